@@ -0,0 +1,460 @@
+package web
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	bind 负责把请求数据填充到结构体
+	@来源及标签 按以下顺序填充 后者覆盖前者
+	1.default:"10"   默认值 仅填充零值字段 切片用,分隔
+	2.json/xml       Content-Type 为JSON/XML时解码Body 使用encoding/json和encoding/xml的标签
+	3.form:"name"    urlencoded/multipart 表单
+	4.query:"page"   Url 查询参数
+	5.param:"id"     路由路径参数
+
+	type TQuery struct {
+		Id    int64    `param:"id"`
+		Page  int      `query:"page" default:"1"`
+		Tags  []string `query:"tag"`
+		Addr  TAddr    `form:"addr"` // 嵌套结构体 表单字段为 addr.city
+	}
+
+	var lQuery TQuery
+	if err := hd.Bind(&lQuery); err != nil {
+		// err 为 *TBindError 列出每个失败的字段
+	}
+*/
+
+const (
+	bindParam = iota
+	bindQuery
+	bindForm
+	bindSourceCount
+
+	maxBindDepth = 16 // 嵌套结构体的最大层数 防止自引用结构体无限递归
+)
+
+var (
+	bindTags = [bindSourceCount]string{"param", "query", "form"}
+
+	bindCache sync.Map // reflect.Type:*tBindStruct
+	bindLock  sync.Mutex
+
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+type (
+	// 字段绑定错误
+	TFieldError struct {
+		Field  string // 结构体字段路径 如 Addr.City
+		Source string // 来源 default/json/xml/form/query/param
+		Key    string // 参数名
+		Value  string // 原始值
+		Err    error
+	}
+
+	// 绑定错误 包含所有失败的字段
+	TBindError struct {
+		Fields []*TFieldError
+	}
+
+	// 结构体的绑定信息 按类型缓存
+	tBindStruct struct {
+		fields []*tBindField
+	}
+
+	tBindField struct {
+		index     int
+		name      string
+		keys      [bindSourceCount]string
+		def       string
+		hasDef    bool
+		anonymous bool
+		nested    *tBindStruct // 嵌套结构体
+	}
+)
+
+func (self *TFieldError) Error() string {
+	if self.Field == "" {
+		return self.Source + ": " + self.Err.Error()
+	}
+
+	lMsg := self.Field
+	if self.Key != "" {
+		lMsg += " (" + self.Source + " " + self.Key + ")"
+	} else if self.Source != "" {
+		lMsg += " (" + self.Source + ")"
+	}
+	return lMsg + ": " + self.Err.Error()
+}
+
+func (self *TFieldError) Unwrap() error {
+	return self.Err
+}
+
+func (self *TBindError) Error() string {
+	lMsgs := make([]string, len(self.Fields))
+	for i, lField := range self.Fields {
+		lMsgs[i] = lField.Error()
+	}
+	return "bind failed: " + strings.Join(lMsgs, "; ")
+}
+
+func (self *TBindError) add(aErr *TFieldError) {
+	self.Fields = append(self.Fields, aErr)
+}
+
+// 把请求数据填充到dst dst必须是结构体指针
+// 所有字段都会尝试填充 失败的字段汇总在返回的*TBindError中
+func (self *THandler) Bind(dst interface{}) error {
+	lVal := reflect.ValueOf(dst)
+	if lVal.Kind() != reflect.Ptr || lVal.IsNil() || lVal.Elem().Kind() != reflect.Struct {
+		return errors.New("bind target must be a non-nil pointer to struct")
+	}
+	lVal = lVal.Elem()
+	lStruct := getBindStruct(lVal.Type())
+	lErrs := &TBindError{}
+
+	bindDefaults(lVal, lStruct, "", lErrs)
+
+	lType, _, _ := mime.ParseMediaType(self.Request.Header.Get("Content-Type"))
+	switch {
+	case lType == "application/json" || strings.HasSuffix(lType, "+json"):
+		if lData := self.Body().AsBytes(); len(lData) > 0 {
+			if err := json.Unmarshal(lData, dst); err != nil {
+				lErrs.add(jsonFieldError(err))
+			}
+		}
+
+	case lType == "application/xml" || lType == "text/xml" || strings.HasSuffix(lType, "+xml"):
+		if lData := self.Body().AsBytes(); len(lData) > 0 {
+			if err := xml.Unmarshal(lData, dst); err != nil {
+				lErrs.add(&TFieldError{Source: "xml", Err: err})
+			}
+		}
+
+	case lType == "application/x-www-form-urlencoded" || lType == "multipart/form-data":
+		var err error
+		if lType == "multipart/form-data" {
+			err = self.parseMultipartForm()
+		} else {
+			err = self.Request.ParseForm()
+		}
+		if err != nil {
+			lErrs.add(&TFieldError{Source: "form", Err: err})
+		} else {
+			bindValues(lVal, lStruct, bindForm, "", "", func(key string) []string {
+				return self.Request.PostForm[key]
+			}, lErrs)
+		}
+	}
+
+	lQuery := self.Request.URL.Query()
+	bindValues(lVal, lStruct, bindQuery, "", "", func(key string) []string {
+		return lQuery[key]
+	}, lErrs)
+
+	bindValues(lVal, lStruct, bindParam, "", "", func(key string) []string {
+		if v, ok := self.pathParams.params[key]; ok {
+			return []string{v}
+		}
+		return nil
+	}, lErrs)
+
+	if len(lErrs.Fields) > 0 {
+		return lErrs
+	}
+	return nil
+}
+
+// 获得结构体的绑定信息
+func getBindStruct(aType reflect.Type) *tBindStruct {
+	if v, ok := bindCache.Load(aType); ok {
+		return v.(*tBindStruct)
+	}
+
+	bindLock.Lock()
+	defer bindLock.Unlock()
+	return buildBindStruct(aType, map[reflect.Type]*tBindStruct{})
+}
+
+func buildBindStruct(aType reflect.Type, aVisiting map[reflect.Type]*tBindStruct) *tBindStruct {
+	if v, ok := bindCache.Load(aType); ok {
+		return v.(*tBindStruct)
+	}
+
+	// # 自引用的结构体返回正在构建的信息
+	if lStruct, ok := aVisiting[aType]; ok {
+		return lStruct
+	}
+
+	lStruct := &tBindStruct{}
+	aVisiting[aType] = lStruct
+	for i := 0; i < aType.NumField(); i++ {
+		lField := aType.Field(i)
+		// # 未导出的字段跳过 未导出类型的内嵌结构体仍可设置其导出字段
+		if lField.PkgPath != "" && !(lField.Anonymous && lField.Type.Kind() == reflect.Struct) {
+			continue
+		}
+
+		lBind := &tBindField{
+			index:     i,
+			name:      lField.Name,
+			anonymous: lField.Anonymous,
+		}
+		for lSource, lTag := range bindTags {
+			lBind.keys[lSource] = strings.Split(lField.Tag.Get(lTag), ",")[0]
+		}
+		lBind.def, lBind.hasDef = lField.Tag.Lookup("default")
+
+		lType := lField.Type
+		if lType.Kind() == reflect.Ptr {
+			lType = lType.Elem()
+		}
+		if lType.Kind() == reflect.Struct && !isBindScalar(lType) {
+			lBind.nested = buildBindStruct(lType, aVisiting)
+		} else if lField.PkgPath != "" {
+			continue
+		}
+
+		lStruct.fields = append(lStruct.fields, lBind)
+	}
+
+	bindCache.Store(aType, lStruct)
+	return lStruct
+}
+
+// 填充默认值
+func bindDefaults(aVal reflect.Value, aStruct *tBindStruct, aPath string, aErrs *TBindError) {
+	for _, lField := range aStruct.fields {
+		lVal := aVal.Field(lField.index)
+		lPath := fieldPath(aPath, lField)
+		if lField.nested != nil {
+			if lVal.Kind() == reflect.Ptr {
+				if lVal.IsNil() {
+					continue // 指针结构体只在有数据时创建
+				}
+				lVal = lVal.Elem()
+			}
+			bindDefaults(lVal, lField.nested, lPath, aErrs)
+			continue
+		}
+
+		if !lField.hasDef || !lVal.IsZero() {
+			continue
+		}
+
+		lValues := []string{lField.def}
+		if lVal.Kind() == reflect.Slice {
+			lValues = strings.Split(lField.def, ",")
+		}
+		if err := setField(lVal, lValues); err != nil {
+			aErrs.add(&TFieldError{Field: lPath, Source: "default", Value: lField.def, Err: err})
+		}
+	}
+}
+
+// 从一个来源填充字段 返回是否填充了任何字段
+func bindValues(aVal reflect.Value, aStruct *tBindStruct, aSource int, aPrefix, aPath string, aLookup func(string) []string, aErrs *TBindError) bool {
+	if strings.Count(aPath, ".") >= maxBindDepth {
+		return false
+	}
+
+	lSet := false
+	for _, lField := range aStruct.fields {
+		lVal := aVal.Field(lField.index)
+		lPath := fieldPath(aPath, lField)
+		lKey := lField.keys[aSource]
+		if lKey == "-" {
+			continue
+		}
+
+		if lField.nested != nil {
+			lPrefix := aPrefix
+			if lKey != "" {
+				lPrefix = aPrefix + lKey + "."
+			}
+
+			if lVal.Kind() == reflect.Ptr {
+				lNew := lVal
+				if lVal.IsNil() {
+					lNew = reflect.New(lVal.Type().Elem())
+				}
+				if bindValues(lNew.Elem(), lField.nested, aSource, lPrefix, lPath, aLookup, aErrs) {
+					lVal.Set(lNew)
+					lSet = true
+				}
+				continue
+			}
+
+			if bindValues(lVal, lField.nested, aSource, lPrefix, lPath, aLookup, aErrs) {
+				lSet = true
+			}
+			continue
+		}
+
+		if lKey == "" {
+			continue
+		}
+
+		lValues := aLookup(aPrefix + lKey)
+		if len(lValues) == 0 {
+			continue
+		}
+
+		lSet = true
+		if err := setField(lVal, lValues); err != nil {
+			aErrs.add(&TFieldError{
+				Field:  lPath,
+				Source: bindTags[aSource],
+				Key:    aPrefix + lKey,
+				Value:  strings.Join(lValues, ","),
+				Err:    err,
+			})
+		}
+	}
+
+	return lSet
+}
+
+func fieldPath(aPath string, aField *tBindField) string {
+	if aField.anonymous && aField.nested != nil {
+		return aPath
+	}
+	if aPath == "" {
+		return aField.name
+	}
+	return aPath + "." + aField.name
+}
+
+// 是否作为单个值处理的类型
+func isBindScalar(aType reflect.Type) bool {
+	return aType == timeType || reflect.PtrTo(aType).Implements(textUnmarshalerType)
+}
+
+// 设置字段值 切片使用所有值 其他类型使用第一个值
+func setField(aVal reflect.Value, aValues []string) error {
+	if aVal.Kind() == reflect.Slice && !isBindScalar(aVal.Type()) && aVal.Type().Elem().Kind() != reflect.Uint8 {
+		lSlice := reflect.MakeSlice(aVal.Type(), 0, len(aValues))
+		for _, lValue := range aValues {
+			lElem := reflect.New(aVal.Type().Elem()).Elem()
+			if err := setScalar(lElem, lValue); err != nil {
+				return err
+			}
+			lSlice = reflect.Append(lSlice, lElem)
+		}
+		aVal.Set(lSlice)
+		return nil
+	}
+
+	return setScalar(aVal, aValues[0])
+}
+
+func setScalar(aVal reflect.Value, aValue string) error {
+	if aVal.Kind() == reflect.Ptr {
+		lNew := reflect.New(aVal.Type().Elem())
+		if err := setScalar(lNew.Elem(), aValue); err != nil {
+			return err
+		}
+		aVal.Set(lNew)
+		return nil
+	}
+
+	// # 非字符串的空值视为未提供 如表单中未填写的数字输入框
+	if aValue == "" && aVal.Kind() != reflect.String {
+		return nil
+	}
+
+	if aVal.Type() == timeType {
+		for _, lLayout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(lLayout, aValue); err == nil {
+				aVal.Set(reflect.ValueOf(t))
+				return nil
+			}
+		}
+		return errors.New("invalid time " + strconv.Quote(aValue))
+	}
+
+	if aVal.CanAddr() && aVal.Addr().Type().Implements(textUnmarshalerType) {
+		return aVal.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(aValue))
+	}
+
+	switch aVal.Kind() {
+	case reflect.String:
+		aVal.SetString(aValue)
+	case reflect.Bool:
+		if aValue == "on" { // checkbox
+			aVal.SetBool(true)
+			return nil
+		}
+		b, err := strconv.ParseBool(aValue)
+		if err != nil {
+			return numError(err)
+		}
+		aVal.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if aVal.Type() == durationType {
+			d, err := time.ParseDuration(aValue)
+			if err != nil {
+				return err
+			}
+			aVal.SetInt(int64(d))
+			return nil
+		}
+		i, err := strconv.ParseInt(aValue, 10, aVal.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		aVal.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(aValue, 10, aVal.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		aVal.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(aValue, aVal.Type().Bits())
+		if err != nil {
+			return numError(err)
+		}
+		aVal.SetFloat(f)
+	default:
+		return errors.New("unsupported type " + aVal.Type().String())
+	}
+
+	return nil
+}
+
+// 去掉strconv错误中重复的函数名和值
+func numError(err error) error {
+	var lNumErr *strconv.NumError
+	if errors.As(err, &lNumErr) {
+		return lNumErr.Err
+	}
+	return err
+}
+
+func jsonFieldError(err error) *TFieldError {
+	var lTypeErr *json.UnmarshalTypeError
+	if errors.As(err, &lTypeErr) {
+		return &TFieldError{
+			Field:  lTypeErr.Field,
+			Source: "json",
+			Value:  lTypeErr.Value,
+			Err:    errors.New("cannot unmarshal " + lTypeErr.Value + " into " + lTypeErr.Type.String()),
+		}
+	}
+	return &TFieldError{Source: "json", Err: err}
+}
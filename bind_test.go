@@ -0,0 +1,147 @@
+package web
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testBindAddr struct {
+	City string `form:"city" json:"city"`
+	Zip  int    `form:"zip" json:"zip"`
+}
+
+type testBindPage struct {
+	Page int `query:"page" default:"1"`
+	Size int `query:"size" default:"20"`
+}
+
+type testBindNode struct {
+	Name string        `query:"name"`
+	Next *testBindNode `query:"next"`
+}
+
+type testBindUser struct {
+	testBindPage
+	Id       int64         `param:"id"`
+	Name     string        `form:"name" json:"name" xml:"name"`
+	Tags     []string      `query:"tag" default:"a,b"`
+	Ids      []int         `query:"ids"`
+	Active   bool          `form:"active"`
+	Birthday time.Time     `form:"birthday"`
+	Timeout  time.Duration `query:"timeout" default:"5s"`
+	Score    *float64      `query:"score"`
+	Addr     testBindAddr  `form:"addr" json:"addr"`
+	Office   *testBindAddr `form:"office"`
+	Node     testBindNode  `query:"node"`
+	Ignored  string        `form:"-"`
+	secret   string
+}
+
+func TestBind(t *testing.T) {
+	var user testBindUser
+	var bindErr error
+	m := NewModule(nil, "m")
+	m.Url("/user/(:id)", func(hd *THandler) {
+		user = testBindUser{}
+		bindErr = hd.Bind(&user)
+	})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	bind := func(method, target, ctype, body string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if ctype != "" {
+			req.Header.Set("Content-Type", ctype)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Url参数 查询参数 默认值
+	bind("GET", "/user/42?tag=x&tag=y&ids=1&ids=2&score=1.5&size=5&node.name=n1&node.next.name=n2", "", "")
+	if bindErr != nil {
+		t.Fatal(bindErr)
+	}
+	if user.Id != 42 || user.Page != 1 || user.Size != 5 || user.Timeout != 5*time.Second {
+		t.Errorf("unexpected scalars %+v", user)
+	}
+	if strings.Join(user.Tags, ",") != "x,y" || len(user.Ids) != 2 || user.Ids[1] != 2 {
+		t.Errorf("unexpected slices %v %v", user.Tags, user.Ids)
+	}
+	if user.Score == nil || *user.Score != 1.5 {
+		t.Errorf("unexpected pointer %v", user.Score)
+	}
+	if user.Node.Name != "n1" || user.Node.Next == nil || user.Node.Next.Name != "n2" || user.Node.Next.Next != nil {
+		t.Errorf("unexpected nested query %+v", user.Node)
+	}
+	if user.Office != nil {
+		t.Errorf("expect nil nested pointer without data")
+	}
+
+	bind("GET", "/user/1", "", "")
+	if strings.Join(user.Tags, ",") != "a,b" {
+		t.Errorf("expect default slice but got %v", user.Tags)
+	}
+
+	// 表单
+	form := url.Values{
+		"name":        {"Tom"},
+		"active":      {"on"},
+		"birthday":    {"2000-01-02"},
+		"addr.city":   {"Paris"},
+		"office.zip":  {"75001"},
+		"Ignored":     {"x"},
+		"secret":      {"x"},
+		"addr.zip":    {""},
+		"unknown.key": {"1"},
+	}
+	bind("POST", "/user/7", "application/x-www-form-urlencoded", form.Encode())
+	if bindErr != nil {
+		t.Fatal(bindErr)
+	}
+	if user.Id != 7 || user.Name != "Tom" || !user.Active || user.Birthday.Day() != 2 || user.Addr.City != "Paris" {
+		t.Errorf("unexpected form binding %+v", user)
+	}
+	if user.Office == nil || user.Office.Zip != 75001 || user.Ignored != "" || user.secret != "" {
+		t.Errorf("unexpected nested form binding %+v", user.Office)
+	}
+
+	// JSON 和 XML
+	bind("PUT", "/user/3?page=2", "application/json; charset=utf-8", `{"name":"Ann","addr":{"city":"Rome","zip":100}}`)
+	if bindErr != nil || user.Name != "Ann" || user.Addr.Zip != 100 || user.Page != 2 || user.Id != 3 {
+		t.Errorf("unexpected json binding %+v %v", user, bindErr)
+	}
+	bind("PUT", "/user/3", "application/xml", `<user><name>Bob</name></user>`)
+	if bindErr != nil || user.Name != "Bob" {
+		t.Errorf("unexpected xml binding %+v %v", user, bindErr)
+	}
+
+	// 错误列出所有字段
+	bind("POST", "/user/abc?page=x&ids=1&ids=y", "application/x-www-form-urlencoded", "birthday=tomorrow&addr.zip=z")
+	var lErr *TBindError
+	if !errors.As(bindErr, &lErr) {
+		t.Fatalf("expect *TBindError but got %v", bindErr)
+	}
+	fields := map[string]string{}
+	for _, f := range lErr.Fields {
+		fields[f.Field] = f.Source
+	}
+	expect := map[string]string{"Id": "param", "Page": "query", "Ids": "query", "Birthday": "form", "Addr.Zip": "form"}
+	for field, source := range expect {
+		if fields[field] != source {
+			t.Errorf("expect error of %s from %s but got %v", field, source, fields)
+		}
+	}
+
+	bind("POST", "/user/1", "application/json", `{"addr":{"zip":"x"}}`)
+	if !errors.As(bindErr, &lErr) || len(lErr.Fields) != 1 || lErr.Fields[0].Field != "addr.zip" {
+		t.Errorf("expect json field error but got %v", bindErr)
+	}
+
+	if err := NewHandler().Bind(user); err == nil {
+		t.Errorf("expect error for non pointer target")
+	}
+}
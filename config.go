@@ -31,9 +31,12 @@ type (
 		EnabledTLS            bool   `ini:"enabled_tls"`
 		TLSCertFile           string `ini:"tls_cert_file"`
 		TLSKeyFile            string `ini:"tls_key_file"`
-		CookieSecret          string
+		CookieSecret          string // 签名/加密Cookie的密钥 多个以逗号分隔 第一个用于新Cookie 其余用于轮换期间验证旧Cookie
 		DefaultDateFormat     string `ini:default_date_format`
 		DefaultDateTimeFormat string `ini:default_date_time_format`
+		UseI18N               bool   `ini:"use_i18n"`   // 启用国际化 由语言文件夹翻译验证消息和模板的trans
+		LocaleDir             string `ini:"locale_dir"` // 语言文件夹 相对于程序文件夹 每个文件为一种语言的JSON
+		LangCode              string `ini:"lang_code"`  // 默认语言
 
 		/*
 			ModuleDir             string `ini:"module_dir"` //模块,程序块目录
@@ -51,7 +54,9 @@ const (
 	MODULE_DIR       = "module" // # 模块文件夹名称
 	DATA_DIR         = "data"
 	STATIC_DIR       = "static"
+	UPLOAD_DIR       = "uploads" // # 上传文件夹 位于程序文件夹内 不在static文件夹内
 	TEMPLATE_DIR     = "template"
+	LOCALE_DIR       = "locale" // # 语言文件夹 文件名为语言代码 如 zh en
 	CSS_DIR          = "css"
 	JS_DIR           = "js"
 	IMG_DIR          = "img"
@@ -128,6 +133,8 @@ func NewConfig(file_name ...string) *TConfig {
 		TLSKeyFile:            "",
 		DefaultDateFormat:     "2006-01-02",
 		DefaultDateTimeFormat: "2006-01-02 15:04:05",
+		LocaleDir:             LOCALE_DIR,
+		LangCode:              "en",
 	}
 
 	if len(file_name) != 0 {
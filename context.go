@@ -0,0 +1,231 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+/*
+	context 负责请求的 context.Context 和路由时限
+	@hd.Context() 来自 Request 客户端断开或超时后被取消 耗时操作应传递并检查它
+	@中间件可用 hd.SetValue 添加值 之后的控制器用 hd.Value 读取
+	@route.Timeout 设置路由时限 控制器在独立的goroutine中执行 超时后取消Context
+		尚未写出响应时返回 503(或指定的状态码) 之后控制器的写出被丢弃
+
+	m.Get("/report", func(hd *THandler) {
+		rows, err := db.QueryContext(hd.Context(), "...")
+	}).Timeout(5 * time.Second)
+*/
+
+type (
+	// 有时限路由的 ResponseWriter 超时后不再写出到连接
+	// 响应头保存在自己的Header中 写出响应头时才复制 避免与超时响应并发修改
+	tTimeoutWriter struct {
+		w      *TResponseWriter
+		header http.Header
+		status int
+		size   int
+
+		beforeWriteHeader []func()
+
+		lock     sync.Mutex
+		timedOut bool
+	}
+)
+
+// 本次请求的Context
+func (self *THandler) Context() context.Context {
+	return self.Request.Context()
+}
+
+// 替换本次请求的Context 须由传入的Context派生 否则将失去取消信号
+func (self *THandler) SetContext(ctx context.Context) {
+	if ctx == nil {
+		logger.Panic("the context must not be nil!")
+	}
+	self.Request = self.Request.WithContext(ctx)
+}
+
+// 添加Context值 供之后的中间件和控制器读取
+func (self *THandler) SetValue(key, value interface{}) {
+	self.SetContext(context.WithValue(self.Context(), key, value))
+}
+
+func (self *THandler) Value(key interface{}) interface{} {
+	return self.Context().Value(key)
+}
+
+// 设置路由的执行时限 需在注册模块前设置
+// 超时时尚未写出响应则返回 status 默认503
+func (self *TRoute) Timeout(d time.Duration, status ...int) *TRoute {
+	self.timeout = d
+	self.timeoutStatus = http.StatusServiceUnavailable
+	if len(status) > 0 {
+		self.timeoutStatus = status[0]
+	}
+	return self
+}
+
+// 在时限内执行控制器 超时或客户端断开时立即返回
+// 超时先标记Writer再取消Context 控制器收到取消信号时已无法写出
+func (self *TRouter) routeTimeout(hd *THandler, w *TResponseWriter) {
+	lWriter := &tTimeoutWriter{w: w, header: http.Header{}}
+	lSettings := hd.Route.settings()
+	lStatus := lSettings.timeoutStatus // hd可能在超时后被回收 须先取出
+	lCtx, lCancel := context.WithCancel(hd.Context())
+	defer lCancel()
+	lTimer := time.AfterFunc(lSettings.timeout, func() {
+		lWriter.timeout(lStatus)
+		lCancel()
+	})
+	defer lTimer.Stop()
+
+	hd.Request = hd.Request.WithContext(lCtx)
+	hd.Response = lWriter
+	hd.IResponseWriter = lWriter
+
+	lDone := make(chan struct{})
+	lPanic := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				if lWriter.isTimedOut() {
+					logger.Err("controller panic after timeout: %v", err)
+				}
+				lPanic <- err
+				return
+			}
+			close(lDone)
+		}()
+
+		self.routeCtrls(hd, reflect.ValueOf(lWriter))
+		self.handlerPool.Put(hd) // 超时后仍由控制器goroutine回收
+	}()
+
+	select {
+	case err := <-lPanic:
+		panic(err)
+	case <-lDone:
+	case <-lCtx.Done():
+		lTimer.Stop()
+		lWriter.timeout(0) // 超时已返回 或客户端已断开无需返回
+	}
+}
+
+func (self *tTimeoutWriter) Header() http.Header {
+	return self.header
+}
+
+func (self *tTimeoutWriter) WriteHeader(s int) {
+	self.runBeforeWriteHeader()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.timedOut || self.status != 0 {
+		return
+	}
+
+	self.status = s
+	copyHeader(self.w.Header(), self.header)
+	self.w.WriteHeader(s)
+}
+
+func (self *tTimeoutWriter) Write(b []byte) (int, error) {
+	if !self.Written() {
+		self.WriteHeader(http.StatusOK)
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	size, err := self.w.Write(b)
+	self.size += size
+	return size, err
+}
+
+// 添加写出响应头前执行的函数 此时仍可修改响应头
+func (self *tTimeoutWriter) BeforeWriteHeader(fn func()) {
+	self.beforeWriteHeader = append(self.beforeWriteHeader, fn)
+}
+
+func (self *tTimeoutWriter) runBeforeWriteHeader() {
+	if lFuncs := self.beforeWriteHeader; lFuncs != nil {
+		self.beforeWriteHeader = nil
+		for _, fn := range lFuncs {
+			fn()
+		}
+	}
+}
+
+// 超时后视为已写出 后续控制器不再执行
+func (self *tTimeoutWriter) Written() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.status != 0 || self.timedOut
+}
+
+func (self *tTimeoutWriter) Status() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.status
+}
+
+func (self *tTimeoutWriter) Size() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.size
+}
+
+func (self *tTimeoutWriter) Flush() {
+	if !self.Written() {
+		self.WriteHeader(http.StatusOK)
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if !self.timedOut {
+		self.w.Flush()
+	}
+}
+
+func (self *tTimeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+
+	conn, buf, err := self.w.Hijack()
+	if err == nil {
+		self.status = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+func (self *tTimeoutWriter) isTimedOut() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.timedOut
+}
+
+// 标记超时 尚未写出时以 aStatus 返回
+func (self *tTimeoutWriter) timeout(aStatus int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.timedOut {
+		return
+	}
+	self.timedOut = true
+
+	if self.status == 0 && aStatus != 0 {
+		self.status = aStatus
+		http.Error(self.w, http.StatusText(aStatus)+": request timeout", aStatus)
+	}
+}
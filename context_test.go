@@ -0,0 +1,89 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type tUserMiddleware struct{}
+
+type tUserKey struct{}
+
+func (self *tUserMiddleware) Request(controller interface{}, hd *THandler) {
+	hd.SetValue(tUserKey{}, "tom")
+}
+
+func (self *tUserMiddleware) Response(controller interface{}, hd *THandler) {}
+func (self *tUserMiddleware) Panic(controller interface{}, hd *THandler)    {}
+
+func TestContextTimeout(t *testing.T) {
+	var user interface{}
+	canceled := make(chan error, 1)
+	m := NewModule(nil, "m")
+	m.Get("/slow", func(hd *THandler) {
+		<-hd.Context().Done()
+		canceled <- hd.Context().Err()
+		hd.RespondString("late")
+	}).Timeout(20*time.Millisecond, http.StatusGatewayTimeout)
+	m.Get("/fast", func(hd *THandler) {
+		user = hd.Value(tUserKey{})
+		hd.Header().Set("X-Fast", "1")
+		hd.RespondString("ok")
+	}).Timeout(time.Second)
+	router := NewRouter()
+	router.RegisterMiddleware(&tUserMiddleware{})
+	router.RegisterModule(m)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expect 504 but got %d %q", w.Code, w.Body.String())
+	}
+	select {
+	case err := <-canceled:
+		if err == nil {
+			t.Errorf("expect context error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expect context canceled")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok" || w.Header().Get("X-Fast") != "1" {
+		t.Errorf("unexpected response %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	if user != "tom" {
+		t.Errorf("expect context value set by middleware but got %v", user)
+	}
+}
+
+// 路由与其他模块的Hook合并后 返回的路由上的设置仍然生效
+func TestContextTimeoutWithHooks(t *testing.T) {
+	hooks := NewModule(nil, "hooks")
+	hooks.HookBefore(nil, "/slow", func(hd *THandler) {})
+	m := NewModule(nil, "m")
+	m.HookAfter(nil, "/slow", func(hd *THandler) {})
+	route := m.Get("/slow", func(hd *THandler) {
+		select {
+		case <-hd.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	route.Name = "slow"
+	router := NewRouter()
+	router.RegisterModule(hooks)
+	router.RegisterModule(m)
+	route.Timeout(20*time.Millisecond, http.StatusGatewayTimeout)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expect 504 but got %d %q", w.Code, w.Body.String())
+	}
+	if !router.UnregisterRoute("slow") {
+		t.Errorf("expect route found by name")
+	}
+}
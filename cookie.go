@@ -0,0 +1,209 @@
+package web
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+/*
+	cookie 负责读写Cookie
+	@签名Cookie 值可读 但不能被客户端篡改
+	@加密Cookie 值不可读也不能被篡改 使用AES-GCM
+	@密钥来自 Config.CookieSecret 多个密钥以逗号分隔 第一个用于新Cookie 全部用于验证
+	 轮换时把新密钥加在最前面 旧Cookie过期后再移除旧密钥
+
+	hd.SetCookie(&http.Cookie{Name: "theme", Value: "dark", MaxAge: 86400, SameSite: http.SameSiteLaxMode})
+	hd.SetSignedCookie(&http.Cookie{Name: "uid", Value: "42", HttpOnly: true})
+	lUid, err := hd.GetSignedCookie("uid")
+*/
+
+var (
+	ErrNoCookieSecret = errors.New("cookie secret is not configured")
+	ErrInvalidCookie  = errors.New("invalid cookie")
+)
+
+// 添加Cookie Path为空时为"/" SameSite=None 时强制Secure 不修改传入的Cookie
+func (self *THandler) SetCookie(cookie *http.Cookie) {
+	lCookie := *cookie // 调用方可能复用同一个Cookie模板
+	if lCookie.Path == "" {
+		lCookie.Path = "/"
+	}
+	if lCookie.SameSite == http.SameSiteNoneMode {
+		lCookie.Secure = true // 浏览器拒绝非Secure的SameSite=None
+	}
+	if err := lCookie.Valid(); err != nil {
+		logger.Err("set cookie %q faild: %s", lCookie.Name, err.Error())
+		return
+	}
+
+	self.Header().Add("Set-Cookie", lCookie.String())
+	if lCookie.MaxAge < 0 {
+		delete(self.COOKIE, lCookie.Name)
+	} else {
+		self.COOKIE[lCookie.Name] = lCookie.Value
+	}
+}
+
+// 返回请求中的Cookie值 不存在时返回空字符串
+func (self *THandler) GetCookie(name string) string {
+	return self.COOKIE[name]
+}
+
+// 让客户端删除Cookie path需与设置时一致
+func (self *THandler) DeleteCookie(name string, path ...string) {
+	lCookie := &http.Cookie{Name: name, MaxAge: -1}
+	if len(path) > 0 {
+		lCookie.Path = path[0]
+	}
+	self.SetCookie(lCookie)
+}
+
+// 添加签名Cookie 值为 base64(value).base64(hmac)
+func (self *THandler) SetSignedCookie(cookie *http.Cookie) error {
+	lKeys, err := self.cookieKeys()
+	if err != nil {
+		return err
+	}
+
+	lValue := base64.RawURLEncoding.EncodeToString([]byte(cookie.Value))
+	lCookie := *cookie
+	lCookie.Value = lValue + "." + base64.RawURLEncoding.EncodeToString(signCookie(lKeys[0], cookie.Name, lValue))
+	self.SetCookie(&lCookie)
+	return nil
+}
+
+// 返回验证通过的签名Cookie值 不存在时返回 http.ErrNoCookie
+func (self *THandler) GetSignedCookie(name string) (string, error) {
+	lKeys, err := self.cookieKeys()
+	if err != nil {
+		return "", err
+	}
+
+	lRaw, ok := self.COOKIE[name]
+	if !ok {
+		return "", http.ErrNoCookie
+	}
+
+	lValue, lSign, ok := strings.Cut(lRaw, ".")
+	if !ok {
+		return "", ErrInvalidCookie
+	}
+	lMac, err := base64.RawURLEncoding.DecodeString(lSign)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+
+	for _, lKey := range lKeys {
+		if hmac.Equal(lMac, signCookie(lKey, name, lValue)) {
+			lData, err := base64.RawURLEncoding.DecodeString(lValue)
+			if err != nil {
+				return "", ErrInvalidCookie
+			}
+			return string(lData), nil
+		}
+	}
+	return "", ErrInvalidCookie
+}
+
+// 添加加密Cookie Cookie名称作为附加数据 值不能被移到其他Cookie
+func (self *THandler) SetEncryptedCookie(cookie *http.Cookie) error {
+	lKeys, err := self.cookieKeys()
+	if err != nil {
+		return err
+	}
+
+	lAead, err := cookieCipher(lKeys[0])
+	if err != nil {
+		return err
+	}
+	lNonce := make([]byte, lAead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, lNonce); err != nil {
+		return err
+	}
+
+	lCookie := *cookie
+	lCookie.Value = base64.RawURLEncoding.EncodeToString(lAead.Seal(lNonce, lNonce, []byte(cookie.Value), []byte(cookie.Name)))
+	self.SetCookie(&lCookie)
+	return nil
+}
+
+// 返回解密后的Cookie值 不存在时返回 http.ErrNoCookie
+func (self *THandler) GetEncryptedCookie(name string) (string, error) {
+	lKeys, err := self.cookieKeys()
+	if err != nil {
+		return "", err
+	}
+
+	lRaw, ok := self.COOKIE[name]
+	if !ok {
+		return "", http.ErrNoCookie
+	}
+	lData, err := base64.RawURLEncoding.DecodeString(lRaw)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+
+	for _, lKey := range lKeys {
+		lAead, err := cookieCipher(lKey)
+		if err != nil {
+			return "", err
+		}
+		if len(lData) < lAead.NonceSize() {
+			return "", ErrInvalidCookie
+		}
+
+		lNonce, lCipher := lData[:lAead.NonceSize()], lData[lAead.NonceSize():]
+		if lPlain, err := lAead.Open(nil, lNonce, lCipher, []byte(name)); err == nil {
+			return string(lPlain), nil
+		}
+	}
+	return "", ErrInvalidCookie
+}
+
+// 从配置取得密钥 第一个为当前密钥
+func (self *THandler) cookieKeys() ([]string, error) {
+	if self.Router == nil || self.Router.Server == nil || self.Router.Server.Config == nil {
+		return nil, ErrNoCookieSecret
+	}
+
+	var lKeys []string
+	for _, lKey := range strings.Split(self.Router.Server.Config.CookieSecret, ",") {
+		if lKey = strings.TrimSpace(lKey); lKey != "" {
+			lKeys = append(lKeys, lKey)
+		}
+	}
+	if len(lKeys) == 0 {
+		return nil, ErrNoCookieSecret
+	}
+	return lKeys, nil
+}
+
+// 签名包含Cookie名称 防止值被移到其他Cookie
+func signCookie(aKey, aName, aValue string) []byte {
+	h := hmac.New(sha256.New, deriveCookieKey(aKey, "sign"))
+	h.Write([]byte(aName + "=" + aValue))
+	return h.Sum(nil)
+}
+
+func cookieCipher(aKey string) (cipher.AEAD, error) {
+	lBlock, err := aes.NewCipher(deriveCookieKey(aKey, "encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(lBlock)
+}
+
+// 签名和加密使用不同的派生密钥
+func deriveCookieKey(aKey, aPurpose string) []byte {
+	h := hmac.New(sha256.New, []byte(aKey))
+	h.Write([]byte("cookie-" + aPurpose))
+	return h.Sum(nil)
+}
@@ -0,0 +1,90 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCookie(t *testing.T) {
+	var got map[string]string
+	var gotErr map[string]error
+	m := NewModule(nil, "m")
+	theme := &http.Cookie{Name: "theme", Value: "dark", MaxAge: 60, SameSite: http.SameSiteNoneMode}
+	m.Get("/set", func(hd *THandler) {
+		hd.SetCookie(theme)
+		hd.SetSignedCookie(&http.Cookie{Name: "uid", Value: "42 ; x", HttpOnly: true})
+		hd.SetEncryptedCookie(&http.Cookie{Name: "secret", Value: "token"})
+		hd.DeleteCookie("old")
+	})
+	m.Get("/get", func(hd *THandler) {
+		got, gotErr = map[string]string{}, map[string]error{}
+		got["theme"] = hd.GetCookie("theme")
+		got["uid"], gotErr["uid"] = hd.GetSignedCookie("uid")
+		got["secret"], gotErr["secret"] = hd.GetEncryptedCookie("secret")
+		_, gotErr["none"] = hd.GetSignedCookie("none")
+	})
+	router := NewRouter()
+	router.Server = &TServer{Config: &TConfig{CookieSecret: "new-key"}}
+	router.RegisterModule(m)
+
+	w := serveTest(router, "GET", "/set")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 4 {
+		t.Fatalf("expect 4 cookies but got %v", w.Header()["Set-Cookie"])
+	}
+	if c := cookies[0]; c.Path != "/" || !c.Secure || c.SameSite != http.SameSiteNoneMode || c.MaxAge != 60 {
+		t.Errorf("unexpected cookie %v", c.String())
+	}
+	// 默认值不写回调用方的Cookie
+	if theme.Path != "" || theme.Secure {
+		t.Errorf("expect caller cookie unchanged but got %v", theme.String())
+	}
+	if c := cookies[3]; c.Name != "old" || c.MaxAge != -1 {
+		t.Errorf("expect delete cookie but got %v", c.String())
+	}
+	if strings.Contains(cookies[2].Value, "token") {
+		t.Errorf("expect encrypted value but got %q", cookies[2].Value)
+	}
+
+	get := func(cookies []*http.Cookie) {
+		req := httptest.NewRequest("GET", "/get", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// 轮换后旧密钥仍可验证
+	router.Server.Config.CookieSecret = "newer-key, new-key"
+	get(cookies)
+	if got["theme"] != "dark" || got["uid"] != "42 ; x" || got["secret"] != "token" || gotErr["uid"] != nil || gotErr["secret"] != nil {
+		t.Errorf("unexpected cookies %v %v", got, gotErr)
+	}
+	if gotErr["none"] != http.ErrNoCookie {
+		t.Errorf("expect ErrNoCookie but got %v", gotErr["none"])
+	}
+
+	// 篡改 移动到其他名称 移除密钥
+	tampered := []*http.Cookie{
+		{Name: "uid", Value: strings.Replace(cookies[1].Value, "NDI", "NDM", 1)},
+		{Name: "secret", Value: cookies[1].Value},
+	}
+	get(tampered)
+	if gotErr["uid"] != ErrInvalidCookie || gotErr["secret"] != ErrInvalidCookie {
+		t.Errorf("expect ErrInvalidCookie but got %v", gotErr)
+	}
+
+	router.Server.Config.CookieSecret = "newer-key"
+	get(cookies)
+	if gotErr["uid"] != ErrInvalidCookie || gotErr["secret"] != ErrInvalidCookie {
+		t.Errorf("expect ErrInvalidCookie after key removed but got %v", gotErr)
+	}
+
+	router.Server.Config.CookieSecret = ""
+	get(cookies)
+	if gotErr["uid"] != ErrNoCookieSecret {
+		t.Errorf("expect ErrNoCookieSecret but got %v", gotErr["uid"])
+	}
+}
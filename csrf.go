@@ -0,0 +1,199 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+/*
+	csrf 负责跨站请求伪造防护
+	@CSRF_SESSION 同步令牌 令牌保存在会话中
+	@CSRF_COOKIE  双重提交 令牌保存在Cookie中 不需要会话 AJAX可从Cookie读取令牌
+	@GET/HEAD/OPTIONS/TRACE 不验证 其他请求从 X-CSRF-Token 头或 csrf_token 表单字段取得令牌
+
+	router.RegisterMiddleware(NewCsrf(CSRF_SESSION).Exempt("/api/webhook", "/api/public/*"))
+
+	@渲染任何模板(包括错误页面)前 路由把取得令牌的函数添加到模板参数 csrf_token
+	令牌在模板调用 csrf_token/csrf_field 或控制器调用 hd.CsrfToken() 时才生成
+	不使用令牌的页面不会因此创建会话 布局和片段只要收到同一模板参数即可使用
+	<form method="post">{{csrf_field .}}...</form>
+	<meta name="csrf-token" content="{{csrf_token .}}">
+*/
+
+const (
+	CSRF_SESSION = iota
+	CSRF_COOKIE
+
+	CSRF_FIELD       = "csrf_token"   // 表单字段名 也是模板变量名
+	CSRF_HEADER      = "X-CSRF-Token" // AJAX请求头
+	CSRF_COOKIE_NAME = "_csrf"
+	CSRF_SESSION_KEY = "_csrf"
+)
+
+var (
+	ErrCsrfMissing = errors.New("CSRF token missing")
+	ErrCsrfInvalid = errors.New("CSRF token invalid")
+
+	csrfMiddlewareName = reflect.TypeOf(TCsrf{}).String()
+)
+
+// CSRF中间件
+type TCsrf struct {
+	Mode       int
+	CookieName string // 双重提交模式的Cookie名称
+	exempt     []string
+}
+
+func NewCsrf(mode ...int) *TCsrf {
+	lCsrf := &TCsrf{
+		Mode:       CSRF_SESSION,
+		CookieName: CSRF_COOKIE_NAME,
+	}
+	if len(mode) > 0 {
+		lCsrf.Mode = mode[0]
+	}
+	return lCsrf
+}
+
+// 不验证的路径 以*结尾时为前缀
+func (self *TCsrf) Exempt(paths ...string) *TCsrf {
+	self.exempt = append(self.exempt, paths...)
+	return self
+}
+
+func (self *TCsrf) Request(controller interface{}, hd *THandler) {
+	switch hd.Request.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		if self.Mode == CSRF_COOKIE {
+			self.Token(hd) // 让AJAX可以读取
+		}
+		return
+	}
+	if self.isExempt(hd.Request.URL.Path) {
+		return
+	}
+
+	if err := self.verify(hd); err != nil {
+		hd.Abort(http.StatusForbidden, err.Error())
+	}
+}
+
+// 令牌由路由在渲染模板前添加 见 TRouter.csrf
+func (self *TCsrf) Response(controller interface{}, hd *THandler) {
+}
+
+func (self *TCsrf) Panic(controller interface{}, hd *THandler) {
+}
+
+// 返回本次请求的令牌 不存在时生成
+func (self *TCsrf) Token(hd *THandler) string {
+	lToken := self.stored(hd)
+	if lToken != "" {
+		return lToken
+	}
+
+	lToken = newCsrfToken()
+	if self.Mode == CSRF_COOKIE {
+		hd.SetCookie(&http.Cookie{
+			Name:     self.CookieName,
+			Value:    lToken,
+			Secure:   hd.Request.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	} else {
+		hd.Session().Set(CSRF_SESSION_KEY, lToken)
+	}
+	return lToken
+}
+
+func (self *TCsrf) stored(hd *THandler) string {
+	if self.Mode == CSRF_COOKIE {
+		return hd.GetCookie(self.CookieName)
+	}
+	return hd.Session().GetString(CSRF_SESSION_KEY)
+}
+
+func (self *TCsrf) verify(hd *THandler) error {
+	lToken := hd.Request.Header.Get(CSRF_HEADER)
+	if lToken == "" {
+		if strings.HasPrefix(hd.Request.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := hd.parseMultipartForm(); err != nil {
+				return ErrCsrfMissing
+			}
+		}
+		lToken = hd.Request.PostFormValue(CSRF_FIELD)
+	}
+	if lToken == "" {
+		return ErrCsrfMissing
+	}
+
+	lStored := self.stored(hd)
+	if lStored == "" || !hmac.Equal([]byte(lToken), []byte(lStored)) {
+		return ErrCsrfInvalid
+	}
+	return nil
+}
+
+func (self *TCsrf) isExempt(aPath string) bool {
+	for _, lExempt := range self.exempt {
+		if lPrefix := strings.TrimSuffix(lExempt, "*"); lPrefix != lExempt {
+			if strings.HasPrefix(aPath, lPrefix) {
+				return true
+			}
+		} else if aPath == lExempt {
+			return true
+		}
+	}
+	return false
+}
+
+// 已注册的CSRF中间件 没有时为nil
+func (self *TRouter) csrf() *TCsrf {
+	lCsrf, _ := self.middleware.Get(csrfMiddlewareName).(*TCsrf)
+	return lCsrf
+}
+
+// 返回本次请求的令牌 没有注册CSRF中间件时为空
+func (self *THandler) CsrfToken() string {
+	if lCsrf := self.Router.csrf(); lCsrf != nil {
+		return lCsrf.Token(self)
+	}
+	return ""
+}
+
+// 模板函数 csrf_token 从模板参数中取得令牌 第一次调用时生成
+func CsrfToken(data map[string]interface{}) string {
+	return csrfTokenOf(data)
+}
+
+// 模板函数 csrf_field 生成隐藏的表单字段
+// 参数为模板参数 或令牌/取得令牌的函数(即 .csrf_token)
+func CsrfField(token interface{}) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + CSRF_FIELD + `" value="` + template.HTMLEscapeString(csrfTokenOf(token)) + `">`)
+}
+
+func csrfTokenOf(aValue interface{}) string {
+	switch v := aValue.(type) {
+	case string:
+		return v
+	case func() string:
+		return v()
+	case map[string]interface{}:
+		return csrfTokenOf(v[CSRF_FIELD])
+	}
+	return ""
+}
+
+func newCsrfToken() string {
+	lToken := make([]byte, 32)
+	if _, err := rand.Read(lToken); err != nil {
+		logger.Panic("generate csrf token faild: %s", err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(lToken)
+}
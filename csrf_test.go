@@ -0,0 +1,144 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/VectorsOrigin/template"
+)
+
+func TestCsrf(t *testing.T) {
+	for _, mode := range []int{CSRF_SESSION, CSRF_COOKIE} {
+		var token string
+		var called int
+		csrf := NewCsrf(mode).Exempt("/hook/*")
+		m := NewModule(nil, "m")
+		m.Get("/form", func(hd *THandler) {
+			token = csrf.Token(hd)
+		})
+		m.Post("/save", func(hd *THandler) { called++ })
+		m.Post("/hook/github", func(hd *THandler) { called++ })
+		router := NewRouter()
+		router.RegisterMiddleware(csrf)
+		router.RegisterModule(m)
+
+		var cookies []*http.Cookie
+		do := func(req *http.Request) *httptest.ResponseRecorder {
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			cookies = append(cookies, w.Result().Cookies()...)
+			return w
+		}
+
+		do(httptest.NewRequest("GET", "/form", nil))
+		if token == "" {
+			t.Fatalf("%d: expect token", mode)
+		}
+
+		if w := do(httptest.NewRequest("POST", "/save", nil)); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), ErrCsrfMissing.Error()) {
+			t.Errorf("%d: expect 403 without token but got %d %q", mode, w.Code, w.Body.String())
+		}
+
+		req := httptest.NewRequest("POST", "/save", nil)
+		req.Header.Set(CSRF_HEADER, "forged")
+		if w := do(req); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), ErrCsrfInvalid.Error()) {
+			t.Errorf("%d: expect 403 with forged token but got %d", mode, w.Code)
+		}
+		if called != 0 {
+			t.Fatalf("%d: controller should not be called", mode)
+		}
+
+		req = httptest.NewRequest("POST", "/save", nil)
+		req.Header.Set(CSRF_HEADER, token)
+		if w := do(req); w.Code != http.StatusOK || called != 1 {
+			t.Errorf("%d: expect header token accepted but got %d", mode, w.Code)
+		}
+
+		req = httptest.NewRequest("POST", "/save", strings.NewReader(url.Values{CSRF_FIELD: {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if w := do(req); w.Code != http.StatusOK || called != 2 {
+			t.Errorf("%d: expect form token accepted but got %d", mode, w.Code)
+		}
+
+		if w := do(httptest.NewRequest("POST", "/hook/github", nil)); w.Code != http.StatusOK || called != 3 {
+			t.Errorf("%d: expect exempt route accepted but got %d", mode, w.Code)
+		}
+	}
+}
+
+func TestCsrfField(t *testing.T) {
+	if s := string(CsrfField(`a"b`)); s != `<input type="hidden" name="csrf_token" value="a&#34;b">` {
+		t.Errorf("unexpected field %s", s)
+	}
+}
+
+func TestCsrfTemplate(t *testing.T) {
+	m := NewModule(nil, "m")
+	m.Templates(fstest.MapFS{
+		"form.html": {Data: []byte(`<meta content="{{csrf_token .}}">{{csrf_field .}}`)},
+	})
+	m.Get("/form", func(hd *THandler) {
+		hd.RenderTemplate("form.html", nil)
+	})
+	router := NewRouter()
+	router.Template = template.NewTemplateSet()
+	router.RegisterMiddleware(NewCsrf(CSRF_COOKIE))
+	router.RegisterModule(m)
+	router.Init()
+
+	w := serveTest(router, "GET", "/form")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CSRF_COOKIE_NAME {
+		t.Fatalf("expect csrf cookie but got %v", cookies)
+	}
+	token := cookies[0].Value
+	if w.Body.String() != `<meta content="`+token+`">`+string(CsrfField(token)) {
+		t.Errorf("expect token in the page but got %q", w.Body.String())
+	}
+}
+
+// 会话模式下 只有使用令牌的页面才创建会话
+func TestCsrfLazyToken(t *testing.T) {
+	m := NewModule(nil, "m")
+	m.Templates(fstest.MapFS{
+		"plain.html": {Data: []byte(`plain`)},
+		"form.html":  {Data: []byte(`{{csrf_field .csrf_token}}`)},
+	})
+	m.Get("/plain", func(hd *THandler) {
+		hd.RenderTemplate("plain.html", nil)
+	})
+	m.Get("/form", func(hd *THandler) {
+		hd.RenderTemplate("form.html", nil)
+	})
+	m.Get("/api/token", func(hd *THandler) {
+		hd.RespondString(hd.CsrfToken())
+	})
+	router := NewRouter()
+	router.Template = template.NewTemplateSet()
+	router.RegisterMiddleware(NewCsrf(CSRF_SESSION))
+	router.RegisterModule(m)
+	router.Init()
+
+	if w := serveTest(router, "GET", "/plain"); w.Body.String() != "plain" || len(w.Result().Cookies()) != 0 {
+		t.Errorf("expect no session for a page without token but got %q %v", w.Body.String(), w.Result().Cookies())
+	}
+
+	w := serveTest(router, "GET", "/form")
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != DEFAULT_SESSION_COOKIE {
+		t.Fatalf("expect session cookie but got %v", cookies)
+	}
+	if !strings.HasPrefix(w.Body.String(), `<input type="hidden" name="csrf_token" value="`) || strings.Contains(w.Body.String(), `value=""`) {
+		t.Errorf("unexpected field %q", w.Body.String())
+	}
+
+	if w := serveTest(router, "GET", "/api/token"); w.Body.String() == "" || len(w.Result().Cookies()) != 1 {
+		t.Errorf("expect token from the controller but got %q", w.Body.String())
+	}
+}
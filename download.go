@@ -0,0 +1,126 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+	download 负责文件下载
+	@支持 Range/If-Range 断点续传 Content-Type 由扩展名决定
+	@文件名按 RFC 6266 同时提供ASCII的filename和UTF-8编码的filename*
+
+	hd.Download("data/report.xlsx")                 // 附件下载
+	hd.Download("data/report.pdf", true)            // 浏览器内打开
+	hd.DownloadBytes("报表.csv", lData)
+	hd.DownloadReader("报表.xlsx", lModTime, lReader)
+*/
+
+// 下载文件 inline 为true时浏览器内打开
+func (self *THandler) Download(file_path string, inline ...bool) error {
+	f, err := os.Open(file_path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		return errors.New("can not download a directory: " + file_path)
+	}
+
+	self.DownloadReader(filepath.Base(file_path), fi.ModTime(), f, inline...)
+	return nil
+}
+
+// 下载内容 modtime 用于 If-Modified-Since 和 If-Range 为零值时不使用
+func (self *THandler) DownloadReader(name string, modtime time.Time, content io.ReadSeeker, inline ...bool) {
+	self.setDownloadHeader(name, inline...)
+	http.ServeContent(self.Response, self.Request, name, modtime, content)
+}
+
+// 下载内存数据 使用内容Hash作为ETag以支持 If-Range
+func (self *THandler) DownloadBytes(name string, data []byte, inline ...bool) {
+	lHash := sha256.Sum256(data)
+	self.Header().Set("Etag", `"`+hex.EncodeToString(lHash[:])+`"`)
+	self.DownloadReader(name, time.Time{}, bytes.NewReader(data), inline...)
+}
+
+func (self *THandler) setDownloadHeader(name string, inline ...bool) {
+	lHeader := self.Header()
+	if lHeader.Get("Content-Type") == "" {
+		// # 未知类型不做内容嗅探
+		lType := mime.TypeByExtension(filepath.Ext(name))
+		if lType == "" {
+			lType = "application/octet-stream"
+		}
+		lHeader.Set("Content-Type", lType)
+	}
+
+	lDisposition := "attachment"
+	if len(inline) > 0 && inline[0] {
+		lDisposition = "inline"
+	}
+	lHeader.Set("Content-Disposition", ContentDisposition(lDisposition, name))
+	lHeader.Set("X-Content-Type-Options", "nosniff")
+}
+
+// 生成RFC 6266的Content-Disposition
+// 非ASCII字符在filename中替换为_ 完整文件名以UTF-8百分号编码放入filename*
+func ContentDisposition(disposition, name string) string {
+	var lAscii, lExt strings.Builder
+	lNeedExt := false
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\':
+			lAscii.WriteByte('_')
+			lNeedExt = true
+		case r < 0x20 || r == 0x7f:
+			// # 控制字符直接丢弃 避免响应头注入
+			lNeedExt = true
+			continue
+		case r > 0x7e:
+			lAscii.WriteByte('_')
+			lNeedExt = true
+		default:
+			lAscii.WriteRune(r)
+		}
+
+		for _, b := range []byte(string(r)) {
+			if isAttrChar(b) {
+				lExt.WriteByte(b)
+			} else {
+				lExt.WriteByte('%')
+				lExt.WriteString(strings.ToUpper(hex.EncodeToString([]byte{b})))
+			}
+		}
+	}
+
+	lValue := disposition + `; filename="` + lAscii.String() + `"`
+	if lNeedExt {
+		lValue += "; filename*=UTF-8''" + lExt.String()
+	}
+	return lValue
+}
+
+// RFC 5987 attr-char
+func isAttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
@@ -0,0 +1,97 @@
+package web
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentDisposition(t *testing.T) {
+	cases := []struct {
+		name   string
+		expect string
+	}{
+		{"report.csv", `attachment; filename="report.csv"`},
+		{"报表 2024.xlsx", `attachment; filename="__ 2024.xlsx"; filename*=UTF-8''%E6%8A%A5%E8%A1%A8%202024.xlsx`},
+		{`a"b\c.txt`, `attachment; filename="a_b_c.txt"; filename*=UTF-8''a%22b%5Cc.txt`},
+		{"a\r\nb.txt", `attachment; filename="ab.txt"; filename*=UTF-8''ab.txt`},
+	}
+	for _, c := range cases {
+		if v := ContentDisposition("attachment", c.name); v != c.expect {
+			t.Errorf("%q: expect %s but got %s", c.name, c.expect, v)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "月报.csv")
+	if err := os.WriteFile(file, []byte("0123456789"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewModule(nil, "m")
+	m.Get("/file", func(hd *THandler) {
+		if err := hd.Download(file); err != nil {
+			t.Error(err)
+		}
+	})
+	m.Get("/inline", func(hd *THandler) {
+		hd.DownloadBytes("report.pdf", []byte("%PDF-data"), true)
+	})
+	m.Get("/missing", func(hd *THandler) {
+		if err := hd.Download(filepath.Join(dir, "missing")); err == nil {
+			t.Error("expect error for missing file")
+		}
+		hd.RespondWithNotFound()
+	})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	serve := func(url string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", url, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/file")
+	if w.Code != 200 || w.Body.String() != "0123456789" {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if cl := w.Header().Get("Content-Length"); cl != "10" {
+		t.Errorf("unexpected content length %q", cl)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="__.csv"; filename*=UTF-8''%E6%9C%88%E6%8A%A5.csv` {
+		t.Errorf("unexpected content disposition %q", cd)
+	}
+
+	// 断点续传
+	w = serve("/file", "Range", "bytes=4-")
+	if w.Code != 206 || w.Body.String() != "456789" {
+		t.Errorf("expect partial content but got %d %q", w.Code, w.Body.String())
+	}
+
+	w = serve("/inline")
+	etag := w.Header().Get("Etag")
+	if w.Header().Get("Content-Disposition") != `inline; filename="report.pdf"` || w.Header().Get("Content-Type") != "application/pdf" {
+		t.Errorf("unexpected inline headers %v", w.Header())
+	}
+	if w = serve("/inline", "Range", "bytes=0-3", "If-Range", etag); w.Code != 206 || w.Body.String() != "%PDF" {
+		t.Errorf("expect partial content for matched If-Range but got %d %q", w.Code, w.Body.String())
+	}
+	if w = serve("/inline", "Range", "bytes=0-3", "If-Range", `"stale"`); w.Code != 200 || w.Body.String() != "%PDF-data" {
+		t.Errorf("expect full content for stale If-Range but got %d %q", w.Code, w.Body.String())
+	}
+
+	if w = serve("/missing"); w.Code != 404 {
+		t.Errorf("expect 404 but got %d", w.Code)
+	}
+}
@@ -0,0 +1,402 @@
+package web
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	encoder 负责把数据编码为各种响应格式
+	@注册表 按MIME类型注册编码器 内容协商和错误响应都从这里取得编码器
+
+	RegisterEncoder("application/toml", &TEncoder{
+		ContentType: "application/toml; charset=utf-8",
+		Encode:      func(w io.Writer, v interface{}) error {...},
+	})
+
+	@流式 Stream 为true的编码器直接写出响应 用于大量数据
+	CSV/JSON Lines 的数据可以是切片、数组、通道或迭代函数 func(yield func(T) bool)
+*/
+
+const (
+	MIME_YAML    = "application/yaml"
+	MIME_MSGPACK = "application/msgpack"
+	MIME_CSV     = "text/csv"
+	MIME_JSONL   = "application/x-ndjson"
+	MIME_PROBLEM = "application/problem+json" // RFC 7807
+)
+
+type (
+	// 响应编码器
+	TEncoder struct {
+		ContentType string // 响应的Content-Type
+		Stream      bool   // 边编码边写出 否则编码完成后才写出
+		Encode      func(w io.Writer, v interface{}) error
+	}
+
+	// 每次写入后刷新
+	tFlushWriter struct {
+		writer  io.Writer
+		flusher http.Flusher
+	}
+
+	// 结构体的编码字段
+	tEncodeField struct {
+		index     []int
+		name      string
+		omitEmpty bool
+	}
+)
+
+var (
+	encoders     = map[string]*TEncoder{}
+	encodersLock sync.RWMutex
+
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func init() {
+	RegisterEncoder(MIME_JSON, &TEncoder{
+		ContentType: "application/json; charset=UTF-8",
+		Encode: func(w io.Writer, v interface{}) error {
+			lJson, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(lJson)
+			return err
+		},
+	})
+	RegisterEncoder(MIME_XML, &TEncoder{
+		ContentType: "application/xml; charset=utf-8",
+		Encode: func(w io.Writer, v interface{}) error {
+			lXml, err := xml.Marshal(v)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(append([]byte(xml.Header), lXml...))
+			return err
+		},
+	})
+	RegisterEncoder(MIME_TEXT, &TEncoder{
+		ContentType: "text/plain; charset=utf-8",
+		Encode: func(w io.Writer, v interface{}) error {
+			_, err := fmt.Fprint(w, v)
+			return err
+		},
+	})
+	RegisterEncoder(MIME_YAML, &TEncoder{
+		ContentType: "application/yaml; charset=utf-8",
+		Encode:      encodeYAML,
+	})
+	RegisterEncoder(MIME_MSGPACK, &TEncoder{
+		ContentType: "application/msgpack",
+		Encode:      encodeMsgPack,
+	})
+	RegisterEncoder(MIME_CSV, &TEncoder{
+		ContentType: "text/csv; charset=utf-8",
+		Stream:      true,
+		Encode:      encodeCSV,
+	})
+	RegisterEncoder(MIME_JSONL, &TEncoder{
+		ContentType: "application/x-ndjson; charset=utf-8",
+		Stream:      true,
+		Encode:      encodeJSONLines,
+	})
+
+	RegisterEncoder(MIME_PROBLEM, &TEncoder{
+		ContentType: MIME_PROBLEM,
+		Encode:      GetEncoder(MIME_JSON).Encode,
+	})
+
+	// 别名
+	RegisterEncoder("text/xml", GetEncoder(MIME_XML))
+	RegisterEncoder("application/x-yaml", GetEncoder(MIME_YAML))
+	RegisterEncoder("application/x-msgpack", GetEncoder(MIME_MSGPACK))
+	RegisterEncoder("application/jsonl", GetEncoder(MIME_JSONL))
+}
+
+// 注册编码器 同一MIME类型的编码器将被替换
+func RegisterEncoder(mime string, encoder *TEncoder) {
+	if mime == "" || encoder == nil || encoder.Encode == nil {
+		logger.Panic("encoder mime and encode func must not be empty!")
+	}
+
+	encodersLock.Lock()
+	encoders[strings.ToLower(mime)] = encoder
+	encodersLock.Unlock()
+}
+
+// 获得MIME类型对应的编码器 未注册时返回nil
+func GetEncoder(mime string) *TEncoder {
+	encodersLock.RLock()
+	defer encodersLock.RUnlock()
+	return encoders[strings.ToLower(mime)]
+}
+
+// 用mime对应的编码器响应v
+// 非流式编码器的错误发生在写出前 调用者可改为响应错误
+func (self *THandler) Encode(mime string, v interface{}) error {
+	lEncoder := GetEncoder(mime)
+	if lEncoder == nil {
+		return errors.New("no encoder registered for " + mime)
+	}
+
+	if lEncoder.Stream {
+		self.Header().Set("Content-Type", lEncoder.ContentType)
+		lWriter := &tFlushWriter{writer: self.Response}
+		lWriter.flusher, _ = self.Response.(http.Flusher)
+		return lEncoder.Encode(lWriter, v)
+	}
+
+	var lBuf bytes.Buffer
+	if err := lEncoder.Encode(&lBuf, v); err != nil {
+		return err
+	}
+
+	self.Header().Set("Content-Type", lEncoder.ContentType)
+	self.Result = lBuf.Bytes()
+	return nil
+}
+
+// 编码失败时响应错误
+func (self *THandler) respondEncoded(mime string, v interface{}) {
+	if err := self.Encode(mime, v); err != nil {
+		logger.Err("encode %s response faild: %s", mime, err.Error())
+		if !self.Response.Written() {
+			self.RespondError(err.Error())
+		}
+	}
+}
+
+func (self *THandler) RespondXML(v interface{}) {
+	self.respondEncoded(MIME_XML, v)
+}
+
+func (self *THandler) RespondYAML(v interface{}) {
+	self.respondEncoded(MIME_YAML, v)
+}
+
+func (self *THandler) RespondMsgPack(v interface{}) {
+	self.respondEncoded(MIME_MSGPACK, v)
+}
+
+// 流式响应CSV 数据的每一项为一行
+// 结构体以字段名(或csv标签)为表头 map以排序后的key为表头 []string等切片直接作为一行
+func (self *THandler) RespondCSV(v interface{}) {
+	self.respondEncoded(MIME_CSV, v)
+}
+
+// 流式响应JSON Lines 每一项一行
+func (self *THandler) RespondJSONLines(v interface{}) {
+	self.respondEncoded(MIME_JSONL, v)
+}
+
+func (self *tFlushWriter) Write(p []byte) (int, error) {
+	n, err := self.writer.Write(p)
+	if self.flusher != nil {
+		self.flusher.Flush()
+	}
+	return n, err
+}
+
+// 遍历切片、数组、通道或迭代函数 func(yield func(T) bool) 其他值视为单项
+func eachItem(v interface{}, fn func(interface{}) error) error {
+	lVal := reflect.ValueOf(v)
+	for lVal.Kind() == reflect.Ptr && !lVal.IsNil() && lVal.Elem().Kind() != reflect.Struct {
+		lVal = lVal.Elem()
+	}
+
+	switch lVal.Kind() {
+	case reflect.Slice, reflect.Array:
+		if lVal.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		for i := 0; i < lVal.Len(); i++ {
+			if err := fn(lVal.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Chan:
+		for {
+			lItem, ok := lVal.Recv()
+			if !ok {
+				return nil
+			}
+			if err := fn(lItem.Interface()); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Func:
+		lType := lVal.Type()
+		if lType.NumIn() != 1 || lType.NumOut() != 0 {
+			break
+		}
+		lYield := lType.In(0)
+		if lYield.Kind() != reflect.Func || lYield.NumIn() != 1 || lYield.NumOut() != 1 || lYield.Out(0).Kind() != reflect.Bool {
+			break
+		}
+
+		var lErr error
+		lVal.Call([]reflect.Value{reflect.MakeFunc(lYield, func(args []reflect.Value) []reflect.Value {
+			if lErr == nil {
+				lErr = fn(args[0].Interface())
+			}
+			return []reflect.Value{reflect.ValueOf(lErr == nil)}
+		})})
+		return lErr
+	}
+
+	return fn(v)
+}
+
+func encodeJSONLines(w io.Writer, v interface{}) error {
+	lEncoder := json.NewEncoder(w)
+	return eachItem(v, func(item interface{}) error {
+		return lEncoder.Encode(item)
+	})
+}
+
+func encodeCSV(w io.Writer, v interface{}) error {
+	lWriter := csv.NewWriter(w)
+	var lHeader []string
+	var lFields []tEncodeField
+	lFirst := true
+	err := eachItem(v, func(item interface{}) error {
+		lVal := reflect.ValueOf(item)
+		for lVal.Kind() == reflect.Ptr || lVal.Kind() == reflect.Interface {
+			if lVal.IsNil() {
+				return nil
+			}
+			lVal = lVal.Elem()
+		}
+
+		var lRow []string
+		switch {
+		case lVal.Kind() == reflect.Struct && lVal.Type() != timeType:
+			if lFirst {
+				lFields = encodeFields(lVal.Type(), "csv")
+				for _, f := range lFields {
+					lHeader = append(lHeader, f.name)
+				}
+			}
+			for _, f := range lFields {
+				lRow = append(lRow, csvValue(lVal.FieldByIndex(f.index)))
+			}
+
+		case lVal.Kind() == reflect.Map && lVal.Type().Key().Kind() == reflect.String:
+			if lFirst {
+				for _, k := range lVal.MapKeys() {
+					lHeader = append(lHeader, k.String())
+				}
+				sort.Strings(lHeader)
+			}
+			for _, k := range lHeader {
+				lRow = append(lRow, csvValue(lVal.MapIndex(reflect.ValueOf(k).Convert(lVal.Type().Key()))))
+			}
+
+		case lVal.Kind() == reflect.Slice || lVal.Kind() == reflect.Array:
+			for i := 0; i < lVal.Len(); i++ {
+				lRow = append(lRow, csvValue(lVal.Index(i)))
+			}
+
+		default:
+			lRow = []string{csvValue(lVal)}
+		}
+
+		if lFirst && lHeader != nil {
+			if err := lWriter.Write(lHeader); err != nil {
+				return err
+			}
+		}
+		lFirst = false
+		return lWriter.Write(lRow)
+	})
+
+	lWriter.Flush()
+	if err != nil {
+		return err
+	}
+	return lWriter.Error()
+}
+
+func csvValue(aVal reflect.Value) string {
+	for aVal.IsValid() && (aVal.Kind() == reflect.Ptr || aVal.Kind() == reflect.Interface) {
+		if aVal.IsNil() {
+			return ""
+		}
+		aVal = aVal.Elem()
+	}
+	if !aVal.IsValid() {
+		return ""
+	}
+
+	if aVal.Type() == timeType {
+		return aVal.Interface().(time.Time).Format(time.RFC3339)
+	}
+	if aVal.Type().Implements(textMarshalerType) {
+		lText, _ := aVal.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(lText)
+	}
+	return fmt.Sprint(aVal.Interface())
+}
+
+// 获得结构体的编码字段 按tags顺序取第一个存在的标签作为名称 内嵌结构体展开
+func encodeFields(aType reflect.Type, tags ...string) []tEncodeField {
+	var lFields []tEncodeField
+	for i := 0; i < aType.NumField(); i++ {
+		lField := aType.Field(i)
+
+		lName, lOmit, lTagged := "", false, false
+		for _, lTag := range tags {
+			if v, ok := lField.Tag.Lookup(lTag); ok {
+				lParts := strings.Split(v, ",")
+				lName, lTagged = lParts[0], true
+				for _, lOpt := range lParts[1:] {
+					lOmit = lOmit || lOpt == "omitempty"
+				}
+				break
+			}
+		}
+		if lName == "-" && lTagged {
+			continue
+		}
+
+		lType := lField.Type
+		if lType.Kind() == reflect.Ptr {
+			lType = lType.Elem()
+		}
+		if lField.Anonymous && lName == "" && lType.Kind() == reflect.Struct && lField.Type.Kind() != reflect.Ptr {
+			for _, f := range encodeFields(lType, tags...) {
+				f.index = append([]int{i}, f.index...)
+				lFields = append(lFields, f)
+			}
+			continue
+		}
+
+		if lField.PkgPath != "" {
+			continue
+		}
+		if lName == "" {
+			lName = lField.Name
+		}
+
+		lFields = append(lFields, tEncodeField{index: []int{i}, name: lName, omitEmpty: lOmit})
+	}
+	return lFields
+}
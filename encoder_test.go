@@ -0,0 +1,179 @@
+package web
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testEncodeAddr struct {
+	City string `json:"city"`
+	Zip  string `yaml:"zip,omitempty"`
+}
+
+type testEncodeUser struct {
+	Id     int               `csv:"id"`
+	Name   string            `csv:"name" yaml:"name"`
+	Tags   []string          `csv:"-"`
+	Addr   testEncodeAddr    `csv:"-"`
+	Meta   map[string]string `csv:"-" yaml:"meta"`
+	secret string
+}
+
+func TestEncodeYAML(t *testing.T) {
+	v := []testEncodeUser{
+		{Id: 1, Name: "Tom", Tags: []string{"a", "yes"}, Addr: testEncodeAddr{City: "New York"}, Meta: map[string]string{"b": "2", "a": "x: y"}},
+		{Id: 2, Name: "", Tags: nil, Meta: map[string]string{}},
+	}
+
+	var buf bytes.Buffer
+	if err := encodeYAML(&buf, v); err != nil {
+		t.Fatal(err)
+	}
+	expect := `- Id: 1
+  name: Tom
+  Tags:
+    - a
+    - "yes"
+  Addr:
+    city: New York
+  meta:
+    a: "x: y"
+    b: "2"
+- Id: 2
+  name: ""
+  Tags: []
+  Addr:
+    city: ""
+  meta: {}
+`
+	if buf.String() != expect {
+		t.Errorf("unexpected yaml:\n%s", buf.String())
+	}
+}
+
+func TestEncodeMsgPack(t *testing.T) {
+	cases := []struct {
+		value  interface{}
+		expect []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{-1, []byte{0xff}},
+		{200, []byte{0xcc, 0xc8}},
+		{-200, []byte{0xd1, 0xff, 0x38}},
+		{70000, []byte{0xce, 0x00, 0x01, 0x11, 0x70}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"abc", []byte{0xa3, 'a', 'b', 'c'}},
+		{[]byte{1, 2}, []byte{0xc4, 0x02, 0x01, 0x02}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+		{struct {
+			Id   int    `json:"id"`
+			Name string `msgpack:"n,omitempty"`
+		}{Id: 1}, []byte{0x81, 0xa2, 'i', 'd', 0x01}},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := encodeMsgPack(&buf, c.value); err != nil {
+			t.Errorf("%v: %v", c.value, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), c.expect) {
+			t.Errorf("%v: expect % x but got % x", c.value, c.expect, buf.Bytes())
+		}
+	}
+
+	// 长字符串使用str8
+	var buf bytes.Buffer
+	encodeMsgPack(&buf, strings.Repeat("x", 40))
+	if !bytes.HasPrefix(buf.Bytes(), []byte{0xd9, 40}) {
+		t.Errorf("expect str8 but got % x", buf.Bytes()[:2])
+	}
+}
+
+func TestRespondStream(t *testing.T) {
+	m := NewModule(nil, "m")
+	m.Get("/users.csv", func(hd *THandler) {
+		hd.RespondCSV(func(yield func(*testEncodeUser) bool) {
+			for i := 1; i <= 3; i++ {
+				if !yield(&testEncodeUser{Id: i, Name: "u,\"" + string(rune('a'+i-1))}) {
+					return
+				}
+			}
+		})
+	})
+	m.Get("/users.jsonl", func(hd *THandler) {
+		lUsers := make(chan map[string]int, 2)
+		lUsers <- map[string]int{"id": 1}
+		lUsers <- map[string]int{"id": 2}
+		close(lUsers)
+		hd.RespondJSONLines(lUsers)
+	})
+	m.Get("/rows.csv", func(hd *THandler) {
+		hd.RespondCSV([][]string{{"a", "b"}, {"1", "2"}})
+	})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	cases := []struct {
+		url   string
+		ctype string
+		body  string
+	}{
+		{"/users.csv", "text/csv; charset=utf-8", "id,name\n1,\"u,\"\"a\"\n2,\"u,\"\"b\"\n3,\"u,\"\"c\"\n"},
+		{"/users.jsonl", "application/x-ndjson; charset=utf-8", "{\"id\":1}\n{\"id\":2}\n"},
+		{"/rows.csv", "text/csv; charset=utf-8", "a,b\n1,2\n"},
+	}
+	for _, c := range cases {
+		w := serveTest(router, "GET", c.url)
+		if w.Code != 200 || w.Header().Get("Content-Type") != c.ctype {
+			t.Errorf("%s: expect 200 %q but got %d %q", c.url, c.ctype, w.Code, w.Header().Get("Content-Type"))
+		}
+		if w.Body.String() != c.body {
+			t.Errorf("%s: unexpected body %q", c.url, w.Body.String())
+		}
+		if !w.Flushed {
+			t.Errorf("%s: expect flushed", c.url)
+		}
+	}
+}
+
+func TestNegotiateEncoder(t *testing.T) {
+	RegisterEncoder("application/x-test", &TEncoder{
+		ContentType: "application/x-test",
+		Encode: func(w io.Writer, v interface{}) error {
+			_, err := io.WriteString(w, "test:"+v.(string))
+			return err
+		},
+	})
+
+	m := NewModule(nil, "m")
+	m.Get("/data", func(hd *THandler) {
+		hd.Negotiate("hello", TNegotiateOptions{Offers: []string{MIME_JSON, MIME_YAML, "application/x-test"}})
+	})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	cases := []struct {
+		accept string
+		ctype  string
+		body   string
+	}{
+		{"application/x-test", "application/x-test", "test:hello"},
+		{"application/yaml", "application/yaml; charset=utf-8", "hello\n"},
+		{"*/*", "application/json; charset=UTF-8", `"hello"`},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/data", nil)
+		req.Header.Set("Accept", c.accept)
+		router.ServeHTTP(w, req)
+
+		if w.Header().Get("Content-Type") != c.ctype || w.Body.String() != c.body {
+			t.Errorf("Accept %q: expect %q %q but got %q %q", c.accept, c.ctype, c.body, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+}
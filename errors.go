@@ -0,0 +1,226 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/VectorsOrigin/utils"
+)
+
+/*
+	errors 负责统一的错误响应
+	@控制器返回的error、Abort、RespondError、RespondWithNotFound和panic都交给 hd.HandleError
+	@处理顺序 路由所属模块(及父模块)的 ErrorHandler > Router.ErrorHandler > hd.RespondWithError
+	@THttpError 带状态码 *TBindError为400 TValidationErrors为422 其他error视为500 且不把错误内容返回给客户端
+	@绑定和验证错误的字段消息作为problem+json的errors成员返回 {"errors":{"Name":"Name is required"}}
+
+	m.ErrorHandler = func(hd *THandler, err error) {
+		metrics.Inc("errors")
+		hd.RespondWithError(err) // 默认的错误响应
+	}
+	return nil, NewHttpError(http.StatusNotFound, "order not found")
+
+	@默认的错误响应按Accept选择
+	1.存在错误页面时可响应HTML 模块的 template/404.html 优先 其次是程序的 template/404.html
+		模板参数为 Status/Title/Detail
+	2.API客户端响应 RFC 7807 application/problem+json
+	3.其他响应纯文本
+*/
+
+type (
+	// 带HTTP状态码的错误
+	THttpError struct {
+		Status int
+		Title  string            // 为空时为状态码的标准说明
+		Detail string            // 返回给客户端的说明
+		Type   string            // 错误类型URI 为空时为 about:blank
+		Err    error             // 原始错误 只记录日志 不返回给客户端
+		Errors map[string]string // 字段路径:消息 作为problem+json的errors成员返回
+	}
+
+	// RFC 7807 problem details
+	TProblem struct {
+		Type     string            `json:"type"`
+		Title    string            `json:"title"`
+		Status   int               `json:"status"`
+		Detail   string            `json:"detail,omitempty"`
+		Instance string            `json:"instance,omitempty"`
+		Errors   map[string]string `json:"errors,omitempty"` // 扩展成员 字段错误
+	}
+)
+
+func NewHttpError(status int, detail ...string) *THttpError {
+	lErr := &THttpError{Status: status}
+	if len(detail) > 0 {
+		lErr.Detail = detail[0]
+	}
+	return lErr
+}
+
+func (self *THttpError) Error() string {
+	lMsg := strconv.Itoa(self.Status) + " " + self.title()
+	if self.Detail != "" {
+		lMsg += ": " + self.Detail
+	}
+	if self.Err != nil {
+		lMsg += ": " + self.Err.Error()
+	}
+	return lMsg
+}
+
+func (self *THttpError) Unwrap() error {
+	return self.Err
+}
+
+func (self *THttpError) title() string {
+	if self.Title != "" {
+		return self.Title
+	}
+	return http.StatusText(self.Status)
+}
+
+// 转换为THttpError 绑定错误为400 验证错误为422 其他错误为500
+func toHttpError(err error) *THttpError {
+	var (
+		lErr     *THttpError
+		lBindErr *TBindError
+		lValErrs TValidationErrors
+	)
+	switch {
+	case errors.As(err, &lErr):
+		return lErr
+	case errors.As(err, &lBindErr):
+		lFields := make(map[string]string, len(lBindErr.Fields))
+		for _, lField := range lBindErr.Fields {
+			lName := lField.Field
+			if lName == "" {
+				lName = lField.Source
+			}
+			lFields[lName] = lField.Error()
+		}
+		return &THttpError{Status: http.StatusBadRequest, Detail: lBindErr.Error(), Err: err, Errors: lFields}
+	case errors.As(err, &lValErrs):
+		return &THttpError{Status: http.StatusUnprocessableEntity, Detail: lValErrs.Error(), Err: err, Errors: lValErrs.Messages()}
+	}
+	return &THttpError{Status: http.StatusInternalServerError, Err: err}
+}
+
+// 交给错误处理器 处理器中再次出错时直接使用默认的错误响应
+func (self *THandler) HandleError(err error) {
+	if err == nil {
+		return
+	}
+	if self.handlingError {
+		self.RespondWithError(err)
+		return
+	}
+	self.handlingError = true
+	defer func() { self.handlingError = false }()
+
+	if self.Route != nil {
+		for lModule := self.Route.module; lModule != nil; lModule = lModule.Parent {
+			if lModule.ErrorHandler != nil {
+				lModule.ErrorHandler(self, err)
+				return
+			}
+		}
+	}
+	if self.Router.ErrorHandler != nil {
+		self.Router.ErrorHandler(self, err)
+		return
+	}
+	self.RespondWithError(err)
+}
+
+// 默认的错误响应 已写出响应时只记录日志
+func (self *THandler) RespondWithError(err error) {
+	lErr := toHttpError(err)
+	if lErr.Status >= http.StatusInternalServerError {
+		logger.Err("%s %s: %s", self.Request.Method, self.Request.URL.Path, lErr.Error())
+	}
+	if self.Response.Written() {
+		return
+	}
+
+	// 丢弃控制器已准备的响应
+	self.TemplateSrc = ""
+	self.Result = nil
+
+	lOffers := []string{MIME_PROBLEM, MIME_JSON, MIME_TEXT}
+	lPage := self.errorPage(lErr.Status)
+	if lPage != "" {
+		lOffers = append([]string{MIME_HTML}, lOffers...)
+	}
+	addVary(self.Header(), "Accept")
+
+	switch NegotiateType(self.Request.Header.Get("Accept"), lOffers) {
+	case MIME_HTML:
+		self.TemplateSrc = lPage
+		self.renderErrorPage(lErr.Status, map[string]interface{}{
+			"Status": lErr.Status,
+			"Title":  lErr.title(),
+			"Detail": lErr.Detail,
+			"Errors": lErr.Errors,
+		})
+	case MIME_PROBLEM, MIME_JSON:
+		lType := lErr.Type
+		if lType == "" {
+			lType = "about:blank"
+		}
+		self.Encode(MIME_PROBLEM, &TProblem{
+			Type:     lType,
+			Title:    lErr.title(),
+			Status:   lErr.Status,
+			Detail:   lErr.Detail,
+			Instance: self.Request.URL.Path,
+			Errors:   lErr.Errors,
+		})
+		self.Header().Set("X-Content-Type-Options", "nosniff")
+		self.WriteHeader(lErr.Status)
+		self.Write(self.Result)
+		self.Result = nil
+	default:
+		lMsg := lErr.title()
+		if lErr.Detail != "" {
+			lMsg = lErr.Detail
+		}
+		self.Header().Set("Content-Type", GetEncoder(MIME_TEXT).ContentType)
+		self.Header().Set("X-Content-Type-Options", "nosniff")
+		self.WriteHeader(lErr.Status)
+		fmt.Fprintln(self, lMsg)
+	}
+}
+
+// 以状态码渲染已设置的模板 Apply时输出
+func (self *THandler) renderErrorPage(aStatus int, aArgs map[string]interface{}) {
+	self.RenderArgs = utils.MergeMaps(self.Router.GVar, aArgs)
+	self.ContentType = "text/html; charset=utf-8"
+	self.Header().Set("Content-Type", self.ContentType)
+	self.WriteHeader(aStatus)
+}
+
+// 状态码对应的错误页面 模块的优先 不存在时返回空字符串
+func (self *THandler) errorPage(aStatus int) string {
+	lFile := strconv.Itoa(aStatus) + ".html"
+	if lName := self.moduleTemplate(lFile); lName != "" {
+		return lName
+	}
+	if self.Template == nil {
+		return ""
+	}
+
+	lPages := []string{filepath.Join(TEMPLATE_DIR, lFile)}
+	if self.Route != nil && self.Route.FilePath != "" {
+		lPages = append([]string{self.templatePath(lFile)}, lPages...)
+	}
+	for _, lPage := range lPages {
+		if lInfo, err := os.Stat(filepath.Join(AppPath, lPage)); err == nil && !lInfo.IsDir() {
+			return lPage
+		}
+	}
+	return ""
+}
@@ -0,0 +1,149 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VectorsOrigin/template"
+)
+
+func TestErrorHandler(t *testing.T) {
+	m := NewModule(nil, "m")
+	m.Get("/order", func(hd *THandler) (*tReplyOrder, error) {
+		return nil, NewHttpError(http.StatusNotFound, "order not found")
+	})
+	m.Get("/panic", func(hd *THandler) {
+		panic("boom")
+	})
+	m.Get("/abort", func(hd *THandler) {
+		hd.Abort(http.StatusForbidden, "deny")
+	})
+	sub := NewModule(m, "sub")
+	sub.Get("/sub", func(hd *THandler) error {
+		return errors.New("db down")
+	})
+	router := NewRouter()
+	router.RegisterModule(m)
+	router.RegisterModule(sub)
+
+	do := func(url, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("/order", MIME_JSON)
+	var problem TProblem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil || w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != MIME_PROBLEM {
+		t.Fatalf("unexpected problem response %d %q %v", w.Code, w.Body.String(), err)
+	}
+	if !reflect.DeepEqual(problem, TProblem{Type: "about:blank", Title: "Not Found", Status: 404, Detail: "order not found", Instance: "/order"}) {
+		t.Errorf("unexpected problem %+v", problem)
+	}
+
+	if w := do("/abort", MIME_TEXT); w.Code != http.StatusForbidden || w.Body.String() != "deny\n" {
+		t.Errorf("unexpected text error %d %q", w.Code, w.Body.String())
+	}
+	if w := do("/panic", MIME_JSON); w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "boom") {
+		t.Errorf("expect 500 after panic but got %d %q", w.Code, w.Body.String())
+	}
+
+	// 子模块使用父模块的处理器 再次出错时使用默认响应
+	var handled []string
+	router.ErrorHandler = func(hd *THandler, err error) {
+		handled = append(handled, "router")
+	}
+	m.ErrorHandler = func(hd *THandler, err error) {
+		handled = append(handled, "module:"+err.Error())
+		hd.Abort(http.StatusBadGateway, "upstream")
+	}
+	if w := do("/sub", MIME_TEXT); w.Code != http.StatusBadGateway || w.Body.String() != "upstream\n" {
+		t.Errorf("unexpected module error response %d %q", w.Code, w.Body.String())
+	}
+	if strings.Join(handled, ",") != "module:db down" {
+		t.Errorf("unexpected handlers %v", handled)
+	}
+}
+
+func TestErrorPage(t *testing.T) {
+	lAppPath := AppPath
+	AppPath = t.TempDir()
+	defer func() { AppPath = lAppPath }()
+
+	m := NewModule(nil, "m")
+	m.Get("/missing", func(hd *THandler) {
+		hd.RespondWithNotFound()
+	})
+	m.Get("/fail", func(hd *THandler) error {
+		return errors.New("db down")
+	})
+	router := NewRouter()
+	router.Template = template.NewTemplateSet()
+	router.RegisterModule(m)
+
+	write := func(name string) {
+		name = filepath.Join(AppPath, name)
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := os.WriteFile(name, []byte("{{.Title}}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(TEMPLATE_DIR, "404.html"))
+	write(filepath.Join(MODULE_DIR, m.FilePath, TEMPLATE_DIR, "500.html"))
+
+	for _, url := range []string{"/missing", "/fail"} {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Accept", "text/html,*/*;q=0.8")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), MIME_HTML) || w.Code < 400 {
+			t.Errorf("%s: expect error page but got %d %q", url, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+type tErrorForm struct {
+	Name string `query:"name" validate:"required"`
+	Age  int    `query:"age"`
+}
+
+func TestBindErrorResponse(t *testing.T) {
+	m := NewModule(nil, "m")
+	m.Get("/form", func(hd *THandler) error {
+		var form tErrorForm
+		return hd.BindValid(&form)
+	})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	for url, status := range map[string]int{
+		"/form?name=a&age=x": http.StatusBadRequest,
+		"/form?age=1":        http.StatusUnprocessableEntity,
+	} {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Accept", MIME_JSON)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var problem TProblem
+		if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil || w.Code != status || problem.Detail == "" {
+			t.Fatalf("%s: unexpected response %d %q %v", url, w.Code, w.Body.String(), err)
+		}
+		lField := "Age"
+		if status == http.StatusUnprocessableEntity {
+			lField = "Name"
+		}
+		if len(problem.Errors) != 1 || problem.Errors[lField] == "" {
+			t.Errorf("%s: unexpected field errors %v", url, problem.Errors)
+		}
+	}
+}
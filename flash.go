@@ -0,0 +1,90 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+/*
+	flash 负责闪现消息 即只显示一次的提示
+	@消息保存在会话中 Router.FlashCookie 非空时保存在签名Cookie中(需配置 CookieSecret)
+	@模板函数 flashes 返回并清除消息 只有显示消息的模板才清除 其他页面(如错误页面)不影响
+
+	hd.Flash("success", "Order saved")
+	hd.Redirect("/orders")
+
+	{{range flashes .}}<div class="alert-{{.Kind}}">{{.Message}}</div>{{end}}
+*/
+
+const (
+	FLASH_KEY = "_flash"  // 会话中的key
+	FLASH_ARG = "Flashes" // 模板变量名 值为读取函数 由模板函数 flashes 调用
+)
+
+// 闪现消息
+type TFlash struct {
+	Kind    string `json:"kind"` // 如 success/info/warning/error
+	Message string `json:"message"`
+}
+
+// 添加一条闪现消息 下一次读取时返回
+func (self *THandler) Flash(kind, message string) {
+	lFlashes := append(self.peekFlashes(), TFlash{Kind: kind, Message: message})
+	lData, _ := json.Marshal(lFlashes)
+
+	if self.Router.FlashCookie == "" {
+		self.Session().Set(FLASH_KEY, string(lData))
+		return
+	}
+
+	if err := self.SetSignedCookie(&http.Cookie{Name: self.Router.FlashCookie, Value: string(lData), HttpOnly: true}); err != nil {
+		logger.Err("set flash cookie faild: %s", err.Error())
+	}
+}
+
+// 返回并清除所有闪现消息
+func (self *THandler) Flashes() []TFlash {
+	lFlashes := self.peekFlashes()
+	if len(lFlashes) == 0 {
+		return nil
+	}
+
+	if self.Router.FlashCookie == "" {
+		self.Session().Delete(FLASH_KEY)
+	} else {
+		self.DeleteCookie(self.Router.FlashCookie)
+	}
+	return lFlashes
+}
+
+// 模板函数 flashes 返回并清除闪现消息
+func templateFlashes(data map[string]interface{}) []TFlash {
+	if lFlashes, ok := data[FLASH_ARG].(func() []TFlash); ok {
+		return lFlashes()
+	}
+	return nil
+}
+
+// 读取但不清除
+func (self *THandler) peekFlashes() []TFlash {
+	var lData string
+	if self.Router.FlashCookie == "" {
+		lData = self.Session().GetString(FLASH_KEY)
+	} else {
+		var err error
+		lData, err = self.GetSignedCookie(self.Router.FlashCookie)
+		if err != nil && !errors.Is(err, http.ErrNoCookie) {
+			logger.Err("read flash cookie faild: %s", err.Error())
+		}
+	}
+	if lData == "" {
+		return nil
+	}
+
+	var lFlashes []TFlash
+	if err := json.Unmarshal([]byte(lData), &lFlashes); err != nil {
+		return nil
+	}
+	return lFlashes
+}
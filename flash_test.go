@@ -0,0 +1,103 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/VectorsOrigin/template"
+)
+
+func TestFlash(t *testing.T) {
+	for _, flashCookie := range []string{"", "_flash"} {
+		var flashes []TFlash
+		m := NewModule(nil, "m")
+		m.Post("/order", func(hd *THandler) {
+			hd.Flash("success", "Order saved")
+			hd.Flash("info", "Mail sent")
+			hd.Redirect("/orders")
+		})
+		m.Get("/orders", func(hd *THandler) {
+			flashes = hd.Flashes()
+		})
+		m.Get("/page", func(hd *THandler) {
+			hd.Flash("error", "Failed")
+			hd.RenderTemplate("page.html", nil)
+		})
+		m.Get("/show", func(hd *THandler) {
+			hd.RenderTemplate("show.html", nil)
+		})
+		m.Get("/missing", func(hd *THandler) {
+			hd.RespondWithNotFound()
+		})
+		m.Templates(fstest.MapFS{
+			"page.html": {Data: []byte(`page`)},
+			"show.html": {Data: []byte(`[{{range flashes .}}{{.Message}};{{end}}]`)},
+			"404.html":  {Data: []byte(`missing`)},
+		})
+		router := NewRouter()
+		router.Template = template.NewTemplateSet()
+		router.Server = &TServer{Config: &TConfig{CookieSecret: "secret"}}
+		router.FlashCookie = flashCookie
+		router.RegisterModule(m)
+		router.Init()
+
+		var cookies []*http.Cookie
+		do := func(method, url string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, url, nil)
+			req.Header.Set("Accept", MIME_HTML)
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// 模拟浏览器 同名Cookie以最后一个为准
+			for _, c := range w.Result().Cookies() {
+				lKept := cookies[:0]
+				for _, o := range cookies {
+					if o.Name != c.Name {
+						lKept = append(lKept, o)
+					}
+				}
+				cookies = lKept
+				if c.MaxAge >= 0 {
+					cookies = append(cookies, c)
+				}
+			}
+			return w
+		}
+
+		if w := do("POST", "/order"); w.Code != http.StatusFound {
+			t.Fatalf("%q: expect redirect but got %d", flashCookie, w.Code)
+		}
+		do("GET", "/orders")
+		if len(flashes) != 2 || flashes[0] != (TFlash{"success", "Order saved"}) || flashes[1].Kind != "info" {
+			t.Errorf("%q: unexpected flashes %v", flashCookie, flashes)
+		}
+		do("GET", "/orders")
+		if len(flashes) != 0 {
+			t.Errorf("%q: expect flashes cleared but got %v", flashCookie, flashes)
+		}
+
+		// 不显示消息的模板不清除 显示消息的模板读取后清除
+		do("GET", "/page")
+		if w := do("GET", "/show"); w.Body.String() != "[Failed;]" {
+			t.Errorf("%q: expect flash rendered by template but got %q", flashCookie, w.Body.String())
+		}
+		do("GET", "/orders")
+		if len(flashes) != 0 {
+			t.Errorf("%q: expect flashes consumed by template but got %v", flashCookie, flashes)
+		}
+
+		// 重定向和显示消息的页面之间有错误页面
+		do("POST", "/order")
+		if w := do("GET", "/missing"); w.Code != http.StatusNotFound || w.Body.String() != "missing" {
+			t.Errorf("%q: expect error page but got %d %q", flashCookie, w.Code, w.Body.String())
+		}
+		if w := do("GET", "/show"); w.Body.String() != "[Order saved;Mail sent;]" {
+			t.Errorf("%q: expect flashes kept across the error page but got %q", flashCookie, w.Body.String())
+		}
+	}
+}
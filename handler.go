@@ -4,14 +4,11 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"net"
 	"net/http"
-	"net/url"
-	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -32,9 +29,6 @@ const (
 )
 
 var (
-	cookieNameSanitizer  = strings.NewReplacer("\n", "-", "\r", "-")
-	cookieValueSanitizer = strings.NewReplacer("\n", " ", "\r", " ", ";", " ")
-
 	// onExitFlushLoop is a callback set by tests to detect the state of the
 	// flushLoop() goroutine.
 	onExitFlushLoop func()
@@ -117,6 +111,13 @@ type (
 		isApplies bool          // -- 已经提交过
 		finalCall reflect.Value // -- handler 结束执行的动作处理器
 		val       reflect.Value
+
+		stream  *TEventStream // SSE事件流 Apply时关闭
+		session *TSession     // 首次调用Session()时创建 Apply时保存
+
+		handlingError bool                           // 正在执行错误处理器 防止处理器中出错时循环
+		injected      map[reflect.Type]reflect.Value // 本次请求已创建的 PROVIDE_REQUEST 注入值
+		args          []reflect.Value                // 控制器参数缓冲 各控制器按调用计划填充
 	}
 
 	// 反向代理
@@ -188,7 +189,6 @@ func NewHandler() *THandler {
 		//GET:    map[string]string{},
 		//POST:   map[string]string{},
 		COOKIE: map[string]string{},
-		//		SESSION:      map[string]interface{}{},
 		//MethodParams: map[string]string{},
 		//PathParams:   map[string]string{},
 		RenderArgs: make(map[string]interface{}),
@@ -282,7 +282,7 @@ func (self *THandler) MethodParams() *TParamsSet {
 	ct := self.Request.Header.Get("Content-Type")
 	ct, _, _ = mime.ParseMediaType(ct)
 	if ct == "multipart/form-data" {
-		self.Request.ParseMultipartForm(256)
+		self.parseMultipartForm()
 	} else {
 		self.Request.ParseForm() //#Go通过r.ParseForm之后，把用户POST和GET的数据全部放在了r.Form里面
 	}
@@ -348,11 +348,6 @@ func (self *THandler) setPathParams(name, val string) {
 	self.pathParams.params[name] = val
 }
 
-/*
-func (self *THandler) UpdateSession() {
-	self.Router.Sessions.UpdateSession(self.COOKIE[self.Router.Sessions.CookieName], self.SESSION)
-}
-*/
 
 /*
 刷新
@@ -372,13 +367,28 @@ func (self *THandler) connect(rw IResponseWriter, req *http.Request, Router *TRo
 	self.RenderArgs = make(map[string]interface{}) // 清空
 	self.Data = make(map[string]interface{})       // 清空
 	self.body = nil
+	for key := range self.pathParams.params { // 清空上次请求的Url参数
+		delete(self.pathParams.params, key)
+	}
+	for key := range self.COOKIE { // 清空上次请求的Cookie
+		delete(self.COOKIE, key)
+	}
+	for _, lCookie := range req.Cookies() {
+		if _, has := self.COOKIE[lCookie.Name]; !has { // 同名时取第一个 与Request.Cookie一致
+			self.COOKIE[lCookie.Name] = lCookie.Value
+		}
+	}
 	self.Result = nil
 	self.CtrlIndex = 0 // -- 提示目前控制器Index
 	//self.CtrlCount = 0     // --
 	self.isApplies = false // -- 已经提交过
+	self.stream = nil
+	self.session = nil
+	self.handlingError = false
+	for key := range self.injected { // 清空上次请求的注入值
+		delete(self.injected, key)
+	}
 
-	//CookieSessions.ConnectSession(rw, req)
-	//MemorySessions.ConnectSession(rw, req)
 	//self.getPathParams()     // 获得Path[请求参数]
 	//self.getMethodParams(32) //废弃 #获得Form[请求参数]
 	//self.GetCookie()
@@ -387,14 +397,30 @@ func (self *THandler) connect(rw IResponseWriter, req *http.Request, Router *TRo
 
 // 执行所以变动
 func (self *THandler) Apply() {
+	if self.stream != nil {
+		self.stream.Close()
+	}
+
+	// 先把模板渲染到缓冲 模板函数(flashes/csrf_token)可能修改会话和Cookie 需在提交会话和写出头之前
+	var lPage *bytes.Buffer
+	var lErr error
+	if !self.isApplies && self.TemplateSrc != "" {
+		lPage = new(bytes.Buffer)
+		lErr = self.Template.RenderToWriter(self.TemplateSrc, self.RenderArgs, lPage, "base")
+	}
+
+	if self.session != nil {
+		self.session.commit()
+	}
+
 	if !self.isApplies {
 		// 如果有模板文件输入
-		if self.TemplateSrc != "" {
-			self.SetHeader(true, "Content-Type", self.ContentType)
-			//self.Template.Render(self.TemplateSrc, self.Response, self.RenderArgs)
-			err := self.Template.RenderToWriter(self.TemplateSrc, self.RenderArgs, self.Response, "base")
-			if err != nil {
-				http.Error(self.Response, "Apply fail:"+err.Error(), http.StatusInternalServerError)
+		if lPage != nil {
+			if lErr != nil {
+				http.Error(self.Response, "Apply fail:"+lErr.Error(), http.StatusInternalServerError)
+			} else {
+				self.SetHeader(true, "Content-Type", self.ContentType)
+				self.Response.Write(lPage.Bytes())
 			}
 		} else if !self.Response.Written() { // STEP:只许一次返回
 			self.Write(self.Result)
@@ -431,16 +457,6 @@ func (self *THandler) IP() (res []string) {
 	return
 }
 
-func (self *THandler) GetCookie(name, key string) (val string) {
-	ck, err := self.Request.Cookie(name)
-	if err != nil {
-		return
-	}
-
-	val, _ = url.QueryUnescape(ck.Value)
-	return
-}
-
 func (self *THandler) GetModulePath() string {
 	return self.Route.FileName
 }
@@ -460,113 +476,6 @@ func (self *THandler) RemoteAddr() string {
 	return addr
 }
 
-//SetCookie Sets the header entries associated with key to the single element value. It replaces any existing values associated with key.
-//一个cookie  有名称,内容,原始值,域,大小,过期时间,安全
-//cookie[0] => name string
-//cookie[1] => value string
-//cookie[2] => expires string
-//cookie[3] => path string
-//cookie[4] => domain string
-func (self *THandler) SetCookie(name string, value string, others ...interface{}) {
-	var b bytes.Buffer
-	fmt.Fprintf(&b, "%s=%s", sanitizeCookieName(name), sanitizeCookieValue(value))
-
-	if len(others) > 0 {
-		switch others[0].(type) {
-		case int:
-			if others[0].(int) > 0 {
-				fmt.Fprintf(&b, "; Max-Age=%d", others[0].(int))
-			} else if others[0].(int) < 0 {
-				fmt.Fprintf(&b, "; Max-Age=0")
-			}
-		case int64:
-			if others[0].(int64) > 0 {
-				fmt.Fprintf(&b, "; Max-Age=%d", others[0].(int64))
-			} else if others[0].(int64) < 0 {
-				fmt.Fprintf(&b, "; Max-Age=0")
-			}
-		case int32:
-			if others[0].(int32) > 0 {
-				fmt.Fprintf(&b, "; Max-Age=%d", others[0].(int32))
-			} else if others[0].(int32) < 0 {
-				fmt.Fprintf(&b, "; Max-Age=0")
-			}
-		}
-	}
-	if len(others) > 1 {
-		fmt.Fprintf(&b, "; Path=%s", sanitizeCookieValue(others[1].(string)))
-	}
-	if len(others) > 2 {
-		fmt.Fprintf(&b, "; Domain=%s", sanitizeCookieValue(others[2].(string)))
-	}
-	if len(others) > 3 {
-		if others[3].(bool) {
-			fmt.Fprintf(&b, "; Secure")
-		}
-	}
-
-	if len(others) > 4 {
-		if others[4].(bool) {
-			fmt.Fprintf(&b, "; HttpOnly")
-		}
-	}
-	self.Response.Header().Add("Set-Cookie", b.String())
-	/*
-		if aName == "" && aValue == "" { // 不能少于两个参数
-			return
-		}
-		fmt.Println(args, len(args))
-		var (
-			//name    string
-			//value   string
-			expires int
-			path    string
-			domain  string
-		)
-		if len(args) > 0 {
-			if v, ok := args[0].(int); ok {
-				expires = v
-			}
-		}
-		if len(args) > 1 {
-			if v, ok := args[1].(string); ok {
-				path = v
-			}
-		}
-		if len(args) > 2 {
-			if v, ok := args[2].(string); ok {
-				domain = v
-			}
-		}
-
-		lpCookie := &http.Cookie{
-			Name:   aName,
-			Value:  url.QueryEscape(aValue),
-			Path:   path,
-			Domain: domain,
-		}
-
-		if expires > 0 { //设置过期时间
-			d, _ := time.ParseDuration(strconv.Itoa(expires) + "s")
-			lpCookie.Expires = time.Now().Add(d)
-		}
-		if unique {
-			self.Response.Header().Set("Set-Cookie", lpCookie.String()) // 等同http.SetCookie()
-
-		} else {
-			self.Response.Header().Add("Set-Cookie", lpCookie.String()) // 等同http.SetCookie()
-
-		}
-	*/
-	/*
-		if expires > 0 {
-			p.COOKIE[pCookie.Name] = pCookie.Value
-		} else {
-			delete(p.COOKIE, pCookie.Name)
-		}
-	*/
-}
-
 // 添加Http头信息
 func (self *THandler) SetHeader(unique bool, hdr string, val string) {
 	if unique {
@@ -576,7 +485,13 @@ func (self *THandler) SetHeader(unique bool, hdr string, val string) {
 	}
 }
 
+// 以状态码结束请求 400以上交给错误处理 body为错误说明
 func (self *THandler) Abort(status int, body string) {
+	if status >= http.StatusBadRequest {
+		self.HandleError(NewHttpError(status, body))
+		return
+	}
+
 	self.Response.WriteHeader(status)
 	self.Response.Write([]byte(body))
 	//self.Result = body
@@ -592,11 +507,9 @@ func (self *THandler) Respond(aBody []byte) {
 	//self.Response.Write(aBody)
 }
 
+// 以500交给错误处理 error为错误说明
 func (self *THandler) RespondError(error string) {
-	self.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	self.Header().Set("X-Content-Type-Options", "nosniff")
-	self.WriteHeader(http.StatusInternalServerError)
-	fmt.Fprintln(self, error)
+	self.HandleError(NewHttpError(http.StatusInternalServerError, error))
 }
 
 func (self *THandler) NotModified() {
@@ -605,15 +518,9 @@ func (self *THandler) NotModified() {
 
 // Respond content by Json mode
 func (self *THandler) RespondByJson(aBody interface{}) {
-
-	lJson, err := json.Marshal(aBody)
-	if err != nil {
+	if err := self.Encode(MIME_JSON, aBody); err != nil {
 		self.Response.Write([]byte(err.Error()))
-		return
 	}
-
-	self.Response.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	self.Result = lJson
 }
 
 // Ck
@@ -630,19 +537,6 @@ func (self *THandler) Redirect(urlStr string, status ...int) {
 	self.Result = []byte("Redirecting to: " + urlStr)
 }
 
-func (self *THandler) Download(file_path string) error {
-	f, err := os.Open(file_path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	fName := filepath.Base(file_path)
-	self.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%v\"", fName))
-	_, err = io.Copy(self, f)
-	return err
-}
-
 func (self *THandler) ServeFile(file_path string) {
 	http.ServeFile(self.Response, self.Request, file_path)
 }
@@ -678,33 +572,40 @@ func (self *THandler) RenderTemplate(aTemplateFile string, aArgs interface{}) {
 	if a, ok := aArgs.(map[string]interface{}); ok {
 		self.RenderArgs = utils.MergeMaps(self.Router.GVar, a) // 添加Router的全局变量到Templete
 	} else {
-		self.RenderArgs = self.Router.GVar // 添加Router的全局变量到Templete
+		self.RenderArgs = utils.MergeMaps(self.Router.GVar) // 添加Router的全局变量到Templete 复制以免请求的变量写入GVar
 	}
 
-	if self.Route.FilePath == "" {
-		self.TemplateSrc = filepath.Join(TEMPLATE_DIR, aTemplateFile)
-	} else {
-		self.TemplateSrc = filepath.Join(MODULE_DIR, self.Route.FilePath, TEMPLATE_DIR, aTemplateFile)
-		//self.TemplateSrc = filepath.Join(self.Route.FilePath,TEMPLATE_DIR, aTemplateFile)
-
-	}
+	self.setTemplate(aTemplateFile)
 	logger.Info("RenderTemplate", self.Route.FilePath, self.TemplateSrc)
 }
 
-// Responds with 404 Not Found
-func (self *THandler) RespondWithNotFound(message ...string) {
-	//self.Abort(http.StatusNotFound, body)
-	if len(message) == 0 {
-		self.Abort(http.StatusNotFound, http.StatusText(http.StatusNotFound))
+// 设置渲染的模板 模块的模板文件系统中存在时从中读取 否则为硬盘上的模板路径
+func (self *THandler) setTemplate(aTemplateFile string) {
+	if lName := self.moduleTemplate(aTemplateFile); lName != "" {
+		self.TemplateSrc = lName
 		return
 	}
-	self.Abort(http.StatusNotFound, message[0])
+
+	self.TemplateSrc = self.templatePath(aTemplateFile)
+}
+
+// 模板文件路径 有模块时为模块的模板文件夹
+func (self *THandler) templatePath(aTemplateFile string) string {
+	if self.Route.FilePath == "" {
+		return filepath.Join(TEMPLATE_DIR, aTemplateFile)
+	}
+	return filepath.Join(MODULE_DIR, self.Route.FilePath, TEMPLATE_DIR, aTemplateFile)
 }
 
 // Responds with 404 Not Found
+func (self *THandler) RespondWithNotFound(message ...string) {
+	self.HandleError(NewHttpError(http.StatusNotFound, message...))
+}
+
+// Responds with 404 Not Found 使用模块的模板页面
 func (self *THandler) RespondWithNotFoundPage(HtmlFile string) {
-	//self.Router.RenderTemplate(TEMPLATES_ROOT+"/"+HtmlFile, self, nil)
-	self.Router.Server.Template.RenderToWriter(filepath.Join(MODULE_DIR, self.Route.Path, TEMPLATE_DIR, HtmlFile), nil, self)
+	self.setTemplate(HtmlFile)
+	self.renderErrorPage(http.StatusNotFound, nil)
 }
 
 // Checks whether the HTTP method is GET or not
@@ -878,49 +779,6 @@ func (self *TRestHandle) RespondByJson(src interface{}) {
 
 }
 */
-func sanitizeCookieName(n string) string {
-	return cookieNameSanitizer.Replace(n)
-}
-
-func sanitizeCookieValue(v string) string {
-	return cookieValueSanitizer.Replace(v)
-	//return sanitizeOrWarn("Cookie.Value", validCookieValueByte, v)
-}
-
-func sanitizeOrWarn(fieldName string, valid func(byte) bool, v string) string {
-	ok := true
-	for i := 0; i < len(v); i++ {
-		if valid(v[i]) {
-			continue
-		}
-		fmt.Printf("net/http: invalid byte %q in %s; dropping invalid bytes", v[i], fieldName)
-		ok = false
-		break
-	}
-	if ok {
-		return v
-	}
-	buf := make([]byte, 0, len(v))
-	for i := 0; i < len(v); i++ {
-		if b := v[i]; valid(b) {
-			buf = append(buf, b)
-		}
-	}
-	return string(buf)
-}
-
-func validCookieValueByte(b byte) bool {
-	return 0x20 < b && b < 0x7f && b != '"' && b != ',' && b != ';' && b != '\\'
-}
-
-/*
-func init() {
-	CookieSessions, _ = xsession.NewManager("cookie", `{"cookieName":"IV_C","enableSetCookie":true,"gclifetime":3600,"maxLifetime":86400,"ProviderConfig":"{\"cookieName\":\"agosessionid\",\"securityKey\":\"beegocookiehashkey\"}"}`)
-	go CookieSessions.GC()
-	MemorySessions, _ = xsession.NewManager("memory", `{"cookieName":"IV_M","gclifetime":3600,"maxLifetime":172800}`)
-	go MemorySessions.GC()
-}
-*/
 
 func (m *maxLatencyWriter) Write(p []byte) (int, error) {
 	m.mu.Lock()
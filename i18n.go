@@ -6,6 +6,7 @@ package web
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -16,7 +17,11 @@ import (
 )
 
 /*
-  废弃 由中间件实现 保留代码
+TI18n 负责语言文件的翻译
+@服务器配置 use_i18n 开启后由 Router.Init 从程序文件夹的 locale 文件夹载入
+@文件夹中每个文件为一种语言 文件名为语言代码 内容为 {"原文":"译文"} 的JSON
+@语言代码不区分大小写 文件名的扩展名不计入 如 zh-CN.json 为 zh-cn
+@验证消息按请求的 Accept-Language 翻译 模板中使用 {{trans "原文"}}
 */
 type TI18n struct {
 	Name            string //名称
@@ -24,10 +29,10 @@ type TI18n struct {
 	defaultLanguage string //默认语言
 
 	rmutex       sync.RWMutex
-	mutex        sync.Mutex
 	Locales      map[string]map[string]string //语言文件数据
 	currentLocal string
 	lastModTime  map[string]int64
+	files        map[string]string // 语言代码:文件路径
 }
 
 // 创建[区域设置]
@@ -40,73 +45,95 @@ func NewI18n(name string) *TI18n {
 		Name:        name,
 		Locales:     map[string]map[string]string{},
 		lastModTime: map[string]int64{},
+		files:       map[string]string{},
 	}
 }
 
-// [区域]设置
+// [区域]设置 载入文件夹中的所有语言 lang为默认语言
 func (self *TI18n) Init(path, lang string) error {
-	if utils.DirExists(path) {
-		self.localeDir = path
-		self.defaultLanguage = lang
-
-		return self.Load(self.defaultLanguage)
-	} else {
+	if !utils.DirExists(path) {
 		return errors.New("Dir not Exist")
 	}
+
+	self.localeDir = path
+	self.defaultLanguage = strings.ToLower(lang)
+	self.SetLocale(lang)
+
+	lInfos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, lInfo := range lInfos {
+		if lInfo.IsDir() || strings.HasPrefix(lInfo.Name(), ".") {
+			continue
+		}
+		if err := self.Load(lInfo.Name()); err != nil {
+			return fmt.Errorf("load locale %s faild: %v", lInfo.Name(), err)
+		}
+	}
 	return nil
 }
 
-// 载入[语言翻译文件]
+// 载入[语言翻译文件] lang为语言代码或文件夹中的文件名
 func (self *TI18n) Load(lang string) error {
+	lLang := localeKey(lang)
 	self.rmutex.RLock()
-	_, found := self.Locales[lang]
-	oldLastModTime, mFound := self.lastModTime[lang] // 语言文件的修改时间
+	_, found := self.Locales[lLang]
+	oldLastModTime, mFound := self.lastModTime[lLang] // 语言文件的修改时间
+	langFile := self.files[lLang]
 	self.rmutex.RUnlock()
 
-	langFile := filepath.Join(self.localeDir, lang) // 组织路径
-	newer := false
+	if langFile == "" {
+		langFile = filepath.Join(self.localeDir, lang) // 组织路径
+	}
 	dataFi, err := os.Stat(langFile) //检查并返回文件状态
-	if err == nil {
-		lastModTime := dataFi.ModTime().Unix() //获取最后修改时间
-		if !mFound {                           // 如果该Lang没有修改时间 则添加
-			self.rmutex.Lock()
-			self.lastModTime[lang] = lastModTime
-			self.rmutex.Unlock()
-			newer = true
-		} else { //如果有则对比更新
-			if lastModTime > oldLastModTime {
-				newer = true
-			}
-		}
+	if err != nil {
+		return err
+	}
 
-		// 不是新文件 没必要更新
-		if found && !newer {
-			return nil
-		}
+	// 不是新文件 没必要更新
+	lastModTime := dataFi.ModTime().Unix() //获取最后修改时间
+	if found && mFound && lastModTime <= oldLastModTime {
+		return nil
+	}
 
-		data, _ := ioutil.ReadFile(langFile) //读取文件
-		m := map[string]string{}
-		err = json.Unmarshal(data, &m) //转换文件到MAP[]
-		if err == nil {
-			self.mutex.Lock()
-			self.Locales[lang] = m //更新
-			self.mutex.Unlock()
-		}
+	data, err := ioutil.ReadFile(langFile) //读取文件
+	if err != nil {
+		return err
+	}
+	m := map[string]string{}
+	if err = json.Unmarshal(data, &m); err != nil { //转换文件到MAP[]
+		return err
 	}
 
-	return err
+	self.rmutex.Lock()
+	self.Locales[lLang] = m //更新
+	self.lastModTime[lLang] = lastModTime
+	self.files[lLang] = langFile
+	self.rmutex.Unlock()
+	return nil
+}
+
+// 语言代码 小写并去掉文件扩展名
+func localeKey(aName string) string {
+	return strings.ToLower(strings.TrimSuffix(aName, filepath.Ext(aName)))
 }
 
 func (self *TI18n) SetLocale(local string) {
-	self.currentLocal = strings.ToLower(local) //通常小写
+	self.rmutex.Lock()
+	self.currentLocal = localeKey(local) //通常小写
+	self.rmutex.Unlock()
 }
 
 func (self *TI18n) Translate(aText string, aLocal ...string) string {
+	self.rmutex.RLock()
+	defer self.rmutex.RUnlock()
+
 	var lLocal string
 	if len(aLocal) == 0 {
 		lLocal = self.currentLocal
 	} else {
-		lLocal = aLocal[0]
+		lLocal = strings.ToLower(aLocal[0])
 	}
 
 	if ct, ok := self.Locales[lLocal]; ok {
@@ -0,0 +1,236 @@
+package web
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+/*
+	inject 负责控制器参数的依赖注入
+	@Provider 注册在服务器上 控制器的参数按类型从中取得
+	@PROVIDE_SINGLETON 单例 第一次注入时创建 所有请求共享
+	@PROVIDE_REQUEST   每个请求创建一次 同一请求的控制器共享
+	@PROVIDE_FACTORY   每次注入都创建
+	@Provider 函数可以没有参数或以 *THandler 为参数 返回 T 或 (T, error)
+		返回的error交给 hd.HandleError 控制器不再执行
+	@没有Provider的参数类型在注册模块时报错 *THandler/http.ResponseWriter/*http.Request/动作结构体除外
+		因此Provider须在注册模块(和Listen)前注册
+
+	srv.Provide(lDb) // *sql.DB
+	srv.ProvideRequest(func(hd *THandler) (*TUser, error) {
+		return findUser(hd.Session().GetInt("uid"))
+	})
+	srv.Get("/me", func(hd *THandler, db *sql.DB, user *TUser) { ... })
+*/
+
+const (
+	PROVIDE_SINGLETON = iota
+	PROVIDE_REQUEST
+	PROVIDE_FACTORY
+)
+
+type (
+	// 依赖注入的Provider注册表
+	TInjector struct {
+		lock      sync.RWMutex
+		providers map[reflect.Type]*tProvider
+	}
+
+	tProvider struct {
+		scope       int
+		fn          reflect.Value // 为空时为注册的单例值
+		withHandler bool          // fn以 *THandler 为参数
+		withError   bool          // fn返回 (T, error)
+
+		lock  sync.Mutex // 单例创建锁
+		ready bool
+		value reflect.Value
+	}
+)
+
+var (
+	handlerType  = reflect.TypeOf((*THandler)(nil))
+	requestType  = reflect.TypeOf((*http.Request)(nil))
+	responseType = reflect.TypeOf((*http.ResponseWriter)(nil)).Elem()
+)
+
+func NewInjector() *TInjector {
+	return &TInjector{
+		providers: make(map[reflect.Type]*tProvider),
+	}
+}
+
+// 注册单例值 以值的类型注入
+func (self *TInjector) Provide(value interface{}) {
+	if value == nil {
+		logger.Panic("the provided value must not be nil!")
+	}
+
+	lValue := reflect.ValueOf(value)
+	self.add(lValue.Type(), &tProvider{
+		scope: PROVIDE_SINGLETON,
+		ready: true,
+		value: lValue,
+	})
+}
+
+// 注册Provider函数 以函数的第一个返回值类型注入 接口类型需函数返回该接口
+func (self *TInjector) Register(scope int, fn interface{}) {
+	lFn := reflect.ValueOf(fn)
+	lType := lFn.Type()
+	if lType.Kind() != reflect.Func {
+		logger.Panic("the provider %v must be a function!", lType)
+	}
+	if lType.NumIn() > 1 || (lType.NumIn() == 1 && lType.In(0) != handlerType) {
+		logger.Panic("the provider %v can only take a *THandler argument!", lType)
+	}
+	if lType.NumOut() == 0 || lType.NumOut() > 2 || (lType.NumOut() == 2 && lType.Out(1) != errorType) {
+		logger.Panic("the provider %v must return T or (T, error)!", lType)
+	}
+	if scope != PROVIDE_SINGLETON && scope != PROVIDE_REQUEST && scope != PROVIDE_FACTORY {
+		logger.Panic("unknown provider scope %d!", scope)
+	}
+
+	self.add(lType.Out(0), &tProvider{
+		scope:       scope,
+		fn:          lFn,
+		withHandler: lType.NumIn() == 1,
+		withError:   lType.NumOut() == 2,
+	})
+}
+
+// 同一类型的Provider将被替换
+func (self *TInjector) add(aType reflect.Type, aProvider *tProvider) {
+	if isBuiltinParam(aType) {
+		logger.Panic("the type %v is injected by the router!", aType)
+	}
+
+	self.lock.Lock()
+	self.providers[aType] = aProvider
+	self.lock.Unlock()
+}
+
+// 是否有该类型的Provider
+func (self *TInjector) Has(aType reflect.Type) bool {
+	return self.get(aType) != nil
+}
+
+func (self *TInjector) get(aType reflect.Type) *tProvider {
+	if self == nil {
+		return nil
+	}
+
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.providers[aType]
+}
+
+// 取得注入的值
+func (self *TInjector) resolve(hd *THandler, aType reflect.Type) (reflect.Value, error) {
+	lProvider := self.get(aType)
+	if lProvider == nil {
+		return reflect.Zero(aType), nil
+	}
+
+	switch lProvider.scope {
+	case PROVIDE_SINGLETON:
+		lProvider.lock.Lock()
+		defer lProvider.lock.Unlock()
+		if !lProvider.ready { // 失败时下次重试
+			lValue, err := lProvider.call(hd)
+			if err != nil {
+				return lValue, err
+			}
+			lProvider.value = lValue
+			lProvider.ready = true
+		}
+		return lProvider.value, nil
+
+	case PROVIDE_REQUEST:
+		if lValue, ok := hd.injected[aType]; ok {
+			return lValue, nil
+		}
+		lValue, err := lProvider.call(hd)
+		if err != nil {
+			return lValue, err
+		}
+		if hd.injected == nil {
+			hd.injected = make(map[reflect.Type]reflect.Value)
+		}
+		hd.injected[aType] = lValue
+		return lValue, nil
+	}
+
+	return lProvider.call(hd)
+}
+
+func (self *tProvider) call(hd *THandler) (reflect.Value, error) {
+	var lOut []reflect.Value
+	if self.withHandler {
+		lOut = self.fn.Call([]reflect.Value{hd.val})
+	} else {
+		lOut = self.fn.Call(nil)
+	}
+
+	if self.withError {
+		if err, _ := lOut[1].Interface().(error); err != nil {
+			return reflect.Zero(lOut[0].Type()), err
+		}
+	}
+	return lOut[0], nil
+}
+
+// 由路由直接传递的参数类型
+func isBuiltinParam(aType reflect.Type) bool {
+	return aType == handlerType || aType == requestType || aType == responseType
+}
+
+// 服务器的注入器 没有服务器时为nil
+func (self *TRouter) injector() *TInjector {
+	if self.Server == nil {
+		return nil
+	}
+	return self.Server.Injector
+}
+
+// 生成模块控制器的调用计划 有参数不能注入时报错
+func (self *TRouter) checkInject(aMd IModule) {
+	lInjector := self.injector()
+	for _, lRoot := range aMd.GetRoutes().Root {
+		lRoot.walk(func(n *TNode) {
+			if n.Route == nil {
+				return
+			}
+
+			for _, lCtrl := range n.Route.Ctrls {
+				if lCtrl.plans == nil {
+					continue
+				}
+				if lMissing := lCtrl.plans.get(lCtrl.FuncType, lInjector).missing; len(lMissing) > 0 {
+					logger.Panic("no provider for parameter %v of controller %v on route %s!", lMissing[0], lCtrl.FuncType, n.Route.Path)
+				}
+			}
+		})
+	}
+}
+
+// 注册单例值
+func (self *TServer) Provide(value interface{}) {
+	self.Injector.Provide(value)
+}
+
+// 注册单例Provider 第一次注入时创建
+func (self *TServer) ProvideSingleton(fn interface{}) {
+	self.Injector.Register(PROVIDE_SINGLETON, fn)
+}
+
+// 注册请求Provider 每个请求创建一次
+func (self *TServer) ProvideRequest(fn interface{}) {
+	self.Injector.Register(PROVIDE_REQUEST, fn)
+}
+
+// 注册工厂Provider 每次注入都创建
+func (self *TServer) ProvideFactory(fn interface{}) {
+	self.Injector.Register(PROVIDE_FACTORY, fn)
+}
@@ -0,0 +1,111 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type (
+	tInjectDb   struct{ name string }
+	tInjectUser struct{ id int }
+	tInjectId   int
+	tInjectConf struct{ Debug bool }
+)
+
+func TestInject(t *testing.T) {
+	var users, ids, singletons int
+	srv := NewServer("inject_test")
+	srv.Provide(&tInjectDb{name: "main"})
+	srv.ProvideSingleton(func() tInjectConf {
+		singletons++
+		return tInjectConf{Debug: true}
+	})
+	srv.ProvideRequest(func(hd *THandler) (*tInjectUser, error) {
+		if hd.Request.Header.Get("Authorization") == "" {
+			return nil, NewHttpError(http.StatusUnauthorized)
+		}
+		users++
+		return &tInjectUser{id: users}, nil
+	})
+	srv.ProvideFactory(func() tInjectId {
+		ids++
+		return tInjectId(ids)
+	})
+
+	var got []interface{}
+	srv.HookBefore(nil, "/me", func(hd *THandler, user *tInjectUser, id tInjectId) {
+		got = append(got, user, id)
+	})
+	srv.Get("/me", func(conf tInjectConf, hd *THandler, db *tInjectDb, user *tInjectUser, id tInjectId) {
+		got = append(got, conf, db, user, id)
+	})
+	srv.Router.RegisterModule(srv)
+
+	serve := func(auth string) int {
+		got = nil
+		req := httptest.NewRequest("GET", "/me", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := serve("token"); code != http.StatusOK || len(got) != 6 {
+		t.Fatalf("unexpected response %d %v", code, got)
+	}
+	if got[0] != got[4] || got[2] != (tInjectConf{Debug: true}) || got[3].(*tInjectDb).name != "main" {
+		t.Errorf("unexpected injected values %v", got)
+	}
+	if got[1] == got[5] {
+		t.Errorf("expect factory creates every time but got %v", got)
+	}
+
+	serve("token")
+	if users != 2 || singletons != 1 {
+		t.Errorf("expect user per request and one singleton but got %d %d", users, singletons)
+	}
+
+	if code := serve(""); code != http.StatusUnauthorized || len(got) != 0 {
+		t.Errorf("expect provider error 401 but got %d %v", code, got)
+	}
+}
+
+func TestInjectMissingProvider(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expect missing provider panic")
+		}
+	}()
+	m := NewModule(nil, "m")
+	m.Get("/x", func(hd *THandler, db *tInjectDb) {})
+	NewRouter().RegisterModule(m)
+}
+
+func TestInjectErrorStopsPipeline(t *testing.T) {
+	var calls []string
+	srv := NewServer("inject_stop_test")
+	srv.ProvideRequest(func() (*tInjectUser, error) {
+		return nil, NewHttpError(http.StatusUnauthorized)
+	})
+	srv.HookBefore(nil, "/me", func(hd *THandler) {
+		calls = append(calls, "before")
+	})
+	srv.Get("/me", func(hd *THandler, user *tInjectUser) {
+		calls = append(calls, "main")
+	})
+	srv.HookAfter(nil, "/me", func(hd *THandler) {
+		calls = append(calls, "after")
+	})
+	srv.Router.RegisterModule(srv)
+	srv.Router.ErrorHandler = func(hd *THandler, err error) {
+		calls = append(calls, "error")
+	}
+
+	srv.Router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/me", nil))
+	if len(calls) != 2 || calls[0] != "before" || calls[1] != "error" {
+		t.Errorf("expect controllers skipped after injection error but got %v", calls)
+	}
+}
@@ -0,0 +1,142 @@
+package web
+
+import (
+	"reflect"
+	"sync"
+)
+
+/*
+	invoke 负责控制器的调用计划
+	@每个控制器的参数来源在注册模块时分析一次 请求时按计划填充参数 不再逐个判断参数类型
+	@计划按注入器区分 同一模块注册到多个服务器时 各自按自己的Provider生成
+	@计划由同一控制器的所有Route副本共享 未注册的控制器在第一次请求时生成
+	@参数来源 *THandler/http.ResponseWriter/*http.Request/Provider注入/动作结构体 其他类型为零值
+*/
+
+const (
+	ARG_ZERO     = iota // 零值
+	ARG_HANDLER         // *THandler
+	ARG_RESPONSE        // http.ResponseWriter
+	ARG_REQUEST         // *http.Request
+	ARG_INJECT          // 由服务器的Provider注入
+	ARG_ACTION          // 动作结构体 即方法的接收者 从actionPool取得
+)
+
+type (
+	// 控制器在各注入器下的调用计划
+	tInvokePlans struct {
+		plans sync.Map // *TInjector:*tInvokePlan
+	}
+
+	// 控制器的调用计划
+	tInvokePlan struct {
+		args       []tInvokeArg
+		actionType reflect.Type   // 动作结构体类型 没有时为nil
+		missing    []reflect.Type // 没有来源的参数类型
+	}
+
+	tInvokeArg struct {
+		kind int
+		typ  reflect.Type
+		zero reflect.Value
+	}
+)
+
+// 取得该注入器的调用计划 没有时生成
+func (self *tInvokePlans) get(aFuncType reflect.Type, aInjector *TInjector) *tInvokePlan {
+	if lPlan, ok := self.plans.Load(aInjector); ok {
+		return lPlan.(*tInvokePlan)
+	}
+
+	lPlan := &tInvokePlan{}
+	lPlan.compile(aFuncType, aInjector)
+	lActual, _ := self.plans.LoadOrStore(aInjector, lPlan)
+	return lActual.(*tInvokePlan)
+}
+
+// 生成调用计划 有Provider的类型优先注入 返回没有来源的参数类型
+func (self *tInvokePlan) compile(aFuncType reflect.Type, aInjector *TInjector) []reflect.Type {
+	if aFuncType == nil || aFuncType.Kind() != reflect.Func {
+		return nil
+	}
+
+	self.args = make([]tInvokeArg, aFuncType.NumIn())
+	for i := range self.args {
+		lParm := aFuncType.In(i)
+		lArg := &self.args[i]
+		lArg.typ = lParm
+
+		switch {
+		case lParm == handlerType:
+			lArg.kind = ARG_HANDLER
+		case lParm == requestType:
+			lArg.kind = ARG_REQUEST
+		case lParm == responseType:
+			lArg.kind = ARG_RESPONSE
+		case aInjector.Has(lParm):
+			lArg.kind = ARG_INJECT
+		case i == 0 && lParm.Kind() == reflect.Struct: //第一个是方法的结构自己本身 例：(self TMiddleware) ProcessRequest（）的 self
+			lArg.kind = ARG_ACTION
+			self.actionType = lParm
+		default:
+			lArg.kind = ARG_ZERO
+			lArg.zero = reflect.Zero(lParm)
+			self.missing = append(self.missing, lParm)
+		}
+	}
+	return self.missing
+}
+
+// 控制器的调用计划 未注册的控制器每次生成
+func (self *TRouter) ctrlPlan(aCtrl *TMethodType, aInjector *TInjector) *tInvokePlan {
+	if aCtrl.plans != nil {
+		return aCtrl.plans.get(aCtrl.FuncType, aInjector)
+	}
+
+	lPlan := &tInvokePlan{}
+	lPlan.compile(aCtrl.FuncType, aInjector)
+	return lPlan
+}
+
+// 从actionPool取得动作结构体
+func (self *TRouter) newAction(aType reflect.Type) reflect.Value {
+	lActionVal := self.actionPool.Get(aType)
+	if lActionVal.Kind() == reflect.Ptr { // 池新建的值为指针
+		lActionVal = lActionVal.Elem()
+	}
+	if !lActionVal.IsValid() {
+		lActionVal = reflect.New(aType).Elem() //由类生成实体值,必须指针转换而成才是Addressable
+	}
+	return lActionVal
+}
+
+// 按计划填充参数 args长度须与计划一致 aAction 为已取得的动作结构体 无效时从actionPool取得
+// 返回动作结构体的值和注入错误 出错时仍填充全部参数
+func (self *TRouter) invokeArgs(aPlan *tInvokePlan, hd *THandler, aResp reflect.Value, args []reflect.Value, aAction reflect.Value) (lActionVal reflect.Value, lErr error) {
+	for i := range aPlan.args {
+		lArg := &aPlan.args[i]
+		switch lArg.kind {
+		case ARG_HANDLER:
+			args[i] = hd.val
+		case ARG_RESPONSE:
+			args[i] = aResp
+		case ARG_REQUEST:
+			args[i] = reflect.ValueOf(hd.Request)
+		case ARG_INJECT:
+			lValue, err := self.injector().resolve(hd, lArg.typ)
+			if err != nil && lErr == nil {
+				lErr = err
+			}
+			args[i] = lValue
+		case ARG_ACTION:
+			lActionVal = aAction
+			if !lActionVal.IsValid() {
+				lActionVal = self.newAction(lArg.typ)
+			}
+			args[i] = lActionVal
+		default:
+			args[i] = lArg.zero
+		}
+	}
+	return
+}
@@ -0,0 +1,184 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type tInvokeAction struct {
+	calls int
+}
+
+func (self tInvokeAction) Show(hd *THandler, w http.ResponseWriter, req *http.Request) {
+	hd.RespondString(req.URL.Path)
+}
+
+func TestInvokePlan(t *testing.T) {
+	var before, after int
+	m := NewModule(nil, "m")
+	m.HookBefore(nil, "/x", func(hd *THandler) {
+		before++
+	})
+	m.Get("/x", tInvokeAction.Show)
+	m.HookAfter(nil, "/x", func(w http.ResponseWriter, hd *THandler) {
+		after++
+	})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	for i := 0; i < 2; i++ {
+		if w := serveTest(router, "GET", "/x"); w.Body.String() != "/x" {
+			t.Fatalf("unexpected response %q", w.Body.String())
+		}
+	}
+	if before != 2 || after != 2 {
+		t.Errorf("expect hooks called twice but got %d %d", before, after)
+	}
+
+	var plan tInvokePlan
+	plan.compile(reflect.TypeOf(tInvokeAction.Show), nil)
+	var kinds []int
+	for _, arg := range plan.args {
+		kinds = append(kinds, arg.kind)
+	}
+	if !reflect.DeepEqual(kinds, []int{ARG_ACTION, ARG_HANDLER, ARG_RESPONSE, ARG_REQUEST}) || plan.actionType != reflect.TypeOf(tInvokeAction{}) {
+		t.Errorf("unexpected plan %v %v", kinds, plan.actionType)
+	}
+}
+
+// 同一模块注册到Provider不同的两个服务器
+func TestInvokePlanPerInjector(t *testing.T) {
+	m := NewModule(nil, "m")
+	m.Get("/conf", func(conf tInjectConf, hd *THandler) {
+		hd.RespondString(fmt.Sprint(conf.Debug))
+	})
+
+	srvA := NewServer("invoke_plan_a") // 没有Provider 参数作为动作结构体
+	srvA.Router.RegisterModule(m)
+	srvB := NewServer("invoke_plan_b")
+	srvB.ProvideSingleton(func() tInjectConf {
+		return tInjectConf{Debug: true}
+	})
+	srvB.Router.RegisterModule(m)
+
+	if w := serveTest(srvA.Router, "GET", "/conf"); w.Body.String() != "false" {
+		t.Errorf("expect zero action value but got %q", w.Body.String())
+	}
+	if w := serveTest(srvB.Router, "GET", "/conf"); w.Body.String() != "true" {
+		t.Errorf("expect injected value but got %q", w.Body.String())
+	}
+}
+
+func benchShow(hd *THandler, w http.ResponseWriter, req *http.Request) {
+	hd.RespondString(req.URL.Path)
+}
+
+// 逐个判断参数类型 即调用计划之前routeHandler的做法 用于对比
+// args 由调用者在每个请求新建 在各控制器间复用并增长
+func legacyInvokeArgs(router *TRouter, hd *THandler, aResp reflect.Value, aFuncType reflect.Type, args []reflect.Value) []reflect.Value {
+	lInjector := router.injector()
+	args = args[:0]
+	for i := 0; i < aFuncType.NumIn(); i++ {
+		lParm := aFuncType.In(i)
+		switch lParm {
+		case reflect.TypeOf(hd):
+			args = append(args, hd.val)
+		default:
+			if lInjector.Has(lParm) {
+				lValue, _ := lInjector.resolve(hd, lParm)
+				args = append(args, lValue)
+				break
+			}
+			if i == 0 && lParm.Kind() == reflect.Struct {
+				args = append(args, reflect.New(lParm).Elem())
+				break
+			}
+			if strings.EqualFold(lParm.String(), "http.ResponseWriter") {
+				args = append(args, aResp)
+				break
+			}
+			if lParm == reflect.TypeOf(hd.Request) {
+				args = append(args, reflect.ValueOf(hd.Request))
+				break
+			}
+			args = append(args, reflect.Zero(lParm))
+		}
+	}
+	return args
+}
+
+// 与 BenchmarkRouteCtrls 相同的前置Hook+控制器+带注入参数的后置Hook 只计参数准备
+func benchInvoke(b *testing.B, legacy bool) {
+	benchServers++ // 服务器名称不能重复
+	srv := NewServer(fmt.Sprintf("bench_invoke_%d", benchServers))
+	srv.Provide(&tInjectDb{name: "main"})
+	router := srv.Router
+	hd := router.handlerPool.Get().(*THandler)
+	w := NewResponser()
+	w.connect(httptest.NewRecorder())
+	hd.connect(w, httptest.NewRequest("GET", "/x", nil), router, &TRoute{})
+
+	lTypes := []reflect.Type{
+		reflect.TypeOf(func(hd *THandler) {}),
+		reflect.TypeOf(benchShow),
+		reflect.TypeOf(func(w http.ResponseWriter, hd *THandler, db *tInjectDb) {}),
+	}
+	lPlans := make([]*tInvokePlan, len(lTypes))
+	for i, lType := range lTypes {
+		lPlans[i] = &tInvokePlan{}
+		lPlans[i].compile(lType, router.injector())
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if legacy {
+			var args []reflect.Value // 旧的routeHandler每个请求从nil开始
+			for _, lType := range lTypes {
+				args = legacyInvokeArgs(router, hd, w.val, lType, args)
+			}
+		} else {
+			for _, lPlan := range lPlans {
+				if cap(hd.args) < len(lPlan.args) {
+					hd.args = make([]reflect.Value, len(lPlan.args))
+				}
+				router.invokeArgs(lPlan, hd, w.val, hd.args[:len(lPlan.args)], reflect.Value{})
+			}
+		}
+	}
+}
+
+func BenchmarkInvokeArgsLegacy(b *testing.B) { benchInvoke(b, true) }
+func BenchmarkInvokeArgsPlan(b *testing.B)   { benchInvoke(b, false) }
+
+// 丢弃响应 避免基准测试计入httptest.NewRecorder的分配
+type tDiscardWriter struct{ header http.Header }
+
+func (self *tDiscardWriter) Header() http.Header         { return self.header }
+func (self *tDiscardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (self *tDiscardWriter) WriteHeader(int)             {}
+
+var benchServers int
+
+// 前置Hook+控制器+带注入参数的后置Hook的完整请求
+func BenchmarkRouteCtrls(b *testing.B) {
+	benchServers++ // 服务器名称不能重复
+	srv := NewServer(fmt.Sprintf("bench_route_ctrls_%d", benchServers))
+	srv.Provide(&tInjectDb{name: "main"})
+	srv.HookBefore(nil, "/x", func(hd *THandler) {})
+	srv.Get("/x", benchShow)
+	srv.HookAfter(nil, "/x", func(w http.ResponseWriter, hd *THandler, db *tInjectDb) {})
+	srv.Router.RegisterModule(srv)
+	req := httptest.NewRequest("GET", "/x", nil)
+	w := &tDiscardWriter{header: http.Header{}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		srv.Router.ServeHTTP(w, req)
+	}
+}
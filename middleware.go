@@ -30,6 +30,7 @@ type (
 		/*
 			this will call before current ruote
 			@controller: the action interface which middleware bindding
+				全局中间件每个请求只调用一次 为主控制器的动作结构体 主控制器不是结构体方法时为nil
 			@hd: the Handler interface for controller
 		*/
 		Request(controller interface{}, hd *THandler)
@@ -37,6 +38,7 @@ type (
 		/*
 			this will call after current ruote
 			@controller: the action interface which middleware bindding
+				全局中间件每个请求只调用一次 为主控制器的动作结构体 主控制器不是结构体方法时为nil
 			@hd: the Handler interface for controller
 		*/
 		Response(controller interface{}, hd *THandler)
@@ -7,6 +7,8 @@ package web
 */
 
 import (
+	"io/fs"
+	"net/http"
 	urls "net/url"
 	"os"
 	"path"
@@ -73,6 +75,9 @@ type (
 		//##########新特新等待优化################
 		Data []string //存储注册时导入的数据文件路径
 
+		ErrorHandler func(hd *THandler, err error) // 本模块路由的错误处理器 为空时使用父模块或Router的
+		TemplateFS   fs.FS                         // 本模块的模板文件系统 为空时使用父模块的或从硬盘读取
+
 	}
 )
 
@@ -244,8 +249,9 @@ func (self *TModule) SetParent(parent *TModule) {
 
 /*
 pos: true 为插入Before 反之After
+priority: Hook的优先级
 */
-func (self *TModule) url(rote_type RouteType, aMethod []string, url string, controller interface{}, scheme string, host string) *TRoute {
+func (self *TModule) url(rote_type RouteType, aMethod []string, url string, controller interface{}, scheme string, host string, priority int) *TRoute {
 	if rote_type != ProxyRoute && controller == nil {
 		logger.Panic("the route must binding a controller!")
 	}
@@ -282,6 +288,7 @@ func (self *TModule) url(rote_type RouteType, aMethod []string, url string, cont
 		Model:    self.Name,
 		Action:   "", //
 		Type:     rote_type,
+		module:   self,
 		//HookCtrl: make([]TMethodType, 0),
 		Ctrls: make([]TMethodType, 0),
 		//Host:     host,
@@ -296,9 +303,14 @@ func (self *TModule) url(rote_type RouteType, aMethod []string, url string, cont
 		}
 		route.isReverseProxy = true
 	}
+	route.origin = route // 合并产生的副本仍以返回的路由为准
 
 	lValueType := TMethodType{
-		FuncType: reflect.TypeOf(controller)}
+		FuncType: reflect.TypeOf(controller),
+		Module:   self.Name,
+		Priority: priority,
+		plans:    &tInvokePlans{},
+	}
 
 	//handler URL函数
 	if fv, ok := controller.(reflect.Value); ok { //****得到函数参数
@@ -306,10 +318,20 @@ func (self *TModule) url(rote_type RouteType, aMethod []string, url string, cont
 	} else {
 		lValueType.Func = reflect.ValueOf(controller)
 	}
+	if lValueType.Func.IsValid() {
+		lValueType.mapReply(lValueType.Func.Type())
+	}
 
 	//route.MainCtrl = append(route.MainCtrl, lValueType)
-	route.MainCtrl = lValueType
-	route.Ctrls = append(route.Ctrls, route.MainCtrl)
+	switch rote_type {
+	case HookBeforeRoute:
+		route.BeforeCtrls = []TMethodType{lValueType}
+	case HookAfterRoute:
+		route.AfterCtrls = []TMethodType{lValueType}
+	default:
+		route.MainCtrl = lValueType
+	}
+	route.combineCtrls()
 	//logger.Dbg("url", route.MainCtrl, aMethod)
 	for _, m := range aMethod {
 		self.Tree.AddRoute(m, url, route)
@@ -319,7 +341,7 @@ func (self *TModule) url(rote_type RouteType, aMethod []string, url string, cont
 }
 
 func (self *TModule) Get(url string, controller interface{}) *TRoute {
-	return self.url(CommomRoute, []string{"GET", "HEAD"}, url, controller, "", "")
+	return self.url(CommomRoute, []string{"GET", "HEAD"}, url, controller, "", "", 0)
 }
 
 func (self *TModule) Post(url string, controller interface{}) *TRoute {
@@ -328,36 +350,36 @@ func (self *TModule) Post(url string, controller interface{}) *TRoute {
 		self.FilePath = utils.CurDirName()
 	}*/
 
-	return self.url(CommomRoute, []string{"POST"}, url, controller, "", "")
+	return self.url(CommomRoute, []string{"POST"}, url, controller, "", "", 0)
 }
 
 func (self *TModule) Head(url string, controller interface{}) *TRoute {
-	return self.url(CommomRoute, []string{"HEAD"}, url, controller, "", "")
+	return self.url(CommomRoute, []string{"HEAD"}, url, controller, "", "", 0)
 }
 
 func (self *TModule) Options(url string, controller interface{}) *TRoute {
-	return self.url(CommomRoute, []string{"OPTIONS"}, url, controller, "", "")
+	return self.url(CommomRoute, []string{"OPTIONS"}, url, controller, "", "", 0)
 }
 
 func (self *TModule) Trace(url string, controller interface{}) *TRoute {
-	return self.url(CommomRoute, []string{"TRACE"}, url, controller, "", "")
+	return self.url(CommomRoute, []string{"TRACE"}, url, controller, "", "", 0)
 }
 
 func (self *TModule) Patch(url string, controller interface{}) *TRoute {
-	return self.url(CommomRoute, []string{"PATCH"}, url, controller, "", "")
+	return self.url(CommomRoute, []string{"PATCH"}, url, controller, "", "", 0)
 }
 
 func (self *TModule) Delete(url string, controller interface{}) *TRoute {
-	return self.url(CommomRoute, []string{"DELETE"}, url, controller, "", "")
+	return self.url(CommomRoute, []string{"DELETE"}, url, controller, "", "", 0)
 }
 
 func (self *TModule) Put(url string, controller interface{}) *TRoute {
-	return self.url(CommomRoute, []string{"PUT"}, url, controller, "", "")
+	return self.url(CommomRoute, []string{"PUT"}, url, controller, "", "", 0)
 }
 
 // 重组添加模块[URL]
 func (self *TModule) Url(url string, controller interface{}) *TRoute {
-	return self.url(CommomRoute, HttpMethods, url, controller, "", "")
+	return self.url(CommomRoute, HttpMethods, url, controller, "", "", 0)
 }
 
 //
@@ -371,7 +393,35 @@ func (self *TModule) Proxy(methods []string, url string, scheme string, host str
 		methods = HttpMethods
 	}
 
-	return self.url(ProxyRoute, methods, url, nil, scheme, host)
+	return self.url(ProxyRoute, methods, url, nil, scheme, host, 0)
+}
+
+// 挂载http.Handler
+// 前缀及其下所有路径的请求都交由该Handler处理,并经过路由器的全局中间件
+// strip 为true时去除前缀后再传递给Handler
+// @ Handle("/files", http.FileServer(http.Dir("files")), true)
+func (self *TModule) Handle(prefix string, handler http.Handler, strip ...bool) *TRoute {
+	if handler == nil {
+		logger.Panic("the handler must not be nil!")
+	}
+
+	lPrefix := strings.TrimRight(prefix, "/")
+	if len(strip) > 0 && strip[0] && lPrefix != "" {
+		handler = http.StripPrefix(lPrefix, handler)
+	}
+
+	lRoute := self.url(CommomRoute, HttpMethods, lPrefix+"/(*path)", func(hd *THandler) {
+		handler.ServeHTTP(hd.Response, hd.Request)
+	}, "", "", 0)
+
+	// 前缀本身
+	if lPrefix != "" {
+		for _, m := range HttpMethods {
+			self.Tree.AddRoute(m, lPrefix, lRoute)
+		}
+	}
+
+	return lRoute
 }
 
 /*
@@ -379,11 +429,29 @@ func (self *TModule) Proxy(methods []string, url string, scheme string, host str
 	xxx/xxx/id
 	xxx/xxx/(:name)
 	xxx/xxx/*
+	执行顺序: 前置Hook > 主控制器 > 后置Hook
+	priority: 同一阶段内数值小的先执行,相同时按模块注册顺序执行
+	methods 为nil时Hook所有方法
 */
-func (self *TModule) HookBefore(methods []string, url string, controller interface{}) {
-	self.url(HookBeforeRoute, methods, url, controller, "", "")
+func (self *TModule) HookBefore(methods []string, url string, controller interface{}, priority ...int) {
+	if methods == nil {
+		methods = HttpMethods
+	}
+
+	self.url(HookBeforeRoute, methods, url, controller, "", "", hookPriority(priority))
+}
+
+func (self *TModule) HookAfter(methods []string, url string, controller interface{}, priority ...int) {
+	if methods == nil {
+		methods = HttpMethods
+	}
+
+	self.url(HookAfterRoute, methods, url, controller, "", "", hookPriority(priority))
 }
 
-func (self *TModule) HookAfter(methods []string, url string, controller interface{}) {
-	self.url(HookAfterRoute, methods, url, controller, "", "")
+func hookPriority(priority []int) int {
+	if len(priority) > 0 {
+		return priority[0]
+	}
+	return 0
 }
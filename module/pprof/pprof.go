@@ -3,7 +3,7 @@ package pprof
 import (
 	"fmt"
 	"log"
-	//"net/http"
+	"net/http"
 	netpprof "net/http/pprof"
 	"os"
 	"path"
@@ -25,7 +25,7 @@ func init() {
 	pid = os.Getpid()
 
 	PprofModule = web.NewModule(nil, "")
-	PprofModule.Get("/debug/pprof/", netpprof.Index)
+	PprofModule.Handle("/debug/pprof", http.HandlerFunc(netpprof.Index)) // 其他profile由Index按名称处理
 	PprofModule.Get("/debug/pprof/cmdline", netpprof.Cmdline)
 	PprofModule.Get("/debug/pprof/profile", netpprof.Profile)
 	PprofModule.Get("/debug/pprof/symbol", netpprof.Symbol)
@@ -0,0 +1,225 @@
+package web
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+/*
+	msgpack 负责把数据编码为MessagePack 只提供编码
+	@整数使用能容纳其值的最短格式
+	@结构体编码为map 字段名按 msgpack > json 标签 > 字段名 的顺序取得
+	@时间编码为 RFC3339 字符串
+*/
+
+type tMsgPackEncoder struct {
+	buf bytes.Buffer
+}
+
+func encodeMsgPack(w io.Writer, v interface{}) error {
+	var lEncoder tMsgPackEncoder
+	if err := lEncoder.encode(reflect.ValueOf(v)); err != nil {
+		return err
+	}
+
+	_, err := w.Write(lEncoder.buf.Bytes())
+	return err
+}
+
+func (self *tMsgPackEncoder) encode(aVal reflect.Value) error {
+	for aVal.IsValid() && (aVal.Kind() == reflect.Ptr || aVal.Kind() == reflect.Interface) {
+		if aVal.IsNil() {
+			self.buf.WriteByte(0xc0)
+			return nil
+		}
+		aVal = aVal.Elem()
+	}
+	if !aVal.IsValid() {
+		self.buf.WriteByte(0xc0)
+		return nil
+	}
+
+	if aVal.Type() == timeType {
+		self.writeString(aVal.Interface().(time.Time).Format(time.RFC3339Nano))
+		return nil
+	}
+	if aVal.Type().Implements(textMarshalerType) {
+		lText, err := aVal.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		self.writeString(string(lText))
+		return nil
+	}
+
+	switch aVal.Kind() {
+	case reflect.Bool:
+		if aVal.Bool() {
+			self.buf.WriteByte(0xc3)
+		} else {
+			self.buf.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		self.writeInt(aVal.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		self.writeUint(aVal.Uint())
+	case reflect.Float32:
+		self.buf.WriteByte(0xca)
+		self.writeBig(uint64(math.Float32bits(float32(aVal.Float()))), 4)
+	case reflect.Float64:
+		self.buf.WriteByte(0xcb)
+		self.writeBig(math.Float64bits(aVal.Float()), 8)
+	case reflect.String:
+		self.writeString(aVal.String())
+
+	case reflect.Slice, reflect.Array:
+		if aVal.Kind() == reflect.Slice && aVal.IsNil() {
+			self.buf.WriteByte(0xc0)
+			return nil
+		}
+		if aVal.Type().Elem().Kind() == reflect.Uint8 {
+			lBytes := make([]byte, aVal.Len())
+			reflect.Copy(reflect.ValueOf(lBytes), aVal)
+			self.writeHeader(len(lBytes), 0, 0xc4, 0xc5, 0xc6)
+			self.buf.Write(lBytes)
+			return nil
+		}
+
+		self.writeHeader(aVal.Len(), 0x90, 0, 0xdc, 0xdd)
+		for i := 0; i < aVal.Len(); i++ {
+			if err := self.encode(aVal.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		if aVal.IsNil() {
+			self.buf.WriteByte(0xc0)
+			return nil
+		}
+
+		// # key排序使输出稳定
+		lKeys := aVal.MapKeys()
+		sort.Slice(lKeys, func(i, j int) bool {
+			return fmt.Sprint(lKeys[i].Interface()) < fmt.Sprint(lKeys[j].Interface())
+		})
+
+		self.writeHeader(len(lKeys), 0x80, 0, 0xde, 0xdf)
+		for _, k := range lKeys {
+			if err := self.encode(k); err != nil {
+				return err
+			}
+			if err := self.encode(aVal.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Struct:
+		var lValues []reflect.Value
+		var lNames []string
+		for _, f := range encodeFields(aVal.Type(), "msgpack", "json") {
+			lField, err := aVal.FieldByIndexErr(f.index)
+			if err != nil { // 内嵌的nil指针
+				continue
+			}
+			if f.omitEmpty && lField.IsZero() {
+				continue
+			}
+			lNames = append(lNames, f.name)
+			lValues = append(lValues, lField)
+		}
+
+		self.writeHeader(len(lNames), 0x80, 0, 0xde, 0xdf)
+		for i, lName := range lNames {
+			self.writeString(lName)
+			if err := self.encode(lValues[i]); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", aVal.Type())
+	}
+
+	return nil
+}
+
+func (self *tMsgPackEncoder) writeInt(i int64) {
+	switch {
+	case i >= 0:
+		self.writeUint(uint64(i))
+	case i >= -32:
+		self.buf.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		self.buf.WriteByte(0xd0)
+		self.buf.WriteByte(byte(i))
+	case i >= math.MinInt16:
+		self.buf.WriteByte(0xd1)
+		self.writeBig(uint64(i), 2)
+	case i >= math.MinInt32:
+		self.buf.WriteByte(0xd2)
+		self.writeBig(uint64(i), 4)
+	default:
+		self.buf.WriteByte(0xd3)
+		self.writeBig(uint64(i), 8)
+	}
+}
+
+func (self *tMsgPackEncoder) writeUint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		self.buf.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		self.buf.WriteByte(0xcc)
+		self.buf.WriteByte(byte(u))
+	case u <= math.MaxUint16:
+		self.buf.WriteByte(0xcd)
+		self.writeBig(u, 2)
+	case u <= math.MaxUint32:
+		self.buf.WriteByte(0xce)
+		self.writeBig(u, 4)
+	default:
+		self.buf.WriteByte(0xcf)
+		self.writeBig(u, 8)
+	}
+}
+
+func (self *tMsgPackEncoder) writeString(s string) {
+	if len(s) < 32 {
+		self.buf.WriteByte(0xa0 | byte(len(s)))
+	} else {
+		self.writeHeader(len(s), 0, 0xd9, 0xda, 0xdb)
+	}
+	self.buf.WriteString(s)
+}
+
+// 写出长度头 fix为0时没有fix格式 fix格式的最大长度为15 8位格式为0时不可用
+func (self *tMsgPackEncoder) writeHeader(n int, fix, b8, b16, b32 byte) {
+	switch {
+	case fix != 0 && n <= 15:
+		self.buf.WriteByte(fix | byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		self.buf.WriteByte(b8)
+		self.buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		self.buf.WriteByte(b16)
+		self.writeBig(uint64(n), 2)
+	default:
+		self.buf.WriteByte(b32)
+		self.writeBig(uint64(n), 4)
+	}
+}
+
+// 以大端序写出u的低size个字节
+func (self *tMsgPackEncoder) writeBig(u uint64, size int) {
+	var lBuf [8]byte
+	binary.BigEndian.PutUint64(lBuf[:], u)
+	self.buf.Write(lBuf[8-size:])
+}
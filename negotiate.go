@@ -0,0 +1,186 @@
+package web
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+	negotiate 负责按 Accept 头选择响应格式
+	@同一个控制器可同时服务浏览器和API客户端
+
+	hd.Negotiate(lData, TNegotiateOptions{Template: "order.html"})
+
+	@选择规则
+	1.按q值选择 q=0 表示拒绝
+	2.q值相同时按 Offers 的顺序 即服务端的偏好
+	3.没有 Accept 头时使用第一个可提供的格式
+	4.没有合适的格式时以406交给 hd.HandleError 与其他错误相同地响应
+	5.Offers 不区分大小写 参数(如 charset)不参与匹配
+*/
+
+const (
+	MIME_JSON = "application/json"
+	MIME_XML  = "application/xml"
+	MIME_HTML = "text/html"
+	MIME_TEXT = "text/plain"
+)
+
+type (
+	// 内容协商选项
+	TNegotiateOptions struct {
+		Template string   // HTML模板 为空时不提供HTML
+		Offers   []string // 可提供的MIME类型 按偏好排序 为空时为 JSON/XML/HTML/TEXT 除HTML外需注册有编码器
+	}
+
+	// Accept 中的一项
+	tAcceptRange struct {
+		typ    string
+		sub    string
+		q      float64
+		params int // 除q外的参数个数 用于比较精确度
+	}
+)
+
+// 按请求的Accept选择格式响应data 返回选中的MIME类型 无合适格式时响应406并返回空字符串
+func (self *THandler) Negotiate(data interface{}, opts ...TNegotiateOptions) string {
+	var lOpts TNegotiateOptions
+	if len(opts) > 0 {
+		lOpts = opts[0]
+	}
+
+	lOffers := make([]string, len(lOpts.Offers))
+	for i, lOffer := range lOpts.Offers {
+		lOffers[i] = mediaType(lOffer)
+	}
+	if len(lOffers) == 0 {
+		lOffers = []string{MIME_JSON, MIME_XML, MIME_HTML, MIME_TEXT}
+	}
+	if lOpts.Template == "" {
+		lOffers = removeOffer(lOffers, MIME_HTML)
+	}
+
+	addVary(self.Header(), "Accept")
+	lType := NegotiateType(self.Request.Header.Get("Accept"), lOffers)
+	if lType == "" {
+		self.HandleError(NewHttpError(http.StatusNotAcceptable, "available: "+strings.Join(lOffers, ", ")))
+		return ""
+	}
+
+	switch {
+	case lType == MIME_HTML:
+		self.RenderTemplate(lOpts.Template, data)
+	case GetEncoder(lType) != nil:
+		self.respondEncoded(lType, data)
+	default:
+		logger.Panic("can not respond the offer %q", lType)
+	}
+
+	return lType
+}
+
+// 从offers中选择最符合accept的MIME类型 没有时返回空字符串
+// 返回的类型为小写且不含参数 如 "Text/HTML; charset=utf-8" 返回 "text/html"
+func NegotiateType(accept string, offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if strings.TrimSpace(accept) == "" {
+		return mediaType(offers[0])
+	}
+
+	lRanges := parseAccept(accept)
+	lBest, lBestQ := "", 0.0
+	for _, lOffer := range offers {
+		lOffer = mediaType(lOffer)
+		lType, lSub, _ := strings.Cut(lOffer, "/")
+
+		// # 找到最精确的匹配项 其q值决定该offer的q值
+		lQ, lMatched, lSpecific := 0.0, false, -1
+		for _, r := range lRanges {
+			lScore := -1
+			switch {
+			case r.typ == lType && r.sub == lSub:
+				lScore = 2
+			case r.typ == lType && r.sub == "*":
+				lScore = 1
+			case r.typ == "*" && r.sub == "*":
+				lScore = 0
+			}
+			if lScore < 0 {
+				continue
+			}
+
+			lScore = lScore*100 + r.params
+			if lScore > lSpecific {
+				lSpecific, lQ, lMatched = lScore, r.q, true
+			}
+		}
+
+		if lMatched && lQ > lBestQ {
+			lBest, lBestQ = lOffer, lQ
+		}
+	}
+
+	return lBest
+}
+
+// 解析Accept头 按q值倒序
+func parseAccept(aAccept string) []tAcceptRange {
+	var lRanges []tAcceptRange
+	for _, lPart := range strings.Split(aAccept, ",") {
+		lParams := strings.Split(lPart, ";")
+		lType, lSub, ok := strings.Cut(strings.ToLower(strings.TrimSpace(lParams[0])), "/")
+		if !ok || lType == "" || lSub == "" {
+			continue
+		}
+
+		lRange := tAcceptRange{typ: lType, sub: lSub, q: 1}
+		for _, lParam := range lParams[1:] {
+			lKey, lValue, _ := strings.Cut(strings.TrimSpace(lParam), "=")
+			if strings.EqualFold(lKey, "q") {
+				if q, err := strconv.ParseFloat(lValue, 64); err == nil && q >= 0 && q <= 1 {
+					lRange.q = q
+				}
+				continue
+			}
+			lRange.params++
+		}
+		lRanges = append(lRanges, lRange)
+	}
+
+	sort.SliceStable(lRanges, func(i, j int) bool {
+		return lRanges[i].q > lRanges[j].q
+	})
+	return lRanges
+}
+
+// 去掉参数并小写的MIME类型
+func mediaType(aMime string) string {
+	lType, _, _ := strings.Cut(aMime, ";")
+	return strings.ToLower(strings.TrimSpace(lType))
+}
+
+func removeOffer(aOffers []string, aOffer string) []string {
+	lOffers := make([]string, 0, len(aOffers))
+	for _, o := range aOffers {
+		if o != aOffer {
+			lOffers = append(lOffers, o)
+		}
+	}
+	return lOffers
+}
+
+// 添加Vary 已存在时不重复添加
+func addVary(aHeader http.Header, aField string) {
+	for _, lValue := range aHeader.Values("Vary") {
+		for _, lField := range strings.Split(lValue, ",") {
+			if strings.EqualFold(strings.TrimSpace(lField), aField) {
+				return
+			}
+		}
+	}
+	aHeader.Add("Vary", aField)
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/VectorsOrigin/template"
+)
+
+func TestNegotiateType(t *testing.T) {
+	offers := []string{MIME_JSON, MIME_XML, MIME_HTML, MIME_TEXT}
+	cases := []struct {
+		accept string
+		offers []string
+		expect string
+	}{
+		{"", offers, MIME_JSON},
+		{"*/*", offers, MIME_JSON},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", offers, MIME_HTML},
+		{"application/xml", offers, MIME_XML},
+		{"text/*", offers, MIME_HTML},
+		{"text/*;q=0.5, text/plain", offers, MIME_TEXT},
+		{"application/json;q=0.2, application/xml;q=0.8", offers, MIME_XML},
+		{"*/*, application/json;q=0", offers, MIME_XML},
+		{"text/html", []string{MIME_JSON, MIME_XML}, ""},
+		{"image/png", offers, ""},
+		{"garbage", offers, ""},
+		{"text/html", []string{"Text/HTML; charset=utf-8"}, MIME_HTML},
+		{"", []string{"Application/JSON"}, MIME_JSON},
+	}
+	for _, c := range cases {
+		if v := NegotiateType(c.accept, c.offers); v != c.expect {
+			t.Errorf("Accept %q: expect %q but got %q", c.accept, c.expect, v)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	type order struct {
+		Id   int    `json:"id" xml:"id"`
+		Name string `json:"name" xml:"name"`
+	}
+
+	m := NewModule(nil, "m")
+	m.Get("/order", func(hd *THandler) {
+		hd.Negotiate(order{1, "book"})
+	})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	cases := []struct {
+		accept string
+		code   int
+		ctype  string
+		body   string
+	}{
+		{"application/json", 200, "application/json; charset=UTF-8", `{"id":1,"name":"book"}`},
+		{"application/xml", 200, "application/xml; charset=utf-8", `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<order><id>1</id><name>book</name></order>`},
+		{"text/plain", 200, "text/plain; charset=utf-8", "{1 book}"},
+		{"text/html", 406, "text/plain; charset=utf-8", "available: application/json, application/xml, text/plain\n"},
+		{"application/problem+json, application/json;q=0", 406, MIME_PROBLEM, ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/order", nil)
+		req.Header.Set("Accept", c.accept)
+		router.ServeHTTP(w, req)
+
+		if w.Code != c.code || w.Header().Get("Content-Type") != c.ctype {
+			t.Errorf("Accept %q: expect %d %q but got %d %q", c.accept, c.code, c.ctype, w.Code, w.Header().Get("Content-Type"))
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			t.Errorf("Accept %q: unexpected body %q", c.accept, w.Body.String())
+		}
+		if w.Header().Get("Vary") != "Accept" {
+			t.Errorf("Accept %q: expect Vary Accept but got %q", c.accept, w.Header().Get("Vary"))
+		}
+	}
+}
+
+// 406 与其他错误相同 由错误处理器和错误页面响应
+func TestNegotiateNotAcceptable(t *testing.T) {
+	var handled error
+	m := NewModule(nil, "m")
+	m.Templates(fstest.MapFS{
+		"406.html": {Data: []byte(`{{.Status}} {{.Title}}`)},
+	})
+	m.Get("/order", func(hd *THandler) {
+		hd.Negotiate("book", TNegotiateOptions{Offers: []string{"Application/JSON; charset=utf-8"}})
+	})
+	router := NewRouter()
+	router.Template = template.NewTemplateSet()
+	router.RegisterModule(m)
+
+	req := httptest.NewRequest("GET", "/order", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != `"book"` {
+		t.Errorf("expect offer with parameters matched but got %d %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/order", nil)
+	req.Header.Set("Accept", MIME_HTML)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotAcceptable || w.Body.String() != "406 Not Acceptable" {
+		t.Errorf("expect 406 error page but got %d %q", w.Code, w.Body.String())
+	}
+
+	router.ErrorHandler = func(hd *THandler, err error) {
+		handled = err
+		hd.RespondWithError(err)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	var lErr *THttpError
+	if !errors.As(handled, &lErr) || lErr.Status != http.StatusNotAcceptable {
+		t.Errorf("expect 406 passed to the error handler but got %v", handled)
+	}
+}
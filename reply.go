@@ -0,0 +1,100 @@
+package web
+
+import (
+	"reflect"
+)
+
+/*
+	reply 负责控制器的返回值
+	@支持的返回值 无 / error / T / (T, error) / (int, T) 其中int为状态码
+	@返回的error交给 hd.HandleError 处理 返回的数据按 Accept 协商格式响应
+	@string和[]byte直接作为响应内容 数据为nil时只写出状态码(如有)
+	@控制器已写出响应时忽略返回的数据
+
+	m.Get("/order/(:id)", func(hd *THandler) (*TOrder, error) {
+		return db.FindOrder(hd.PathParams().AsInteger("id"))
+	})
+	m.Post("/order", func(hd *THandler) (int, *TOrder) {
+		return http.StatusCreated, lOrder
+	})
+*/
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// 分析控制器的返回值 不支持的返回值在注册时报错
+func (self *TMethodType) mapReply(aFuncType reflect.Type) {
+	self.replyStatus, self.replyData, self.replyErr = -1, -1, -1
+	if aFuncType.Kind() != reflect.Func {
+		return
+	}
+
+	switch aFuncType.NumOut() {
+	case 0:
+	case 1:
+		if aFuncType.Out(0) == errorType {
+			self.replyErr = 0
+		} else {
+			self.replyData = 0
+		}
+	case 2:
+		if aFuncType.Out(1) == errorType {
+			self.replyData, self.replyErr = 0, 1
+		} else if aFuncType.Out(0).Kind() == reflect.Int {
+			self.replyStatus, self.replyData = 0, 1
+		} else {
+			logger.Panic("the controller %v must return (T, error) or (int, T)!", aFuncType)
+		}
+	default:
+		logger.Panic("the controller %v returns too many values!", aFuncType)
+	}
+
+	if self.replyData >= 0 {
+		self.ReplyType = aFuncType.Out(self.replyData)
+	}
+}
+
+// 处理控制器的返回值
+func (self *THandler) reply(aCtrl *TMethodType, aOut []reflect.Value) {
+	if aCtrl.replyErr >= 0 {
+		if err, _ := aOut[aCtrl.replyErr].Interface().(error); err != nil {
+			self.HandleError(err)
+			return
+		}
+	}
+	if aCtrl.replyData < 0 || self.Response.Written() {
+		return
+	}
+
+	lStatus := 0
+	if aCtrl.replyStatus >= 0 {
+		lStatus = int(aOut[aCtrl.replyStatus].Int())
+	}
+
+	lData := aOut[aCtrl.replyData]
+	switch lData.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+		if lData.IsNil() {
+			if lStatus != 0 {
+				self.WriteHeader(lStatus)
+			}
+			return
+		}
+	}
+
+	switch v := lData.Interface().(type) {
+	case string:
+		self.Header().Set("Content-Type", GetEncoder(MIME_TEXT).ContentType)
+		self.RespondString(v)
+	case []byte:
+		self.Respond(v)
+	default:
+		self.Negotiate(v)
+	}
+
+	// 流式格式已写出 状态码为200
+	if lStatus != 0 && !self.Response.Written() {
+		self.WriteHeader(lStatus)
+		self.Write(self.Result)
+		self.Result = nil
+	}
+}
@@ -0,0 +1,89 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type tReplyOrder struct {
+	Id   int    `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name"`
+}
+
+func TestControllerReply(t *testing.T) {
+	m := NewModule(nil, "m")
+	m.Get("/order", func(hd *THandler) (*tReplyOrder, error) {
+		return &tReplyOrder{Id: 1, Name: "book"}, nil
+	})
+	m.Get("/missing", func(hd *THandler) (*tReplyOrder, error) {
+		return nil, errors.New("db down")
+	})
+	m.Post("/order", func(hd *THandler) (int, *tReplyOrder) {
+		return http.StatusCreated, &tReplyOrder{Id: 2}
+	})
+	m.Delete("/order", func(hd *THandler) (int, *tReplyOrder) {
+		return http.StatusNoContent, nil
+	})
+	m.Get("/text", func(hd *THandler) string {
+		return "hello"
+	})
+	m.Get("/ok", func(hd *THandler) error {
+		hd.RespondString("plain")
+		return nil
+	})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	do := func(method, url, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("GET", "/order", ""); w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"id":1,"name":"book"}` {
+		t.Errorf("unexpected json reply %d %q", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/order", MIME_XML); !strings.HasPrefix(w.Header().Get("Content-Type"), MIME_XML) || !strings.Contains(w.Body.String(), "<name>book</name>") {
+		t.Errorf("unexpected xml reply %q", w.Body.String())
+	}
+	if w := do("GET", "/missing", ""); w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "db down") {
+		t.Errorf("expect 500 without error detail but got %d %q", w.Code, w.Body.String())
+	}
+	if w := do("POST", "/order", ""); w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"id":2`) {
+		t.Errorf("unexpected status reply %d %q", w.Code, w.Body.String())
+	}
+	if w := do("DELETE", "/order", ""); w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("unexpected empty reply %d %q", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/text", ""); w.Body.String() != "hello" {
+		t.Errorf("unexpected text reply %q", w.Body.String())
+	}
+	if w := do("GET", "/ok", ""); w.Body.String() != "plain" {
+		t.Errorf("unexpected reply %q", w.Body.String())
+	}
+
+	var handled error
+	router.ErrorHandler = func(hd *THandler, err error) {
+		handled = err
+		hd.Abort(http.StatusServiceUnavailable, "later")
+	}
+	if w := do("GET", "/missing", ""); w.Code != http.StatusServiceUnavailable || handled == nil || handled.Error() != "db down" {
+		t.Errorf("expect custom error handler but got %d %v", w.Code, handled)
+	}
+}
+
+func TestControllerReplyInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expect invalid controller return panic")
+		}
+	}()
+	NewModule(nil, "m").Get("/bad", func(hd *THandler) (string, string) { return "", "" })
+}
@@ -0,0 +1,226 @@
+package web
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+	resolver 负责把URL解析为程序文件夹下的静态文件
+	@地址格式
+	/robots.txt                 程序文件夹根目录 只开放 RootExts 中的扩展名
+	/static/js/base.js          程序 static 文件夹
+	/shop/static/js/base.js     模块 module/shop/static 文件夹 Fallback 时再找程序 static 文件夹
+
+	@安全
+	1.文件必须位于对应的静态根目录内 包括符号链接解析后的真实路径 预压缩的 .br/.gz 文件同样检查
+	2.任何以 . 开头的路径段(隐藏文件和 ..)都不会被解析
+	3.只响应普通文件 目录仅在开启 Index 时响应其 index.html
+
+	@SPA
+	resolver.AddSPA("/app", "/static/app/index.html")
+	/app 下无扩展名且找不到文件的地址响应 /static/app/index.html
+*/
+
+type (
+	// 静态文件解析器 配置需在服务启动前完成
+	TStaticResolver struct {
+		RootExts []string // 根目录允许的扩展名
+		Exts     []string // 静态文件夹允许的扩展名 为空时不限制
+		Fallback bool     // 模块静态文件夹找不到时使用程序static文件夹
+		Index    bool     // 目录请求响应目录下的 index.html
+
+		root string
+		fs   *TStaticFS
+		spa  []tSpaRule // 按前缀长度倒序
+	}
+
+	// 静态文件候选位置
+	tStaticCandidate struct {
+		base string // 静态根目录 相对程序文件夹 "" 表示程序文件夹本身
+		name string // 相对静态根目录的文件名
+	}
+
+	tSpaRule struct {
+		prefix string
+		index  string
+	}
+)
+
+// 创建以aRoot为程序文件夹的静态文件解析器
+func NewStaticResolver(aRoot string) *TStaticResolver {
+	lResolver := &TStaticResolver{
+		RootExts: []string{".txt", ".html", ".htm"},
+		Fallback: true,
+		root:     aRoot,
+		fs:       NewStaticFS(os.DirFS(aRoot)),
+	}
+	lResolver.fs.allow = lResolver.allowVariant
+	return lResolver
+}
+
+// 为前缀aPrefix添加SPA入口 aIndexUrl 为入口文件的静态地址
+func (self *TStaticResolver) AddSPA(aPrefix, aIndexUrl string) {
+	self.spa = append(self.spa, tSpaRule{
+		prefix: path.Clean("/" + aPrefix),
+		index:  aIndexUrl,
+	})
+	sort.SliceStable(self.spa, func(i, j int) bool {
+		return len(self.spa[i].prefix) > len(self.spa[j].prefix)
+	})
+}
+
+// 解析地址 返回相对程序文件夹并以/分隔的文件路径
+func (self *TStaticResolver) Resolve(aUrlPath string) (string, bool) {
+	if lName, ok := self.resolve(aUrlPath); ok {
+		return lName, true
+	}
+
+	// # 有扩展名的地址视为资源文件 不使用SPA入口
+	lPath := path.Clean("/" + aUrlPath)
+	if path.Ext(lPath) != "" {
+		return "", false
+	}
+
+	for _, lRule := range self.spa {
+		if lPath == lRule.prefix || strings.HasPrefix(lPath, lRule.prefix+"/") || lRule.prefix == "/" {
+			return self.resolve(lRule.index)
+		}
+	}
+
+	return "", false
+}
+
+func (self *TStaticResolver) resolve(aUrlPath string) (string, bool) {
+	for _, lCandidate := range self.candidates(aUrlPath) {
+		if lName, ok := self.stat(lCandidate); ok {
+			return lName, true
+		}
+	}
+
+	return "", false
+}
+
+// 按优先顺序列出地址对应的文件位置
+func (self *TStaticResolver) candidates(aUrlPath string) []tStaticCandidate {
+	lSegs := strings.Split(strings.Trim(path.Clean("/"+aUrlPath), "/"), "/")
+	for _, lSeg := range lSegs {
+		// # Windows下 \ 和 : 也可用于穿越目录
+		if strings.HasPrefix(lSeg, ".") || strings.ContainsAny(lSeg, `\:`) {
+			return nil
+		}
+	}
+
+	switch {
+	case len(lSegs) == 1:
+		if lSegs[0] == "" || !hasExt(self.RootExts, lSegs[0]) {
+			return nil
+		}
+		return []tStaticCandidate{{"", lSegs[0]}}
+
+	case strings.EqualFold(lSegs[0], STATIC_DIR):
+		return []tStaticCandidate{{STATIC_DIR, path.Join(lSegs[1:]...)}}
+
+	case len(lSegs) > 2 && strings.EqualFold(lSegs[1], STATIC_DIR):
+		lName := path.Join(lSegs[2:]...)
+		lCandidates := []tStaticCandidate{{path.Join(MODULE_DIR, lSegs[0], STATIC_DIR), lName}}
+		if self.Fallback {
+			lCandidates = append(lCandidates, tStaticCandidate{STATIC_DIR, lName})
+		}
+		return lCandidates
+	}
+
+	return nil
+}
+
+// 检查候选文件 必须是位于静态根目录内的普通文件
+func (self *TStaticResolver) stat(aCandidate tStaticCandidate) (string, bool) {
+	lBase := filepath.Join(self.root, filepath.FromSlash(aCandidate.base))
+	lName := aCandidate.name
+	lFile := filepath.Join(lBase, filepath.FromSlash(lName))
+
+	fi, err := os.Stat(lFile)
+	if err != nil {
+		return "", false
+	}
+
+	if fi.IsDir() {
+		if !self.Index {
+			return "", false
+		}
+
+		lName = path.Join(lName, "index.html")
+		lFile = filepath.Join(lFile, "index.html")
+		if fi, err = os.Stat(lFile); err != nil {
+			return "", false
+		}
+	}
+
+	if !fi.Mode().IsRegular() {
+		return "", false
+	}
+
+	if aCandidate.base == "" {
+		if !hasExt(self.RootExts, lName) {
+			return "", false
+		}
+	} else if len(self.Exts) > 0 && !hasExt(self.Exts, lName) {
+		return "", false
+	}
+
+	if !confined(lBase, lFile) {
+		return "", false
+	}
+
+	return path.Join(aCandidate.base, lName), true
+}
+
+// 检查已解析文件的预压缩文件 aName 为相对程序文件夹的路径 如 static/js/app.js.br
+// 须是同一静态根目录内的普通文件
+func (self *TStaticResolver) allowVariant(aName string) bool {
+	lSegs := strings.Split(aName, "/")
+	lBase := ""
+	switch {
+	case len(lSegs) > 1 && lSegs[0] == STATIC_DIR:
+		lBase = STATIC_DIR
+	case len(lSegs) > 3 && lSegs[0] == MODULE_DIR && lSegs[2] == STATIC_DIR:
+		lBase = path.Join(lSegs[:3]...)
+	}
+
+	lFile := filepath.Join(self.root, filepath.FromSlash(aName))
+	fi, err := os.Stat(lFile)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+
+	return confined(filepath.Join(self.root, filepath.FromSlash(lBase)), lFile)
+}
+
+// 符号链接解析后文件仍须在根目录内
+func confined(aBase, aFile string) bool {
+	lRealBase, err := filepath.EvalSymlinks(aBase)
+	if err != nil {
+		return false
+	}
+	lRealFile, err := filepath.EvalSymlinks(aFile)
+	if err != nil {
+		return false
+	}
+	lRel, err := filepath.Rel(lRealBase, lRealFile)
+	return err == nil && lRel != ".." && !strings.HasPrefix(lRel, ".."+string(filepath.Separator))
+}
+
+// 文件扩展名是否在列表中 不区分大小写
+func hasExt(aExts []string, aName string) bool {
+	lExt := path.Ext(aName)
+	for _, e := range aExts {
+		if strings.EqualFold(e, lExt) {
+			return true
+		}
+	}
+
+	return false
+}
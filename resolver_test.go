@@ -0,0 +1,117 @@
+package web
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	for name, data := range files {
+		lFile := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(lFile), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(lFile, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStaticResolver(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"robots.txt":                    "robots",
+		"main.go":                       "package main",
+		"static/js/base.js":             "base",
+		"static/css/common.css":         "common",
+		"static/.env":                   "secret",
+		"static/docs/index.html":        "docs",
+		"static/app/index.html":         "app",
+		"module/shop/static/js/shop.js": "shop",
+		"module/shop/static/js/base.js": "shop base",
+		"module/shop/config.ini":        "secret",
+	})
+	writeTestFiles(t, outside, map[string]string{"passwd": "secret"})
+
+	links := map[string]string{
+		"static/escape.txt":    filepath.Join(outside, "passwd"),
+		"static/main.go":       filepath.Join(root, "main.go"),
+		"static/alias.js":      filepath.Join(root, "static", "js", "base.js"),
+		"static/js/base.js.gz": filepath.Join(outside, "passwd"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skip("symlink is not supported:", err)
+		}
+	}
+
+	resolver := NewStaticResolver(root)
+	cases := []struct {
+		name  string
+		url   string
+		file  string
+		setup func(*TStaticResolver)
+	}{
+		{"root allowed ext", "/robots.txt", "robots.txt", nil},
+		{"root denied ext", "/main.go", "", nil},
+		{"root custom ext", "/main.go", "main.go", func(r *TStaticResolver) { r.RootExts = []string{".go"} }},
+		{"app static", "/static/js/base.js", "static/js/base.js", nil},
+		{"static dir case", "/Static/js/base.js", "static/js/base.js", nil},
+		{"module static", "/shop/static/js/shop.js", "module/shop/static/js/shop.js", nil},
+		{"module before app", "/shop/static/js/base.js", "module/shop/static/js/base.js", nil},
+		{"module fallback", "/shop/static/css/common.css", "static/css/common.css", nil},
+		{"module no fallback", "/shop/static/css/common.css", "", func(r *TStaticResolver) { r.Fallback = false }},
+		{"module without static", "/shop/config.ini", "", nil},
+		{"short module path", "/shop/static", "", nil},
+		{"empty", "/", "", nil},
+		{"traversal", "/static/../main.go", "", nil},
+		{"module traversal", "/shop/static/../../module/shop/config.ini", "", nil},
+		{"backslash", `/static/js\..\..\main.go`, "", nil},
+		{"hidden file", "/static/.env", "", nil},
+		{"symlink escape", "/static/escape.txt", "", nil},
+		{"symlink to root", "/static/main.go", "", nil},
+		{"symlink inside", "/static/alias.js", "static/alias.js", nil},
+		{"ext allowlist", "/static/css/common.css", "", func(r *TStaticResolver) { r.Exts = []string{".js"} }},
+		{"ext allowlist ok", "/static/js/base.js", "static/js/base.js", func(r *TStaticResolver) { r.Exts = []string{".JS"} }},
+		{"dir without index", "/static/docs", "", nil},
+		{"dir index", "/static/docs/", "static/docs/index.html", func(r *TStaticResolver) { r.Index = true }},
+		{"spa route", "/app/users/1", "static/app/index.html", func(r *TStaticResolver) { r.AddSPA("/app", "/static/app/index.html") }},
+		{"spa prefix", "/app", "static/app/index.html", func(r *TStaticResolver) { r.AddSPA("/app/", "/static/app/index.html") }},
+		{"spa asset", "/app/missing.js", "", func(r *TStaticResolver) { r.AddSPA("/app", "/static/app/index.html") }},
+		{"spa other prefix", "/application", "", func(r *TStaticResolver) { r.AddSPA("/app", "/static/app/index.html") }},
+	}
+	for _, c := range cases {
+		r := *resolver
+		if c.setup != nil {
+			c.setup(&r)
+		}
+
+		file, ok := r.Resolve(c.url)
+		if ok != (c.file != "") || file != c.file {
+			t.Errorf("%s: resolve %q expect %q but got %q %v", c.name, c.url, c.file, file, ok)
+		}
+	}
+
+	// 路由使用解析器响应
+	router := NewRouter()
+	router.StaticResolver = resolver
+	if w := serveTest(router, "GET", "/shop/static/css/common.css"); w.Code != 200 || w.Body.String() != "common" {
+		t.Errorf("expect fallback file served but got %d %q", w.Code, w.Body.String())
+	}
+	if w := serveTest(router, "GET", "/static/escape.txt"); w.Code != 404 {
+		t.Errorf("expect 404 for symlink escape but got %d", w.Code)
+	}
+	req := httptest.NewRequest("GET", "/static/js/base.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Body.String() != "base" || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expect symlinked variant outside the root ignored but got %q %q", w.Header().Get("Content-Encoding"), w.Body.String())
+	}
+	if w := serveTest(router, "POST", "/static/js/base.js"); w.Code != 404 {
+		t.Errorf("expect 404 for POST to static file but got %d", w.Code)
+	}
+}
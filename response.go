@@ -25,6 +25,8 @@ type (
 		status int
 		size   int
 
+		beforeWriteHeader []func() // 写出响应头前执行 如保存会话Cookie
+
 		val reflect.Value
 	}
 )
@@ -35,6 +37,7 @@ func NewResponser() *TResponseWriter {
 	return resp
 }
 func (self *TResponseWriter) WriteHeader(s int) {
+	self.runBeforeWriteHeader()
 	self.status = s
 	self.ResponseWriter.WriteHeader(s)
 }
@@ -49,6 +52,21 @@ func (self *TResponseWriter) Write(b []byte) (int, error) {
 	return size, err
 }
 
+// 添加写出响应头前执行的函数 此时仍可修改响应头
+func (self *TResponseWriter) BeforeWriteHeader(fn func()) {
+	self.beforeWriteHeader = append(self.beforeWriteHeader, fn)
+}
+
+// 执行并清空写出响应头前的函数 接管连接自行写出响应头时须先调用
+func (self *TResponseWriter) runBeforeWriteHeader() {
+	if lFuncs := self.beforeWriteHeader; lFuncs != nil {
+		self.beforeWriteHeader = nil
+		for _, fn := range lFuncs {
+			fn()
+		}
+	}
+}
+
 func (self *TResponseWriter) Written() bool {
 	return self.status != 0
 }
@@ -65,7 +83,11 @@ func (self *TResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if !ok {
 		return nil, nil, fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
 	}
-	return hijacker.Hijack()
+	conn, buf, err := hijacker.Hijack()
+	if err == nil {
+		self.status = http.StatusSwitchingProtocols // 连接已接管 不能再写出响应
+	}
+	return conn, buf, err
 }
 
 func (self *TResponseWriter) Status() int {
@@ -93,4 +115,5 @@ func (self *TResponseWriter) connect(w http.ResponseWriter) {
 	self.ResponseWriter = w
 	self.status = 0
 	self.size = 0
+	self.beforeWriteHeader = nil
 }
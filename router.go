@@ -2,6 +2,9 @@ package web
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	//_template "html/template"
 	"net"
 	"net/http"
@@ -11,8 +14,10 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VectorsOrigin/template"
@@ -50,7 +55,12 @@ type (
 		//ArgType   []reflect.Type // 参数组类型
 		//ReplyType []reflect.Type //TODO 返回多结果
 		ArgType   reflect.Type // 参数组类型
-		ReplyType reflect.Type //TODO 返回多结果
+		ReplyType reflect.Type // 返回数据的类型 没有返回数据时为nil
+		Module    string       // 注册该控制器的模块名称
+		Priority  int          // Hook优先级 同一阶段内数值小的先执行 相同时按注册顺序
+
+		replyStatus, replyData, replyErr int           // 状态码/数据/error 在返回值中的位置 -1为没有
+		plans                            *tInvokePlans // 各注入器的调用计划 所有副本共享
 	}
 
 	// TRoute 路,表示一个Link 连接地址"../webgo/"
@@ -62,13 +72,24 @@ type (
 		Model          string // 模型/对象/模块名称 Tmodule/Tmodel, "Model.Action", "404"
 		Action         string // 动作名称[包含模块名，动作名] "Model.Action", "/index.html","/filename.png"
 		FileName       string
+		Name           string    // 路由名称 用于删除路由
 		Type           RouteType // Route 类型 决定合并的形式
 		Host           *url.URL
 		isReverseProxy bool //# 是反向代理
 		isDynRoute     bool // 是否*动态路由   /base/*.html
 
-		MainCtrl TMethodType   // 主控制器 每个Route都会有一个主要的Ctrl,其他为Hook的Ctrl
-		Ctrls    []TMethodType // 最终控制器 合并主控制器+次控制器
+		static *TStaticFS    // 静态文件挂载的文件系统
+		upload *TUploadLimit // 上传限制 为空时使用Router的默认限制
+
+		module        *TModule      // 注册该路由的模块 用于错误处理
+		origin        *TRoute       // 模块返回给调用者的路由 Name和路由设置以它为准 所有副本共享
+		timeout       time.Duration // 执行时限 为0时不限制
+		timeoutStatus int           // 超时时返回的状态码
+
+		MainCtrl    TMethodType   // 主控制器 每个Route都会有一个主要的Ctrl,其他为Hook的Ctrl
+		BeforeCtrls []TMethodType // 前置Hook控制器 在主控制器前执行
+		AfterCtrls  []TMethodType // 后置Hook控制器 在主控制器后执行
+		Ctrls       []TMethodType // 最终控制器 合并前置Hook+主控制器+后置Hook
 		//HookCtrl map[string][]TMethodType // 次控制器 map[*][]TMethodType 匹配所有  Hook的Ctrl会在主的Ctrl执行完后执行
 		//Ctrls    map[string][]TMethodType // 最终控制器 合并主控制器+次控制器
 	}
@@ -84,9 +105,22 @@ type (
 
 		show_route bool
 
-		tree       *TTree
+		tree       atomic.Value        // *TTree 只读 变更时整体替换
+		modules    []IModule           // 已注册的模块 按注册顺序
 		middleware *TMiddlewareManager // 中间件
 
+		StaticResolver *TStaticResolver // 程序文件夹下的静态文件解析器
+		staticCache    []tCacheRule     // Cache-Control 规则 按前缀长度倒序
+
+		UploadLimit   TUploadLimit   // 默认上传限制
+		UploadStorage IUploadStorage // 默认上传存储 程序 uploads 文件夹 不作为静态文件公开
+
+		Sessions    *TSessionManager // 会话管理器 默认内存存储
+		FlashCookie string           // 非空时闪现消息保存在该名称的签名Cookie中 否则保存在会话中
+
+		ErrorHandler func(hd *THandler, err error) // 处理控制器返回的error 为空时响应500
+
+		templateFS        sync.Map // 已加入Template的模块模板 前缀:fs.FS
 		lock              sync.RWMutex
 		handlerPool       sync.Pool
 		proxy_handlerPool sync.Pool
@@ -100,8 +134,12 @@ func NewRouter() *TRouter {
 	lRouter := &TRouter{
 		TempleteVar: map[string]interface{}{},
 		GVar:        map[string]interface{}{},
-		tree:        NewRouteTree(),
 	}
+	lRouter.tree.Store(NewRouteTree())
+	lRouter.StaticResolver = NewStaticResolver(AppPath)
+	lRouter.UploadLimit = TUploadLimit{MaxSize: DEFAULT_MAX_UPLOAD_SIZE}
+	lRouter.UploadStorage = NewDiskStorage(filepath.Join(AppPath, UPLOAD_DIR))
+	lRouter.Sessions = NewSessionManager(NewMemorySessionStore())
 
 	//
 	lRouter.middleware = NewMiddlewareManager()
@@ -137,36 +175,83 @@ func NewRouter() *TRouter {
 	return lRouter
 }
 
-// TODO 管理Ctrl 顺序 before center after
-// 根据不同Action 名称合并Ctrls
-func (self *TRoute) CombineController(aFrom *TRoute) {
-	switch aFrom.Type {
-	/*	case CommomRoute:
-		// 普通Tree合并
-		{
-			self.MainCtrl = append(self.MainCtrl, aFrom.MainCtrl...)
-		}*/
-	case HookBeforeRoute:
-		/*
-			// 静态：Url xxx\xxx\action 将直接插入
-			// 动态：根据Action 插入到Map 叠加
-			if aFrom.isDynRoute {
-				self.HookCtrl[aFrom.Action] = append(self.HookCtrl[aFrom.Action], aFrom.MainCtrl)
-			} else {
-				self.MainCtrl = append(self.MainCtrl, aFrom.MainCtrl...)
+// 复制Route 合并Controller时不影响原模块的Route
+func (self *TRoute) clone() *TRoute {
+	lRoute := *self
+	lRoute.BeforeCtrls = append([]TMethodType(nil), self.BeforeCtrls...)
+	lRoute.AfterCtrls = append([]TMethodType(nil), self.AfterCtrls...)
+	lRoute.Ctrls = append([]TMethodType(nil), self.Ctrls...)
+	return &lRoute
+}
+
+// 保存Name和路由设置的路由
+// 树中存储的可能是合并后的副本 调用者在返回的路由上的设置须经此读取
+func (self *TRoute) settings() *TRoute {
+	if self.origin != nil {
+		return self.origin
+	}
+	return self
+}
+
+// 是否有主控制器 只有Hook的Route不能被访问
+func (self *TRoute) hasMain() bool {
+	return self.MainCtrl.Func.IsValid() || self.isReverseProxy
+}
+
+// 中间件是否为路由中某个动作结构体的成员 是则由动作结构体执行
+func (self *TRoute) bindsMiddleware(aKey string) bool {
+	for i := range self.Ctrls {
+		lFuncType := self.Ctrls[i].FuncType
+		if lFuncType == nil || lFuncType.NumIn() == 0 || lFuncType.In(0).Kind() != reflect.Struct {
+			continue
+		}
+
+		lActionType := lFuncType.In(0)
+		for j := 0; j < lActionType.NumField(); j++ {
+			lType := lActionType.Field(j).Type
+			if lType.Kind() == reflect.Ptr {
+				lType = lType.Elem()
 			}
-		*/
+			if lType.String() == aKey {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 合并Ctrls
+// Hook控制器叠加到前置/后置控制器,主路由替换主控制器但保留已有的Hook
+func (self *TRoute) CombineController(aFrom *TRoute) {
+	lBefore := append(append([]TMethodType(nil), self.BeforeCtrls...), aFrom.BeforeCtrls...)
+	lAfter := append(append([]TMethodType(nil), self.AfterCtrls...), aFrom.AfterCtrls...)
 
-		self.Ctrls = []TMethodType{self.MainCtrl}
-		self.Ctrls = append(self.Ctrls, self.Ctrls...)
-	case HookAfterRoute:
-		self.Ctrls = append(self.Ctrls, aFrom.MainCtrl)
-	default:
-		// 替换路由会直接替换 主控制器 但不会影响其他Hook 进来的控制器
-		self.MainCtrl = aFrom.MainCtrl
-		self.Ctrls = []TMethodType{self.MainCtrl}
-		//logger.Dbg("CombineController", self.MainCtrl, aFrom.MainCtrl)
+	// 替换路由会直接替换 主控制器 但不会影响其他Hook 进来的控制器
+	if aFrom.hasMain() {
+		*self = *aFrom
 	}
+
+	self.BeforeCtrls = lBefore
+	self.AfterCtrls = lAfter
+	self.combineCtrls()
+}
+
+// 按 前置Hook>主控制器>后置Hook 的顺序生成最终控制器
+// 同一阶段内按Priority排序 相同Priority保持注册顺序
+func (self *TRoute) combineCtrls() {
+	sort.SliceStable(self.BeforeCtrls, func(i, j int) bool {
+		return self.BeforeCtrls[i].Priority < self.BeforeCtrls[j].Priority
+	})
+	sort.SliceStable(self.AfterCtrls, func(i, j int) bool {
+		return self.AfterCtrls[i].Priority < self.AfterCtrls[j].Priority
+	})
+
+	self.Ctrls = make([]TMethodType, 0, len(self.BeforeCtrls)+len(self.AfterCtrls)+1)
+	self.Ctrls = append(self.Ctrls, self.BeforeCtrls...)
+	if self.MainCtrl.Func.IsValid() {
+		self.Ctrls = append(self.Ctrls, self.MainCtrl)
+	}
+	self.Ctrls = append(self.Ctrls, self.AfterCtrls...)
 }
 
 /*
@@ -196,28 +281,26 @@ func (self *TRoute) mapMiddleware(aHandlerType reflect.Type) int64 {
  初始化所有加载工作
 */
 func (self *TRouter) Init() {
-	/*
-		// 创建并初始化[国际化]
-		if self.Server.Config.UseI18N {
-			logger.Info("Use I18N")
-			self.I18n = NewI18n("XWeb") // I18N Name
-			lPath := filepath.Join(self.Server.Config.RootPath, self.Server.Config.LocaleDir)
-			if err := self.I18n.Init(lPath, self.Server.Config.LangCode); err != nil {
-				logger.Err(err.Error())
-			}
-
-			lTemplateFuncs := map[string]interface{}{
-				"trans": func(aText string) _template.HTML {
-					return _template.HTML(self.I18n.Translate(aText))
-				},
-			}
-
-			self.Template.AddFuncs(lTemplateFuncs)
+	// 创建并初始化[国际化]
+	if self.Server != nil && self.Server.Config.UseI18N && self.I18n == nil {
+		logger.Info("Use I18N")
+		self.I18n = NewI18n("XWeb") // I18N Name
+		lPath := self.Server.Config.LocaleDir
+		if !filepath.IsAbs(lPath) {
+			lPath = filepath.Join(AppPath, lPath)
 		}
-	*/
+		if err := self.I18n.Init(lPath, self.Server.Config.LangCode); err != nil {
+			logger.Err("init i18n faild: %s", err.Error())
+		}
+	}
+
 	//self.RegisterModules(admin.Admin)
-	if self.show_route || self.Server.Config.PrintRouterTree {
-		self.tree.PrintTrees()
+	if self.Template != nil {
+		self.Template.AddFuncs(self.templateFuncs())
+	}
+
+	if self.show_route || (self.Server != nil && self.Server.Config.PrintRouterTree) {
+		self.getTree().PrintTrees()
 	}
 }
 
@@ -232,10 +315,21 @@ func (self *TRouter) RegisterModule(aMd IModule, build_path ...bool) {
 	if a, ok := aMd.(IModuleRegister); ok {
 		a.Register()
 	}
+	self.checkInject(aMd)
 
 	lModuleFilePath := utils.Trim(aMd.GetFilePath())
 	self.lock.Lock() //<-锁
-	self.tree.Conbine(aMd.GetRoutes())
+	lIsNew := true
+	for _, m := range self.modules {
+		if m == aMd { // 重复注册时更新该模块的路由
+			lIsNew = false
+			break
+		}
+	}
+	if lIsNew {
+		self.modules = append(self.modules, aMd)
+	}
+	self.rebuild()
 	self.lock.Unlock() //<-
 
 	//#创建文件夹
@@ -252,6 +346,63 @@ func (self *TRouter) RegisterModule(aMd IModule, build_path ...bool) {
 
 }
 
+// 从路由器注销功能模块
+// 该模块的路由将被移除,正在处理的请求不受影响
+func (self *TRouter) UnregisterModule(aMd IModule) bool {
+	self.lock.Lock()
+	lFound := false
+	for i, m := range self.modules {
+		if m == aMd {
+			self.modules = append(self.modules[:i], self.modules[i+1:]...)
+			lFound = true
+			break
+		}
+	}
+	if lFound {
+		self.rebuild()
+	}
+	self.lock.Unlock()
+
+	// 执行卸载接口
+	if a, ok := aMd.(IModuleInstaller); lFound && ok {
+		a.Uninstall()
+	}
+	return lFound
+}
+
+// 删除指定名称的路由
+// 路由将从所属模块中移除
+func (self *TRouter) UnregisterRoute(name string) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	lFound := false
+	for _, m := range self.modules {
+		if m.GetRoutes().DelRoute(name) {
+			lFound = true
+		}
+	}
+	if lFound {
+		self.rebuild()
+	}
+	return lFound
+}
+
+// 由已注册模块重建路由树并整体替换
+// 调用者必须持有锁
+func (self *TRouter) rebuild() {
+	lTree := NewRouteTree()
+	for _, m := range self.modules {
+		lTree.Conbine(m.GetRoutes())
+	}
+	self.tree.Store(lTree)
+}
+
+// 获得当前路由树
+func (self *TRouter) getTree() *TTree {
+	return self.tree.Load().(*TTree)
+}
+
 // 注册中间件
 func (self *TRouter) RegisterMiddleware(aMd ...IMiddleware) {
 	for _, m := range aMd {
@@ -265,6 +416,25 @@ func (self *TRouter) RegisterMiddleware(aMd ...IMiddleware) {
 
 }
 
+// 模板函数 硬盘模板和模块模板文件系统共用
+func (self *TRouter) templateFuncs() map[string]interface{} {
+	return map[string]interface{}{
+		"asset":      self.AssetUrl,
+		"csrf_field": CsrfField,
+		"csrf_token": CsrfToken,
+		"flashes":    templateFlashes,
+		"trans":      self.trans,
+	}
+}
+
+// 模板翻译函数 没有启用国际化时返回原文
+func (self *TRouter) trans(aText string) string {
+	if self.I18n == nil {
+		return aText
+	}
+	return self.I18n.Translate(aText)
+}
+
 func (self *TRouter) AddVar(name string, value interface{}) {
 	self.GVar[name] = value
 }
@@ -279,12 +449,16 @@ func (self *TRouter) DelVar(name string) {
 
 //安全调用Handle 函数(resp []reflect.Value, e interface{})
 // TODO 有待优化
-func (self *TRouter) safelyCall(function reflect.Value, args []reflect.Value, hd *THandler, aActionValue reflect.Value) {
+func (self *TRouter) safelyCall(function reflect.Value, args []reflect.Value, hd *THandler, aActionValue reflect.Value) []reflect.Value {
 	// 错误处理
 	defer func() {
 		if err := recover(); err != nil {
-			if self.Server.Config.RecoverPanic { //是否绕过错误处理直接关闭程序
+			// 未绑定服务器时默认恢复
+			if self.Server == nil || self.Server.Config.RecoverPanic { //是否绕过错误处理直接关闭程序
 				self.routePanic(hd, aActionValue)
+				if !hd.Response.Written() {
+					hd.HandleError(&THttpError{Status: http.StatusInternalServerError, Err: fmt.Errorf("panic: %v", err)})
+				}
 
 				for i := 1; ; i++ {
 					_, file, line, ok := runtime.Caller(i)
@@ -300,93 +474,109 @@ func (self *TRouter) safelyCall(function reflect.Value, args []reflect.Value, hd
 	}()
 
 	//调用控制器函数 >>>输出HTML数据
-	function.Call(args)
+	return function.Call(args)
 }
 
 // TODO 有待优化
 // 执行静态文件路由
 func (self *TRouter) routeStatic(req *http.Request, w *TResponseWriter) {
-	var lFilePath string
-	lPath, lFileName := filepath.Split(req.URL.Path) //products/js/base.js
-	//urlPath := strings.Split(strings.Trim(req.URL.Path, `/`), `/`) // Split不能去除/products
+	if req.Method != "GET" && req.Method != "HEAD" {
+		http.NotFound(w, req)
+		return
+	}
 
-	//根目录静态文件映射过滤
-	if lPath == "/" {
-		switch filepath.Ext(lFileName) {
-		case ".txt", ".html", ".htm": // 目前只开放这种格式
-			lFilePath = filepath.Join(lFileName)
+	lFilePath, ok := self.StaticResolver.Resolve(req.URL.Path)
+	lFingerprint := ""
+	if !ok {
+		// 带指纹的地址按原文件解析
+		if lUrlPath, lHash, has := splitFingerprint(req.URL.Path); has {
+			lFilePath, ok = self.StaticResolver.Resolve(lUrlPath)
+			lFingerprint = lHash
 		}
+	}
 
-	} else {
-		//urlPath := strings.Trim(req.URL.Path, `/`)
-
-		//如果第一个是静态文件夹名则选用主静态文件夹,反之使用模块
-		// /static/js/base.js
-		// /ModuleName/static/js/base.js
-		lDirs := strings.Split(lPath, "/")
-		if strings.EqualFold(lDirs[1], STATIC_DIR) {
-			//if strings.HasPrefix(lPath, "/"+STATIC_DIR) { // 如果请求是 /Static/js/base.js
-			/* static_file = filepath.Join(
-			self.Server.Config.RootPath,                           // c:\project\
-			STATIC_DIR,                          // c:\project\static\
-			strings.Join(urlPath[1:], string(filepath.Separator)), // c:\project\static\js\base.js
-			fileName)
-			*/
-
-			lFilePath = filepath.Join(req.URL.Path)
-
-		} else { // 如果请求是 products/Static/js/base.js
-			/* static_file = filepath.Join(
-			self.Server.Config.RootPath,                           // c:\project\
-			MODULE_DIR,                         // c:\project\Modules
-			urlPath[0],                                            // c:\project\Modules\products\
-			STATIC_DIR,                          // c:\project\Modules\products\static\
-			strings.Join(urlPath[1:], string(filepath.Separator)), // c:\project\Modules\products\static\js\base.js
-			fileName)
-			*/
-
-			//Debug("lDirsD", lDirs, STATIC_DIR, string(os.PathSeparator))
-			// 再次检查 Module Name 后必须是 /static 目录
-			if strings.EqualFold(lDirs[2], STATIC_DIR) {
-				lFilePath = filepath.Join(
-					MODULE_DIR, // c:\project\Modules
-					req.URL.Path)
-			} else {
-				http.NotFound(w, req)
-				return
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
 
-			}
+	err := self.StaticResolver.fs.serveFile(self, w, req, lFilePath, lFingerprint)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			http.NotFound(w, req)
+			return
 		}
+
+		logger.Err("serve static file faild: %s", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
+}
 
-	// 当模块路径无该文件时，改为程序static文件夹
-	if !utils.FileExists(lFilePath) {
-		lIndex := strings.Index(lFilePath, STATIC_DIR)
-		if lIndex != -1 {
-			lFilePath = lFilePath[lIndex-1:]
+// 设置路径前缀对应的Cache-Control 最长前缀优先
+// 例: router.SetCacheControl("/static/lib", "public, max-age=604800")
+func (self *TRouter) SetCacheControl(aPrefix, aValue string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 
+	for i, lRule := range self.staticCache {
+		if lRule.prefix == aPrefix {
+			self.staticCache[i].value = aValue
+			return
 		}
 	}
 
-	//Info("static_file", static_file)
-	if req.Method == "GET" || req.Method == "HEAD" {
-		lFilePath = filepath.Join(
-			//self.Server.Config.RootPath,
-			AppPath,
-			lFilePath)
-		// 当程序文件夹无该文件时
-		if !utils.FileExists(lFilePath) {
-			//self.Logger.DbgLn("Not Found", lFilePath)
-			http.NotFound(w, req)
-			return
+	self.staticCache = append(self.staticCache, tCacheRule{prefix: aPrefix, value: aValue})
+	sort.SliceStable(self.staticCache, func(i, j int) bool {
+		return len(self.staticCache[i].prefix) > len(self.staticCache[j].prefix)
+	})
+}
+
+// 获得地址对应的Cache-Control
+func (self *TRouter) cacheControl(aUrlPath string) string {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	for _, lRule := range self.staticCache {
+		if strings.HasPrefix(aUrlPath, lRule.prefix) {
+			return lRule.value
 		}
-		//Noted: ServeFile() can not accept "/AA.exe" string, only accepy "AA.exe" string.
-		http.ServeFile(w, req, lFilePath) // func ServeFile(w ResponseWriter, r *Request, name string)
-		//self.Server.Logger.Println("RouteFile:" + static_file)
-		return
 	}
-	//Debug("RouteStatic", path, fileName)
-	return
+
+	return ""
+}
+
+// 返回带内容指纹的静态文件地址 文件不存在时原样返回
+// 模板中使用: <script src="{{asset "/static/js/app.js"}}"></script>
+func (self *TRouter) AssetUrl(aUrlPath string) string {
+	lStatic, lName := self.lookupStatic(aUrlPath)
+	if lStatic == nil {
+		return aUrlPath
+	}
+
+	lHash, err := lStatic.Hash(lName)
+	if err != nil {
+		return aUrlPath
+	}
+
+	return Fingerprint(aUrlPath, lHash)
+}
+
+// 查找地址对应的静态文件系统和文件名
+func (self *TRouter) lookupStatic(aUrlPath string) (*TStaticFS, string) {
+	lParams := getParams()
+	defer putParams(lParams)
+
+	for _, lMethod := range []string{"GET", "HEAD"} {
+		if lRoute := self.getTree().Lookup(lMethod, aUrlPath, lParams); lRoute != nil && lRoute.settings().static != nil {
+			return lRoute.settings().static, lParams.Get("filepath")
+		}
+	}
+
+	if lFilePath, ok := self.StaticResolver.Resolve(aUrlPath); ok {
+		return self.StaticResolver.fs, lFilePath
+	}
+
+	return nil, ""
 }
 
 // ServeHTTP
@@ -434,11 +624,20 @@ func cloneInterfacePtrFeild(s interface{}) interface{} {
 	return lrVal.Interface()
 }
 
+// 传给中间件的控制器 没有动作结构体时为nil
+func actionInterface(aActionValue reflect.Value) interface{} {
+	if !aActionValue.IsValid() {
+		return nil
+	}
+	return aActionValue.Interface()
+}
+
 // TODO:过滤 _ 的中间件
-func (self *TRouter) routeBefore(hd *THandler, aActionValue reflect.Value) {
+// aGlobal 时执行全局中间件 每个请求在控制器前执行一次 controller 为主控制器的动作结构体 没有时为nil
+// 否则只执行动作结构体成员中的中间件
+func (self *TRouter) routeBefore(hd *THandler, aActionValue reflect.Value, aGlobal bool) {
 	// Action结构外的其他中间件
 	var (
-		IsFound         bool
 		lField, lMethod reflect.Value
 		lType           reflect.Type
 		lNew            interface{}
@@ -449,7 +648,16 @@ func (self *TRouter) routeBefore(hd *THandler, aActionValue reflect.Value) {
 		if hd.Response.Written() {
 			break
 		}
-		IsFound = false
+
+		ml = self.middleware.Get(key).(IMiddleware)
+		// 全局中间件 已作为动作结构体成员的由动作结构体执行
+		if aGlobal {
+			if !hd.Route.bindsMiddleware(key) {
+				ml.Request(actionInterface(aActionValue), hd)
+			}
+			continue
+		}
+
 		for i := 0; i < aActionValue.NumField(); i++ { // Action结构下的中间件
 			lField = aActionValue.Field(i) // 获得成员
 			lType = lField.Type()
@@ -459,7 +667,6 @@ func (self *TRouter) routeBefore(hd *THandler, aActionValue reflect.Value) {
 			}
 
 			//self.Server.Logger.DbgLn("Name %s %s", key, lType.Name(), lField.Interface(), lField.Kind(), lField.String())
-			ml = self.middleware.Get(key).(IMiddleware)
 			if lType.String() == key {
 				//Warn("lField.IsValid(),lField.IsNil()", lField.IsValid(), lField.IsNil())
 				//if lField.IsValid() { // 存在该Filed
@@ -490,26 +697,29 @@ func (self *TRouter) routeBefore(hd *THandler, aActionValue reflect.Value) {
 				//}
 
 				// STEP:结束循环
-				IsFound = true
 				break
 			}
 		}
-
-		// 更新非控制器中的中间件
-		if !IsFound {
-			//Warn(" routeBefore not IsFound", key, aActionValue.Interface(), hd)
-			ml.Request(aActionValue.Interface(), hd)
-		}
 	}
 }
 
-func (self *TRouter) routeAfter(hd *THandler, aActionValue reflect.Value) {
+// aActionValue 无效时执行全局中间件 每个请求在控制器后执行一次
+// 有效时只执行动作结构体成员中的中间件
+func (self *TRouter) routeAfter(hd *THandler, aActionValue reflect.Value, aGlobal bool) {
 	var (
 		lField, lMethod reflect.Value
 		lType           reflect.Type
 		lNew            interface{}
 	)
 	for key, ml := range self.middleware.middlewares {
+		// 全局中间件 已作为动作结构体成员的由动作结构体执行
+		if aGlobal {
+			if !hd.Route.bindsMiddleware(key) {
+				ml.Response(actionInterface(aActionValue), hd)
+			}
+			continue
+		}
+
 		for i := 0; i < aActionValue.NumField(); i++ { // Action结构下的中间件
 			lField = aActionValue.Field(i) // 获得成员
 			lType = lField.Type()
@@ -547,9 +757,6 @@ func (self *TRouter) routeAfter(hd *THandler, aActionValue reflect.Value) {
 
 				// STEP:结束循环
 				break
-			} else {
-				//Warn(" routeBefore", key, aActionValue.Interface(), hd)
-				ml.Response(aActionValue.Interface(), hd)
 			}
 		}
 	}
@@ -621,6 +828,11 @@ func (self *TRouter) routePanic(hd *THandler, aActionValue reflect.Value) {
 				ml.Panic(aActionValue.Interface(), hd)
 			}
 		}
+	} else {
+		// 非结构体控制器直接执行全局中间件
+		for _, ml := range self.middleware.middlewares {
+			ml.Panic(nil, hd)
+		}
 	}
 }
 
@@ -635,19 +847,22 @@ func (self *TRouter) routeHandler(req *http.Request, w *TResponseWriter) {
 	//ar lRoute *TRoute
 	//ar lParam Params
 	// # match route from tree
-	lRoute, lParam := self.tree.Match(req.Method, lPath)
-	if self.show_route {
+	lParam := getParams()
+	defer putParams(lParam)
+	lRoute := self.getTree().Lookup(req.Method, lPath, lParam)
+	if self.show_route && lRoute != nil {
 		logger.Info("[Path]%v [Route]%v", lPath, lRoute.FilePath)
 	}
 
 	//opy(lParam, Param)
-	if lRoute == nil {
+	if lRoute == nil || !lRoute.hasMain() { // 只有Hook的路由视为不存在
+
 		self.routeStatic(req, w) // # serve as a static file link
 		return
 	}
 
 	if lRoute.isReverseProxy {
-		self.routeProxy(lRoute, lParam, req, w)
+		self.routeProxy(lRoute, *lParam, req, w)
 
 		return
 	}
@@ -662,89 +877,109 @@ func (self *TRouter) routeHandler(req *http.Request, w *TResponseWriter) {
 
 	//lHandler := self.handlerPool.Get().(*THandler) // Pool 提供Handler
 	lHandler.connect(w, req, self, lRoute)
-	for _, param := range lParam {
+	for _, param := range *lParam {
 		lHandler.setPathParams(param.Name, param.Value)
 		//self.Logger.DbgLn("lParam", param.Name, param.Value)
 	}
 
+	if lRoute.settings().timeout > 0 {
+		self.routeTimeout(lHandler, w)
+		return
+	}
+
+	self.routeCtrls(lHandler, w.val)
+	self.handlerPool.Put(lHandler) // Pool 回收Handler
+	return
+}
+
+// 依次执行Route的控制器并提交结果
+// aResp 为传递给 http.ResponseWriter 参数的值
+func (self *TRouter) routeCtrls(lHandler *THandler, aResp reflect.Value) {
+	lRoute := lHandler.Route
 	var (
 		args          []reflect.Value //handler参数
 		lActionVal    reflect.Value
-		lActionTyp    reflect.Type
-		lParm         reflect.Type
 		CtrlValidable bool
+		lInjectErr    error
 	)
+	lInjector := self.injector()
+
+	// 主控制器为动作结构体的方法时 先取得动作结构体 全局中间件与主控制器使用同一个值
+	lMainIndex, lMainAction := -1, reflect.Value{}
+	if lRoute.MainCtrl.Func.IsValid() {
+		lMainIndex = len(lRoute.BeforeCtrls)
+		if lPlan := self.ctrlPlan(&lRoute.Ctrls[lMainIndex], lInjector); lPlan.actionType != nil {
+			lMainAction = self.newAction(lPlan.actionType)
+			defer self.actionPool.Put(lPlan.actionType, lMainAction)
+		}
+	}
 
-	// TODO:将所有需要执行的Handler 存疑列表或者树-Node保存函数和参数
-	//logger.Dbg("lParm %s %d:%d %p %p", lHandler.TemplateSrc, lRoute.Action, lRoute.MainCtrl, len(lRoute.Ctrls), lRoute.Ctrls)
-	for index, ctrl := range lRoute.Ctrls {
-		lHandler.CtrlIndex = index //index
-		// STEP#: 获取<Ctrl.Func()>方法的参数
-		for i := 0; i < ctrl.FuncType.NumIn(); i++ {
-			lParm = ctrl.FuncType.In(i) // 获得参数
-
-			//self.Logger.DbgLn("lParm%d:", i, lParm, lParm.Name())
-			switch lParm { //arg0.Elem() { //获得Handler的第一个参数类型.
-			case reflect.TypeOf(lHandler): // if is a pointer of THandler
-				{
-					//args = append(args, reflect.ValueOf(lHandler)) // 这里将传递本函数先前创建的handle 给请求函数
-					args = append(args, lHandler.val) // 这里将传递本函数先前创建的handle 给请求函数
-				}
-			default:
-				{
-					//Trace("lParm->default")
-					if i == 0 && lParm.Kind() == reflect.Struct { // 第一个 //第一个是方法的结构自己本身 例：(self TMiddleware) ProcessRequest（）的 self
-						lActionTyp = lParm
-						lActionVal = self.actionPool.Get(lParm)
-						if !lActionVal.IsValid() {
-							lActionVal = reflect.New(lParm).Elem() //由类生成实体值,必须指针转换而成才是Addressable  错误：lVal := reflect.Zero(aHandleType)
-						}
-						args = append(args, lActionVal) //插入该类型空值
-						break
-					}
-
-					// STEP:如果是参数是 http.ResponseWriter 值
-					if strings.EqualFold(lParm.String(), "http.ResponseWriter") { // Response 类
-						//args = append(args, reflect.ValueOf(w.ResponseWriter))
-						args = append(args, w.val)
-						break
-					}
+	// 全局中间件的Request 每个请求只执行一次
+	self.routeBefore(lHandler, lMainAction, true)
+	lAborted := lHandler.Response.Written()
 
-					// STEP:如果是参数是 http.Request 值
-					if lParm == reflect.TypeOf(req) { // request 指针
-						args = append(args, reflect.ValueOf(req)) //TODO (同上简化reflect.ValueOf）
-						break
-					}
+	for index, ctrl := range lRoute.Ctrls {
+		if lAborted {
+			break
+		}
 
-					// STEP#:
-					args = append(args, reflect.Zero(lParm)) //插入该类型空值
+		lHandler.CtrlIndex = index //index
+		// STEP#: 按调用计划获取<Ctrl.Func()>方法的参数
+		lPlan := self.ctrlPlan(&ctrl, lInjector)
+		if cap(lHandler.args) < len(lPlan.args) {
+			lHandler.args = make([]reflect.Value, len(lPlan.args))
+		}
+		args = lHandler.args[:len(lPlan.args)]
+		lIsMain := index == lMainIndex
+		lAction := reflect.Value{}
+		if lIsMain {
+			lAction = lMainAction
+		}
+		lActionVal, lInjectErr = self.invokeArgs(lPlan, lHandler, aResp, args, lAction)
+		CtrlValidable = lActionVal.IsValid()
 
-				}
+		if lInjectErr != nil {
+			// 注入失败 不再执行本控制器及之后的控制器 直接提交错误响应
+			if CtrlValidable && !lIsMain {
+				self.actionPool.Put(lPlan.actionType, lActionVal)
 			}
+			lHandler.HandleError(lInjectErr)
+			lAborted = true
+			break
 		}
 
-		CtrlValidable = lActionVal.IsValid()
 		if CtrlValidable {
 			//self.Logger.Info("routeBefore")
-			self.routeBefore(lHandler, lActionVal)
+			self.routeBefore(lHandler, lActionVal, false)
 		}
 		//logger.Infof("safelyCall %v ,%v", lHandler.Response.Written(), args)
 		if !lHandler.Response.Written() {
 			//self.Logger.Info("safelyCall")
 			// -- execute Handler or Panic Event
-			self.safelyCall(ctrl.Func, args, lHandler, lActionVal) //传递参数给函数.<<<
+			lOut := self.safelyCall(ctrl.Func, args, lHandler, lActionVal) //传递参数给函数.<<<
+			if len(lOut) > 0 {
+				lHandler.reply(&ctrl, lOut)
+			}
 		}
 
 		if !lHandler.Response.Written() && CtrlValidable {
 			// # after route
-			self.routeAfter(lHandler, lActionVal)
+			self.routeAfter(lHandler, lActionVal, false)
 		}
 
-		if CtrlValidable {
-			self.actionPool.Put(lActionTyp, lActionVal)
+		if CtrlValidable && !lIsMain { // 主控制器的动作结构体在全局中间件之后放回
+			self.actionPool.Put(lPlan.actionType, lActionVal)
 		}
 	}
 
+	// 全局中间件的Response 在所有控制器之后执行一次
+	if !lAborted && !lHandler.Response.Written() {
+		self.routeAfter(lHandler, lMainAction, true)
+	}
+	for i := range lHandler.args { // 不保留本次请求的值
+		lHandler.args[i] = reflect.Value{}
+	}
+
 	if lHandler.finalCall.IsValid() {
 		if f, ok := lHandler.finalCall.Interface().(func(*THandler)); ok {
 			//f([]reflect.Value{reflect.ValueOf(lHandler)})
@@ -766,13 +1001,18 @@ func (self *TRouter) routeHandler(req *http.Request, w *TResponseWriter) {
 		logger.Dbg(STATIC_DIR, path.Join(utils.FilePathToPath(lRoute.FilePath), STATIC_DIR))
 		//	self.AddVar(STATIC_DIR, path.Join(utils.FilePathToPath(lRoute.FilePath), STATIC_DIR)) //添加[static]静态文件路径
 		lHandler.RenderArgs[STATIC_DIR] = path.Join(utils.FilePathToPath(lRoute.FilePath), STATIC_DIR)
+
+		// 添加取得CSRF令牌的函数 模板使用时才生成 布局/片段/错误页面均可使用
+		if self.csrf() != nil {
+			lHandler.RenderArgs[CSRF_FIELD] = lHandler.CsrfToken
+		}
+
+		// 闪现消息 模板调用 flashes 时才读取并清除
+		lHandler.RenderArgs[FLASH_ARG] = lHandler.Flashes
 	}
 
 	// 结束Route并返回内容
 	lHandler.Apply()
-
-	self.handlerPool.Put(lHandler) // Pool 回收Handler
-	return
 }
 
 // TODO 将代理移动至Handler里实现
@@ -0,0 +1,334 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRouterUnregister(t *testing.T) {
+	router := NewRouter()
+	m1 := NewModule(nil, "m1")
+	m1.Get("/order/list", func(hd *THandler) {}).Name = "order.list"
+	m1.Get("/order/(int:id)", func(hd *THandler) {})
+	m2 := NewModule(nil, "m2")
+	m2.Get("/stock/list", func(hd *THandler) {})
+
+	router.RegisterModule(m1)
+	router.RegisterModule(m2)
+	for _, url := range []string{"/order/list", "/order/1", "/stock/list"} {
+		if r, _ := router.getTree().Match("GET", url); r == nil {
+			t.Fatalf("%s: expect route after register", url)
+		}
+	}
+
+	lOld := router.getTree()
+	if !router.UnregisterModule(m2) {
+		t.Fatalf("expect m2 to be unregistered")
+	}
+	if r, _ := router.getTree().Match("GET", "/stock/list"); r != nil {
+		t.Errorf("expect /stock/list removed")
+	}
+	if r, _ := lOld.Match("GET", "/stock/list"); r == nil {
+		t.Errorf("expect the old tree unchanged for in-flight requests")
+	}
+	if router.UnregisterModule(m2) {
+		t.Errorf("expect unregistering twice to fail")
+	}
+
+	if !router.UnregisterRoute("order.list") {
+		t.Fatalf("expect order.list to be removed")
+	}
+	if r, _ := router.getTree().Match("GET", "/order/list"); r != nil {
+		t.Errorf("expect /order/list removed")
+	}
+	if r, _ := router.getTree().Match("GET", "/order/1"); r == nil {
+		t.Errorf("expect /order/(int:id) kept")
+	}
+	if router.UnregisterRoute("order.list") {
+		t.Errorf("expect removing a missing route to fail")
+	}
+}
+
+// 运行时注册注销模块 与请求匹配并发执行
+func TestRouterSwapConcurrent(t *testing.T) {
+	router := NewRouter()
+	m1 := NewModule(nil, "m1")
+	m1.Get("/a", func(hd *THandler) {})
+	m2 := NewModule(nil, "m2")
+	m2.Get("/b/(:id)", func(hd *THandler) {})
+	router.RegisterModule(m1)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			params := make(Params, 0, 4)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if router.getTree().Lookup("GET", "/a", &params) == nil {
+					t.Errorf("/a lost during swap")
+					return
+				}
+				router.getTree().Lookup("GET", "/b/1", &params)
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		router.RegisterModule(m2)
+		router.UnregisterModule(m2)
+	}
+	close(done)
+	wg.Wait()
+}
+
+func serveTest(router *TRouter, method, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+	return w
+}
+
+func traceCtrl(trace *[]string, name string) func(*THandler) {
+	return func(hd *THandler) {
+		*trace = append(*trace, name)
+	}
+}
+
+func TestRouteHookOrder(t *testing.T) {
+	var trace []string
+	cases := []struct {
+		name   string
+		setup  func() []*TModule
+		method string
+		expect string
+	}{
+		{"main only", func() []*TModule {
+			m := NewModule(nil, "m")
+			m.Get("/x", traceCtrl(&trace, "main"))
+			return []*TModule{m}
+		}, "GET", "main"},
+		{"before registered after main", func() []*TModule {
+			m := NewModule(nil, "m")
+			m.Get("/x", traceCtrl(&trace, "main"))
+			m.HookBefore(nil, "/x", traceCtrl(&trace, "before"))
+			return []*TModule{m}
+		}, "GET", "before,main"},
+		{"hooks registered before main", func() []*TModule {
+			m := NewModule(nil, "m")
+			m.HookAfter(nil, "/x", traceCtrl(&trace, "after"))
+			m.HookBefore(nil, "/x", traceCtrl(&trace, "before"))
+			m.Get("/x", traceCtrl(&trace, "main"))
+			return []*TModule{m}
+		}, "GET", "before,main,after"},
+		{"hook for other method", func() []*TModule {
+			m := NewModule(nil, "m")
+			m.Url("/x", traceCtrl(&trace, "main"))
+			m.HookBefore([]string{"POST"}, "/x", traceCtrl(&trace, "before"))
+			return []*TModule{m}
+		}, "GET", "main"},
+		{"priorities across modules", func() []*TModule {
+			m1 := NewModule(nil, "m1")
+			m1.Get("/x", traceCtrl(&trace, "main"))
+			m2 := NewModule(nil, "m2")
+			m2.HookBefore(nil, "/x", traceCtrl(&trace, "before2"), 10)
+			m2.HookAfter(nil, "/x", traceCtrl(&trace, "after2"))
+			m3 := NewModule(nil, "m3")
+			m3.HookBefore(nil, "/x", traceCtrl(&trace, "before3"), -1)
+			m3.HookAfter(nil, "/x", traceCtrl(&trace, "after3"))
+			m3.HookAfter(nil, "/x", traceCtrl(&trace, "after3first"), -5)
+			return []*TModule{m1, m2, m3}
+		}, "GET", "before3,before2,main,after3first,after2,after3"},
+		{"hooks before main module", func() []*TModule {
+			m1 := NewModule(nil, "m1")
+			m1.HookBefore(nil, "/x", traceCtrl(&trace, "before"))
+			m2 := NewModule(nil, "m2")
+			m2.Get("/x", traceCtrl(&trace, "main"))
+			return []*TModule{m1, m2}
+		}, "GET", "before,main"},
+		{"replace keeps hooks", func() []*TModule {
+			m1 := NewModule(nil, "m1")
+			m1.Get("/x", traceCtrl(&trace, "main1"))
+			m2 := NewModule(nil, "m2")
+			m2.HookBefore(nil, "/x", traceCtrl(&trace, "before"))
+			m2.HookAfter(nil, "/x", traceCtrl(&trace, "after"))
+			m3 := NewModule(nil, "m3")
+			m3.Get("/x", traceCtrl(&trace, "main3"))
+			return []*TModule{m1, m2, m3}
+		}, "GET", "before,main3,after"},
+		{"replace in same module keeps hooks", func() []*TModule {
+			m := NewModule(nil, "m")
+			m.Get("/x", traceCtrl(&trace, "main1"))
+			m.HookAfter(nil, "/x", traceCtrl(&trace, "after"))
+			m.Get("/x", traceCtrl(&trace, "main2"))
+			return []*TModule{m}
+		}, "GET", "main2,after"},
+		{"before hook responds", func() []*TModule {
+			m := NewModule(nil, "m")
+			m.Get("/x", traceCtrl(&trace, "main"))
+			m.HookBefore(nil, "/x", func(hd *THandler) {
+				trace = append(trace, "deny")
+				hd.Abort(403, "deny")
+			})
+			m.HookAfter(nil, "/x", traceCtrl(&trace, "after"))
+			return []*TModule{m}
+		}, "GET", "deny"},
+	}
+
+	for _, c := range cases {
+		router := NewRouter()
+		for _, m := range c.setup() {
+			router.RegisterModule(m)
+		}
+
+		trace = nil
+		serveTest(router, c.method, "/x")
+		if got := strings.Join(trace, ","); got != c.expect {
+			t.Errorf("%s: expect %s but got %s", c.name, c.expect, got)
+		}
+	}
+}
+
+func TestRouteHookOnly(t *testing.T) {
+	m := NewModule(nil, "m")
+	m.HookBefore(nil, "/x", func(hd *THandler) {})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	r, _ := router.getTree().Match("GET", "/x")
+	if r == nil || r.hasMain() {
+		t.Fatalf("expect a route without main controller")
+	}
+	if r.BeforeCtrls[0].Module != "m" {
+		t.Errorf("expect hook module m but got %s", r.BeforeCtrls[0].Module)
+	}
+}
+
+type testHeaderMiddleware struct{}
+
+func (self *testHeaderMiddleware) Request(controller interface{}, hd *THandler) {
+	hd.Header().Set("X-Middleware", "request")
+}
+
+func (self *testHeaderMiddleware) Response(controller interface{}, hd *THandler) {}
+
+func (self *testHeaderMiddleware) Panic(controller interface{}, hd *THandler) {}
+
+type testOrderMiddleware struct {
+	calls *[]string
+}
+
+func (self *testOrderMiddleware) Request(controller interface{}, hd *THandler) {
+	*self.calls = append(*self.calls, "request"+testControllerName(controller))
+}
+
+func (self *testOrderMiddleware) Response(controller interface{}, hd *THandler) {
+	*self.calls = append(*self.calls, "response"+testControllerName(controller))
+}
+
+func testControllerName(controller interface{}) string {
+	if controller == nil {
+		return ""
+	}
+	return ":" + reflect.TypeOf(controller).Name()
+}
+
+type testOrderAction struct{}
+
+func (self testOrderAction) Show(hd *THandler) {}
+
+func (self *testOrderMiddleware) Panic(controller interface{}, hd *THandler) {}
+
+func TestMiddlewareOncePerRequest(t *testing.T) {
+	var calls []string
+	m := NewModule(nil, "m")
+	m.HookBefore(nil, "/x", func(hd *THandler) {
+		calls = append(calls, "before")
+	})
+	m.Get("/x", func(hd *THandler) {
+		calls = append(calls, "main")
+	})
+	m.HookAfter(nil, "/x", func(hd *THandler) {
+		calls = append(calls, "after")
+	})
+	m.HookBefore(nil, "/action", func(hd *THandler) {
+		calls = append(calls, "before")
+	})
+	m.Get("/action", testOrderAction.Show)
+	router := NewRouter()
+	router.RegisterMiddleware(&testOrderMiddleware{calls: &calls})
+	router.RegisterModule(m)
+
+	serveTest(router, "GET", "/x")
+	if strings.Join(calls, ",") != "request,before,main,after,response" {
+		t.Errorf("unexpected middleware order %v", calls)
+	}
+
+	// 主控制器为动作结构体方法时 全局中间件收到该动作结构体
+	calls = nil
+	serveTest(router, "GET", "/action")
+	if strings.Join(calls, ",") != "request:testOrderAction,before,response:testOrderAction" {
+		t.Errorf("unexpected middleware controller %v", calls)
+	}
+}
+
+func TestModuleHandle(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("users:" + r.URL.Path))
+	})
+	mux.HandleFunc("/admin/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("full:" + r.URL.Path))
+	})
+
+	m := NewModule(nil, "m")
+	m.Handle("/admin/", mux, true)
+	m.Handle("/raw", mux)
+	m.Get("/admin/about", func(hd *THandler) {
+		hd.RespondString("about")
+	})
+	router := NewRouter()
+	router.RegisterMiddleware(new(testHeaderMiddleware))
+	router.RegisterModule(m)
+
+	cases := []struct {
+		method string
+		url    string
+		code   int
+		body   string
+	}{
+		{"GET", "/admin/users", 200, "users:/users"},
+		{"POST", "/admin/users", 200, "users:/users"},
+		{"GET", "/admin/about", 200, "about"},
+		{"GET", "/admin/missing", 404, ""},
+		{"GET", "/raw/users", 404, ""},
+	}
+	for _, c := range cases {
+		w := serveTest(router, c.method, c.url)
+		if w.Code != c.code {
+			t.Errorf("%s %s: expect status %d but got %d", c.method, c.url, c.code, w.Code)
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			t.Errorf("%s %s: expect body %q but got %q", c.method, c.url, c.body, w.Body.String())
+		}
+		if w.Header().Get("X-Middleware") != "request" {
+			t.Errorf("%s %s: expect global middleware to run", c.method, c.url)
+		}
+	}
+
+	if r, p := router.getTree().Match("GET", "/admin/a/b"); r == nil || p.Get("path") != "a/b" {
+		t.Errorf("expect mounted route in the tree")
+	}
+	if r, _ := router.getTree().Match("GET", "/admin"); r == nil {
+		t.Errorf("expect the prefix itself to be mounted")
+	}
+}
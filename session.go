@@ -0,0 +1,272 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+/*
+	session 负责会话
+	@Router.Sessions 为会话管理器 默认使用内存存储
+	@会话在第一次读写时才从存储加载 没有使用会话的请求不访问存储
+	@修改过的会话在写出响应头前(包括Redirect)保存 之后的修改只能保存到服务端存储 不再更新Cookie
+
+	lSession := hd.Session()
+	lSession.Set("uid", 42)
+	lUid := lSession.GetInt("uid")
+	lSession.Regenerate() // 登录后更换会话ID 防止会话固定
+
+	@存储 实现 ISessionStore 即可接入Redis等外部存储
+	router.Sessions = NewSessionManager(NewFileSessionStore("data/sessions"))
+*/
+
+const (
+	DEFAULT_SESSION_COOKIE  = "SESSIONID"
+	DEFAULT_SESSION_MAX_AGE = 24 * time.Hour
+)
+
+var ErrSessionDestroyed = errors.New("session destroyed")
+
+type (
+	// 会话存储接口
+	ISessionStore interface {
+		// 加载会话 不存在或已过期时返回nil
+		Load(id string) (map[string]interface{}, error)
+		// 保存会话 返回写入Cookie的值 服务端存储返回id本身
+		Save(id string, values map[string]interface{}, maxAge time.Duration) (string, error)
+		Delete(id string) error
+	}
+
+	// 会话管理器 Cookie选项用于会话Cookie
+	TSessionManager struct {
+		Store      ISessionStore
+		CookieName string
+		Path       string
+		Domain     string
+		MaxAge     time.Duration // 会话自最后修改起的有效期 也是Cookie的Max-Age
+		Secure     bool
+		HttpOnly   bool
+		SameSite   http.SameSite
+	}
+
+	// 一次请求的会话
+	TSession struct {
+		handler   *THandler
+		manager   *TSessionManager
+		id        string
+		values    map[string]interface{}
+		loaded    bool
+		changed   bool
+		destroyed bool
+		oldId     string // Regenerate 前的id 保存时删除
+	}
+)
+
+func NewSessionManager(store ISessionStore) *TSessionManager {
+	if store == nil {
+		logger.Panic("the session store must not be nil!")
+	}
+
+	return &TSessionManager{
+		Store:      store,
+		CookieName: DEFAULT_SESSION_COOKIE,
+		Path:       "/",
+		MaxAge:     DEFAULT_SESSION_MAX_AGE,
+		HttpOnly:   true,
+		SameSite:   http.SameSiteLaxMode,
+	}
+}
+
+// 返回本次请求的会话 不访问存储
+func (self *THandler) Session() *TSession {
+	if self.session == nil {
+		lSession := &TSession{
+			handler: self,
+			manager: self.Router.Sessions,
+			id:      self.COOKIE[self.Router.Sessions.CookieName],
+		}
+		self.session = lSession
+
+		// # Redirect等会提前写出响应头 须在此之前写出会话Cookie
+		if lResp, ok := self.Response.(interface{ BeforeWriteHeader(func()) }); ok {
+			lResp.BeforeWriteHeader(lSession.commit)
+		}
+	}
+	return self.session
+}
+
+// 会话id 新会话在保存前为空
+func (self *TSession) Id() string {
+	self.load()
+	return self.id
+}
+
+func (self *TSession) Get(key string) interface{} {
+	self.load()
+	return self.values[key]
+}
+
+func (self *TSession) GetString(key string) string {
+	lValue, _ := self.Get(key).(string)
+	return lValue
+}
+
+// 取得整数 兼容存储为JSON后得到的float64
+func (self *TSession) GetInt(key string) int {
+	switch v := self.Get(key).(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case json.Number:
+		i, _ := v.Int64()
+		return int(i)
+	}
+	return 0
+}
+
+func (self *TSession) Has(key string) bool {
+	self.load()
+	_, ok := self.values[key]
+	return ok
+}
+
+// 设置值 文件和Cookie存储以JSON保存 值需可被JSON编码
+func (self *TSession) Set(key string, value interface{}) {
+	self.load()
+	self.values[key] = value
+	self.changed = true
+}
+
+func (self *TSession) Delete(key string) {
+	self.load()
+	if _, ok := self.values[key]; ok {
+		delete(self.values, key)
+		self.changed = true
+	}
+}
+
+// 更换会话id 保留数据 登录等权限变化后调用
+func (self *TSession) Regenerate() error {
+	self.load()
+	if self.destroyed {
+		return ErrSessionDestroyed
+	}
+
+	lId, err := newSessionId()
+	if err != nil {
+		return err
+	}
+	if self.oldId == "" {
+		self.oldId = self.id
+	}
+	self.id = lId
+	self.changed = true
+	return nil
+}
+
+// 删除会话数据和Cookie
+func (self *TSession) Destroy() error {
+	self.load()
+	self.values = map[string]interface{}{}
+	self.destroyed = true
+	self.changed = false
+
+	if self.id != "" {
+		if err := self.manager.Store.Delete(self.id); err != nil {
+			return err
+		}
+	}
+	if self.oldId != "" {
+		self.manager.Store.Delete(self.oldId)
+	}
+	self.handler.DeleteCookie(self.manager.CookieName, self.manager.Path)
+	return nil
+}
+
+// 第一次访问时加载 id无效时开始新会话
+func (self *TSession) load() {
+	if self.loaded {
+		return
+	}
+	self.loaded = true
+
+	if self.id != "" {
+		lValues, err := self.manager.Store.Load(self.id)
+		if err != nil {
+			logger.Err("load session faild: %s", err.Error())
+		}
+		if lValues != nil {
+			self.values = lValues
+			return
+		}
+	}
+
+	self.id = ""
+	self.values = map[string]interface{}{}
+}
+
+// 保存会话 失败时记录日志
+func (self *TSession) commit() {
+	if err := self.save(); err != nil {
+		logger.Err("save session faild: %s", err.Error())
+	}
+}
+
+// 保存修改过的会话并写出Cookie
+func (self *TSession) save() error {
+	if !self.changed || self.destroyed {
+		return nil
+	}
+	self.changed = false
+
+	if self.id == "" {
+		lId, err := newSessionId()
+		if err != nil {
+			return err
+		}
+		self.id = lId
+	}
+
+	lValue, err := self.manager.Store.Save(self.id, self.values, self.manager.MaxAge)
+	if err != nil {
+		return err
+	}
+	if self.oldId != "" {
+		self.manager.Store.Delete(self.oldId)
+		self.oldId = ""
+	}
+
+	if self.handler.Response.Written() {
+		if lValue != self.handler.COOKIE[self.manager.CookieName] {
+			return errors.New("session cookie can not be set after the response has been written")
+		}
+		return nil
+	}
+
+	self.handler.SetCookie(&http.Cookie{
+		Name:     self.manager.CookieName,
+		Value:    lValue,
+		Path:     self.manager.Path,
+		Domain:   self.manager.Domain,
+		MaxAge:   int(self.manager.MaxAge / time.Second),
+		Secure:   self.manager.Secure,
+		HttpOnly: self.manager.HttpOnly,
+		SameSite: self.manager.SameSite,
+	})
+	return nil
+}
+
+func newSessionId() (string, error) {
+	lId := make([]byte, 24)
+	if _, err := rand.Read(lId); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(lId), nil
+}
@@ -0,0 +1,304 @@
+package web
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	session_store 负责保存会话数据
+	@TMemorySessionStore 保存在内存 定时清除过期会话 重启后丢失
+	@TFileSessionStore 每个会话一个JSON文件
+	@TCookieSessionStore 数据签名后保存在Cookie中 服务端无状态 数据不能超过4K
+*/
+
+const MAX_SESSION_COOKIE_SIZE = 4000
+
+var (
+	ErrInvalidSessionId = errors.New("invalid session id")
+	ErrSessionTooLarge  = errors.New("session too large for cookie")
+	ErrNoSessionSecret  = errors.New("cookie session store requires a secret")
+	sessionGCInterval   = 10 * time.Minute
+)
+
+type (
+	// 内存存储
+	TMemorySessionStore struct {
+		lock     sync.Mutex
+		sessions map[string]*tMemorySession
+		gcOnce   sync.Once
+	}
+
+	tMemorySession struct {
+		values  map[string]interface{}
+		expires time.Time
+	}
+
+	// 文件存储
+	TFileSessionStore struct {
+		Dir string
+	}
+
+	// Cookie存储 密钥含义与 Config.CookieSecret 相同
+	TCookieSessionStore struct {
+		keys []string
+	}
+
+	// 文件和Cookie中保存的格式
+	tSessionData struct {
+		Expires int64                  `json:"e"`
+		Values  map[string]interface{} `json:"v"`
+	}
+)
+
+func NewMemorySessionStore() *TMemorySessionStore {
+	return &TMemorySessionStore{
+		sessions: map[string]*tMemorySession{},
+	}
+}
+
+func (self *TMemorySessionStore) Load(id string) (map[string]interface{}, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	lSession, ok := self.sessions[id]
+	if !ok || time.Now().After(lSession.expires) {
+		return nil, nil
+	}
+	return copyValues(lSession.values), nil
+}
+
+// 第一次保存时启动GC
+func (self *TMemorySessionStore) Save(id string, values map[string]interface{}, maxAge time.Duration) (string, error) {
+	self.gcOnce.Do(func() {
+		go func() {
+			for range time.Tick(sessionGCInterval) {
+				self.GC()
+			}
+		}()
+	})
+
+	self.lock.Lock()
+	self.sessions[id] = &tMemorySession{values: copyValues(values), expires: time.Now().Add(maxAge)}
+	self.lock.Unlock()
+	return id, nil
+}
+
+func (self *TMemorySessionStore) Delete(id string) error {
+	self.lock.Lock()
+	delete(self.sessions, id)
+	self.lock.Unlock()
+	return nil
+}
+
+// 清除过期会话
+func (self *TMemorySessionStore) GC() {
+	lNow := time.Now()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for id, lSession := range self.sessions {
+		if lNow.After(lSession.expires) {
+			delete(self.sessions, id)
+		}
+	}
+}
+
+// 会话数量 包括未清除的过期会话
+func (self *TMemorySessionStore) Len() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return len(self.sessions)
+}
+
+func NewFileSessionStore(dir string) *TFileSessionStore {
+	return &TFileSessionStore{
+		Dir: dir,
+	}
+}
+
+func (self *TFileSessionStore) Load(id string) (map[string]interface{}, error) {
+	lFile, err := self.path(id)
+	if err != nil {
+		return nil, nil // 伪造的id视为不存在
+	}
+
+	lData, err := os.ReadFile(lFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lSession tSessionData
+	if err := json.Unmarshal(lData, &lSession); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > lSession.Expires {
+		os.Remove(lFile)
+		return nil, nil
+	}
+	if lSession.Values == nil {
+		lSession.Values = map[string]interface{}{}
+	}
+	return lSession.Values, nil
+}
+
+// 写入临时文件后改名
+func (self *TFileSessionStore) Save(id string, values map[string]interface{}, maxAge time.Duration) (string, error) {
+	lFile, err := self.path(id)
+	if err != nil {
+		return "", err
+	}
+
+	lData, err := json.Marshal(tSessionData{Expires: time.Now().Add(maxAge).Unix(), Values: values})
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(self.Dir, 0700); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(self.Dir, ".session-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(lData)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), lFile)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return id, nil
+}
+
+func (self *TFileSessionStore) Delete(id string) error {
+	lFile, err := self.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(lFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 删除过期的会话文件
+func (self *TFileSessionStore) GC() error {
+	lEntries, err := os.ReadDir(self.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, lEntry := range lEntries {
+		if _, err := hex.DecodeString(lEntry.Name()); err != nil || lEntry.IsDir() {
+			continue
+		}
+		self.Load(lEntry.Name()) // 过期时删除
+	}
+	return nil
+}
+
+// id只能是newSessionId生成的16进制字符串
+func (self *TFileSessionStore) path(id string) (string, error) {
+	if _, err := hex.DecodeString(id); err != nil || len(id) < 32 {
+		return "", ErrInvalidSessionId
+	}
+	return filepath.Join(self.Dir, id), nil
+}
+
+// keys 第一个用于签名 全部用于验证
+func NewCookieSessionStore(keys ...string) *TCookieSessionStore {
+	var lKeys []string
+	for _, lKey := range keys {
+		if lKey = strings.TrimSpace(lKey); lKey != "" {
+			lKeys = append(lKeys, lKey)
+		}
+	}
+	if len(lKeys) == 0 {
+		logger.Panic(ErrNoSessionSecret.Error())
+	}
+
+	return &TCookieSessionStore{
+		keys: lKeys,
+	}
+}
+
+// id为Cookie值 验证签名和有效期
+func (self *TCookieSessionStore) Load(id string) (map[string]interface{}, error) {
+	lPayload, lSign, ok := strings.Cut(id, ".")
+	if !ok {
+		return nil, nil
+	}
+	lMac, err := base64.RawURLEncoding.DecodeString(lSign)
+	if err != nil {
+		return nil, nil
+	}
+
+	for _, lKey := range self.keys {
+		if !hmac.Equal(lMac, signCookie(lKey, "session", lPayload)) {
+			continue
+		}
+
+		lData, err := base64.RawURLEncoding.DecodeString(lPayload)
+		if err != nil {
+			return nil, nil
+		}
+		var lSession tSessionData
+		if err := json.Unmarshal(lData, &lSession); err != nil {
+			return nil, err
+		}
+		if time.Now().Unix() > lSession.Expires {
+			return nil, nil
+		}
+		if lSession.Values == nil {
+			lSession.Values = map[string]interface{}{}
+		}
+		return lSession.Values, nil
+	}
+	return nil, nil
+}
+
+// 返回签名后的数据 作为新的Cookie值
+func (self *TCookieSessionStore) Save(id string, values map[string]interface{}, maxAge time.Duration) (string, error) {
+	lData, err := json.Marshal(tSessionData{Expires: time.Now().Add(maxAge).Unix(), Values: values})
+	if err != nil {
+		return "", err
+	}
+
+	lPayload := base64.RawURLEncoding.EncodeToString(lData)
+	lValue := lPayload + "." + base64.RawURLEncoding.EncodeToString(signCookie(self.keys[0], "session", lPayload))
+	if len(lValue) > MAX_SESSION_COOKIE_SIZE {
+		return "", ErrSessionTooLarge
+	}
+	return lValue, nil
+}
+
+// 数据在客户端 删除Cookie即可
+func (self *TCookieSessionStore) Delete(id string) error {
+	return nil
+}
+
+func copyValues(aValues map[string]interface{}) map[string]interface{} {
+	lValues := make(map[string]interface{}, len(aValues))
+	for k, v := range aValues {
+		lValues[k] = v
+	}
+	return lValues
+}
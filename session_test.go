@@ -0,0 +1,117 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	stores := map[string]ISessionStore{
+		"memory": NewMemorySessionStore(),
+		"file":   NewFileSessionStore(t.TempDir()),
+		"cookie": NewCookieSessionStore("new", "old"),
+	}
+
+	for name, store := range stores {
+		var uid int
+		var sid string
+		m := NewModule(nil, "m")
+		m.Get("/login", func(hd *THandler) {
+			hd.Session().Set("uid", 42)
+			hd.Session().Set("name", "tom")
+		})
+		m.Get("/me", func(hd *THandler) {
+			uid = hd.Session().GetInt("uid")
+			sid = hd.Session().Id()
+		})
+		m.Get("/regenerate", func(hd *THandler) {
+			hd.Session().Delete("name")
+			hd.Session().Regenerate()
+		})
+		m.Get("/logout", func(hd *THandler) {
+			hd.Session().Destroy()
+		})
+		m.Get("/none", func(hd *THandler) {})
+		router := NewRouter()
+		router.Sessions = NewSessionManager(store)
+		router.RegisterModule(m)
+
+		var cookie *http.Cookie
+		get := func(url string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", url, nil)
+			if cookie != nil {
+				req.AddCookie(cookie)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			for _, c := range w.Result().Cookies() {
+				cookie = c
+			}
+			return w
+		}
+
+		if w := get("/none"); len(w.Header()["Set-Cookie"]) != 0 {
+			t.Errorf("%s: unused session should not set cookie", name)
+		}
+
+		get("/login")
+		if cookie == nil || cookie.Name != DEFAULT_SESSION_COOKIE || !cookie.HttpOnly || cookie.MaxAge != int(DEFAULT_SESSION_MAX_AGE/time.Second) {
+			t.Fatalf("%s: unexpected session cookie %v", name, cookie)
+		}
+
+		get("/me")
+		if uid != 42 {
+			t.Errorf("%s: expect uid 42 but got %d", name, uid)
+		}
+
+		old := cookie
+		get("/regenerate")
+		if cookie.Value == old.Value {
+			t.Errorf("%s: expect new session id", name)
+		}
+		get("/me")
+		if uid != 42 {
+			t.Errorf("%s: expect uid kept after regenerate but got %d", name, uid)
+		}
+		if name != "cookie" {
+			lValues, _ := store.Load(old.Value)
+			if lValues != nil {
+				t.Errorf("%s: expect old session deleted", name)
+			}
+		}
+
+		get("/logout")
+		if cookie.MaxAge != -1 {
+			t.Errorf("%s: expect session cookie deleted but got %v", name, cookie)
+		}
+		cookie = old
+		if name != "cookie" {
+			get("/me")
+			if uid != 0 || sid != "" {
+				t.Errorf("%s: expect destroyed session but got %d %q", name, uid, sid)
+			}
+		}
+
+		// 伪造的id
+		cookie = &http.Cookie{Name: DEFAULT_SESSION_COOKIE, Value: "../../etc/passwd"}
+		get("/me")
+		if uid != 0 || sid != "" {
+			t.Errorf("%s: expect forged id ignored", name)
+		}
+	}
+}
+
+func TestMemorySessionStoreGC(t *testing.T) {
+	store := NewMemorySessionStore()
+	store.Save("a", map[string]interface{}{"k": 1}, -time.Second)
+	store.Save("b", map[string]interface{}{"k": 1}, time.Hour)
+	if v, _ := store.Load("a"); v != nil {
+		t.Errorf("expect expired session not loaded")
+	}
+	store.GC()
+	if store.Len() != 1 {
+		t.Errorf("expect 1 session after gc but got %d", store.Len())
+	}
+}
@@ -0,0 +1,210 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	sse 负责 Server-Sent Events 推送
+	@事件直接写出并刷新 不经过 Result/Apply
+	@客户端断开时 Done 关闭 Send 返回错误 控制器应随之返回
+	@控制器返回后事件流自动关闭
+
+	lStream, err := hd.SSE(15 * time.Second)
+	if err != nil {
+		return
+	}
+	for {
+		select {
+		case lMsg := <-lMessages:
+			lStream.Send(TEvent{Event: "message", Data: lMsg})
+		case <-lStream.Done():
+			return
+		}
+	}
+*/
+
+var ErrStreamClosed = errors.New("event stream closed")
+
+type (
+	// 一个事件 Data 为 string/[]byte 时原样写出 其他值编码为JSON
+	TEvent struct {
+		Id    string
+		Event string
+		Data  interface{}
+		Retry time.Duration // 客户端重连等待时间 0为不设置
+	}
+
+	// 事件流
+	TEventStream struct {
+		LastEventId string // 客户端重连时带来的 Last-Event-ID
+
+		handler *THandler
+		ctx     context.Context
+		lock    sync.Mutex
+		closed  bool
+		stop    chan struct{}
+		done    chan struct{}
+	}
+)
+
+// 开始事件流 heartbeat 大于0时按此间隔发送注释行保持连接
+// 响应已写出时返回错误
+func (self *THandler) SSE(heartbeat ...time.Duration) (*TEventStream, error) {
+	if self.stream != nil {
+		return self.stream, nil
+	}
+	if self.Response.Written() {
+		return nil, errors.New("can not start event stream after the response has been written")
+	}
+
+	lHeader := self.Header()
+	lHeader.Set("Content-Type", "text/event-stream; charset=utf-8")
+	lHeader.Set("Cache-Control", "no-cache")
+	lHeader.Set("Connection", "keep-alive")
+	lHeader.Set("X-Accel-Buffering", "no") // 禁止nginx缓冲
+	self.WriteHeader(http.StatusOK)
+
+	lStream := &TEventStream{
+		LastEventId: self.Request.Header.Get("Last-Event-ID"),
+		handler:     self,
+		ctx:         self.Request.Context(),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	self.stream = lStream
+	self.flush()
+
+	go func() {
+		select {
+		case <-lStream.ctx.Done():
+		case <-lStream.stop:
+		}
+		close(lStream.done)
+	}()
+
+	if len(heartbeat) > 0 && heartbeat[0] > 0 {
+		go lStream.heartbeat(heartbeat[0])
+	}
+	return lStream, nil
+}
+
+func (self *THandler) flush() {
+	if lFlusher, ok := self.Response.(http.Flusher); ok {
+		lFlusher.Flush()
+	}
+}
+
+// 发送事件
+func (self *TEventStream) Send(event TEvent) error {
+	var lBuf bytes.Buffer
+	if event.Id != "" {
+		writeEventField(&lBuf, "id", event.Id)
+	}
+	if event.Event != "" {
+		writeEventField(&lBuf, "event", event.Event)
+	}
+	if event.Retry > 0 {
+		writeEventField(&lBuf, "retry", strconv.FormatInt(int64(event.Retry/time.Millisecond), 10))
+	}
+
+	switch v := event.Data.(type) {
+	case nil:
+	case string:
+		writeEventField(&lBuf, "data", v)
+	case []byte:
+		writeEventField(&lBuf, "data", string(v))
+	default:
+		var lData bytes.Buffer
+		if err := GetEncoder(MIME_JSON).Encode(&lData, v); err != nil {
+			return err
+		}
+		writeEventField(&lBuf, "data", lData.String())
+	}
+	lBuf.WriteByte('\n')
+
+	return self.write(lBuf.Bytes())
+}
+
+// 发送只有名称和数据的事件
+func (self *TEventStream) Event(name string, data interface{}) error {
+	return self.Send(TEvent{Event: name, Data: data})
+}
+
+// 发送注释行 客户端忽略 用于保持连接
+func (self *TEventStream) Comment(text string) error {
+	var lBuf bytes.Buffer
+	for _, lLine := range splitEventLines(text) {
+		lBuf.WriteString(": " + lLine + "\n")
+	}
+	lBuf.WriteByte('\n')
+	return self.write(lBuf.Bytes())
+}
+
+// 客户端断开或事件流关闭时关闭
+func (self *TEventStream) Done() <-chan struct{} {
+	return self.done
+}
+
+// 关闭事件流 停止心跳 可多次调用
+func (self *TEventStream) Close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if !self.closed {
+		self.closed = true
+		close(self.stop)
+	}
+}
+
+func (self *TEventStream) write(data []byte) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		return ErrStreamClosed
+	}
+	if err := self.ctx.Err(); err != nil {
+		return err
+	}
+
+	if _, err := self.handler.Response.Write(data); err != nil {
+		return err
+	}
+	self.handler.flush()
+	return nil
+}
+
+func (self *TEventStream) heartbeat(interval time.Duration) {
+	lTicker := time.NewTicker(interval)
+	defer lTicker.Stop()
+
+	for {
+		select {
+		case <-lTicker.C:
+			if self.Comment("ping") != nil {
+				return
+			}
+		case <-self.done:
+			return
+		}
+	}
+}
+
+// 多行值每行写为一个字段
+func writeEventField(aBuf *bytes.Buffer, aName, aValue string) {
+	for _, lLine := range splitEventLines(aValue) {
+		aBuf.WriteString(aName + ": " + lLine + "\n")
+	}
+}
+
+func splitEventLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(strings.ReplaceAll(s, "\r", "\n"), "\n")
+}
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSE(t *testing.T) {
+	var sendErr error
+	m := NewModule(nil, "m")
+	m.Get("/events", func(hd *THandler) {
+		lStream, err := hd.SSE(5 * time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lStream.Send(TEvent{Id: "7", Event: "resume", Data: lStream.LastEventId, Retry: 3 * time.Second})
+		lStream.Event("order", map[string]int{"id": 1})
+		lStream.Send(TEvent{Data: "a\nb"})
+		time.Sleep(20 * time.Millisecond)
+		hd.RespondString("ignored")
+	})
+	m.Get("/closed", func(hd *THandler) {
+		lStream, _ := hd.SSE()
+		<-lStream.Done()
+		sendErr = lStream.Event("late", "x")
+	})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Last-Event-ID", "6")
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Type") != "text/event-stream; charset=utf-8" || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("unexpected headers %v", w.Header())
+	}
+	expect := "id: 7\nevent: resume\nretry: 3000\ndata: 6\n\n" +
+		"event: order\ndata: {\"id\":1}\n\n" +
+		"data: a\ndata: b\n\n"
+	if !strings.HasPrefix(w.Body.String(), expect) {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), ": ping\n\n") {
+		t.Errorf("expect heartbeat but got %q", w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "ignored") {
+		t.Errorf("expect Result ignored after streaming")
+	}
+
+	// 客户端断开
+	ctx, cancel := context.WithCancel(context.Background())
+	req = httptest.NewRequest("GET", "/closed", nil).WithContext(ctx)
+	time.AfterFunc(5*time.Millisecond, cancel)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if sendErr != context.Canceled {
+		t.Errorf("expect context.Canceled but got %v", sendErr)
+	}
+}
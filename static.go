@@ -0,0 +1,313 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	static 负责挂载静态文件系统
+	@文件系统可以是 os.DirFS 或 embed.FS,使单个程序文件即可携带模块的静态资源
+
+	//go:embed static
+	var assets embed.FS
+
+	lSub, _ := fs.Sub(assets, "static")
+	module.Static("/web/static", lSub)
+	module.Templates(lTemplates) // 模板同样可由fs.FS提供 见 templates.go
+
+	@缓存
+	1.所有静态文件带强ETag(内容sha256),客户端可用If-None-Match协商
+	2.Cache-Control 由 Router.SetCacheControl 按路径前缀配置
+	3.客户端接受时优先响应同目录下预压缩的 .br/.gz 文件
+	4.模板函数 asset 把地址改写为带内容指纹的地址 /static/js/app.3f2a9c1b7d4e.js
+	  带正确指纹的请求响应为永久缓存
+*/
+
+const (
+	// 带正确指纹的静态文件的缓存策略
+	IMMUTABLE_CACHE_CONTROL = "public, max-age=31536000, immutable"
+
+	fingerprintLen = 12
+)
+
+type (
+	// 静态文件系统 缓存文件的内容Hash
+	TStaticFS struct {
+		FS     fs.FS
+		hashes sync.Map          // name:*tStaticHash
+		allow  func(string) bool // 预压缩文件的额外检查 为空时不检查
+	}
+
+	tStaticHash struct {
+		modTime time.Time
+		size    int64
+		hash    string
+	}
+
+	tStaticFile struct {
+		content io.ReadSeeker
+		info    fs.FileInfo
+		closer  io.Closer
+	}
+
+	// Cache-Control 规则
+	tCacheRule struct {
+		prefix string
+		value  string
+	}
+)
+
+// 挂载静态文件系统到路由树
+// urlPrefix 下的GET/HEAD请求由fsys中对应的文件响应,目录不提供列表
+func (self *TModule) Static(urlPrefix string, fsys fs.FS) *TRoute {
+	if fsys == nil {
+		logger.Panic("the static file system must not be nil!")
+	}
+
+	lStatic := NewStaticFS(fsys)
+	lPrefix := strings.TrimRight(urlPrefix, "/")
+	lRoute := self.url(CommomRoute, []string{"GET", "HEAD"}, lPrefix+"/(*filepath)", func(hd *THandler) {
+		err := lStatic.serve(hd.Router, hd.Response, hd.Request, hd.PathParams().AsString("filepath"))
+		if err != nil {
+			respondFSError(hd, err)
+		}
+	}, "", "", 0)
+	lRoute.static = lStatic
+	return lRoute
+}
+
+func NewStaticFS(fsys fs.FS) *TStaticFS {
+	return &TStaticFS{
+		FS: fsys,
+	}
+}
+
+// 返回文件内容的sha256 16进制字符串
+func (self *TStaticFS) Hash(name string) (string, error) {
+	lFile, err := self.open(cleanStaticName(name))
+	if err != nil {
+		return "", err
+	}
+	defer lFile.Close()
+
+	return self.hash(name, lFile)
+}
+
+// 打开文件 目录视为不存在
+func (self *TStaticFS) open(name string) (*tStaticFile, error) {
+	if name == "" || !fs.ValidPath(name) {
+		return nil, fs.ErrNotExist
+	}
+
+	f, err := self.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+
+	// embed.FS 和 os.DirFS 的文件都支持Seek 其他文件系统读入内存
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		rs = bytes.NewReader(data)
+	}
+
+	return &tStaticFile{content: rs, info: fi, closer: f}, nil
+}
+
+// 计算并缓存文件Hash 文件修改时间或大小变化时重新计算
+func (self *TStaticFS) hash(name string, aFile *tStaticFile) (string, error) {
+	if v, ok := self.hashes.Load(name); ok {
+		lHash := v.(*tStaticHash)
+		if lHash.size == aFile.info.Size() && lHash.modTime.Equal(aFile.info.ModTime()) {
+			return lHash.hash, nil
+		}
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, aFile.content); err != nil {
+		return "", err
+	}
+	if _, err := aFile.content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	lHash := hex.EncodeToString(h.Sum(nil))
+	self.hashes.Store(name, &tStaticHash{
+		modTime: aFile.info.ModTime(),
+		size:    aFile.info.Size(),
+		hash:    lHash,
+	})
+	return lHash, nil
+}
+
+// 响应文件 文件不存在时尝试去掉地址中的指纹
+func (self *TStaticFS) serve(router *TRouter, w http.ResponseWriter, req *http.Request, name string) error {
+	name = cleanStaticName(name)
+	lFile, err := self.open(name)
+	if err == nil {
+		lFile.Close()
+		return self.serveFile(router, w, req, name, "")
+	}
+
+	if lName, lFingerprint, ok := splitFingerprint(name); ok && errors.Is(err, fs.ErrNotExist) {
+		return self.serveFile(router, w, req, lName, lFingerprint)
+	}
+
+	return err
+}
+
+// 响应文件 fingerprint 为地址中的指纹 与内容一致时响应为永久缓存
+func (self *TStaticFS) serveFile(router *TRouter, w http.ResponseWriter, req *http.Request, name, fingerprint string) error {
+	name = cleanStaticName(name)
+	lFile, err := self.open(name)
+	if err != nil {
+		return err
+	}
+	defer lFile.Close()
+
+	lHash, err := self.hash(name, lFile)
+	if err != nil {
+		return err
+	}
+
+	lHeader := w.Header()
+	if fingerprint != "" && strings.HasPrefix(lHash, fingerprint) {
+		lHeader.Set("Cache-Control", IMMUTABLE_CACHE_CONTROL)
+	} else if router != nil {
+		if lCache := router.cacheControl(req.URL.Path); lCache != "" {
+			lHeader.Set("Cache-Control", lCache)
+		}
+	}
+
+	// # 预压缩文件需先确定原文件的类型 否则ServeContent会按压缩内容嗅探
+	lType := mime.TypeByExtension(path.Ext(name))
+	if lType != "" {
+		lHeader.Set("Content-Type", lType)
+		addVary(lHeader, "Accept-Encoding")
+
+		for _, lEnc := range []struct{ name, ext string }{{"br", ".br"}, {"gzip", ".gz"}} {
+			if !acceptsEncoding(req.Header.Get("Accept-Encoding"), lEnc.name) {
+				continue
+			}
+
+			if self.allow != nil && !self.allow(name+lEnc.ext) {
+				continue
+			}
+
+			lVariant, err := self.open(name + lEnc.ext)
+			if err != nil {
+				continue
+			}
+			defer lVariant.Close()
+
+			lVariantHash, err := self.hash(name+lEnc.ext, lVariant)
+			if err != nil {
+				continue
+			}
+
+			lHeader.Set("Content-Encoding", lEnc.name)
+			lHeader.Set("Etag", `"`+lVariantHash+`"`)
+			http.ServeContent(w, req, lFile.info.Name(), lFile.info.ModTime(), lVariant.content)
+			return nil
+		}
+	}
+
+	lHeader.Set("Etag", `"`+lHash+`"`)
+	http.ServeContent(w, req, lFile.info.Name(), lFile.info.ModTime(), lFile.content)
+	return nil
+}
+
+func (self *tStaticFile) Close() error {
+	return self.closer.Close()
+}
+
+// 规范为fs.FS要求的无前导/的相对路径
+func cleanStaticName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// 在扩展名前插入内容指纹
+// /static/js/app.js -> /static/js/app.3f2a9c1b7d4e.js
+func Fingerprint(urlPath, hash string) string {
+	if len(hash) > fingerprintLen {
+		hash = hash[:fingerprintLen]
+	}
+
+	lDir, lFile := path.Split(urlPath)
+	lExt := path.Ext(lFile)
+	return lDir + strings.TrimSuffix(lFile, lExt) + "." + hash + lExt
+}
+
+// 从地址中分离内容指纹 Fingerprint 的逆操作
+func splitFingerprint(urlPath string) (string, string, bool) {
+	lDir, lFile := path.Split(urlPath)
+	lExt := path.Ext(lFile)
+	lBase := strings.TrimSuffix(lFile, lExt)
+	lIdx := strings.LastIndexByte(lBase, '.')
+	if lIdx < 0 || len(lBase)-lIdx-1 != fingerprintLen {
+		return urlPath, "", false
+	}
+
+	lHash := lBase[lIdx+1:]
+	if _, err := hex.DecodeString(lHash); err != nil {
+		return urlPath, "", false
+	}
+
+	return lDir + lBase[:lIdx] + lExt, lHash, true
+}
+
+// 客户端是否接受该编码 q=0 表示拒绝
+func acceptsEncoding(aHeader, aEncoding string) bool {
+	for _, lPart := range strings.Split(aHeader, ",") {
+		lParams := strings.Split(lPart, ";")
+		if !strings.EqualFold(strings.TrimSpace(lParams[0]), aEncoding) {
+			continue
+		}
+
+		for _, lParam := range lParams[1:] {
+			lParam = strings.TrimSpace(lParam)
+			if strings.HasPrefix(lParam, "q=") && strings.Trim(lParam[2:], "0.") == "" {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+func respondFSError(hd *THandler, err error) {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		hd.RespondWithNotFound()
+		return
+	}
+
+	logger.Err("serve static file faild: %s", err.Error())
+	hd.RespondError(http.StatusText(http.StatusInternalServerError))
+}
@@ -0,0 +1,139 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestModuleStatic(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/base.css":   {Data: []byte("body{}"), ModTime: time.Unix(1500000000, 0)},
+		"js/app.js":      {Data: []byte("var a;")},
+		"lib/index.html": {Data: []byte("<html></html>")},
+	}
+
+	m := NewModule(nil, "m")
+	m.Static("/web/static/", fsys)
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	cases := []struct {
+		method string
+		url    string
+		code   int
+		body   string
+		ctype  string
+	}{
+		{"GET", "/web/static/css/base.css", 200, "body{}", "text/css; charset=utf-8"},
+		{"HEAD", "/web/static/js/app.js", 200, "", ""},
+		{"GET", "/web/static/css/../js/app.js", 200, "var a;", ""},
+		{"GET", "/web/static/lib", 404, "", ""},
+		{"GET", "/web/static/", 404, "", ""},
+		{"GET", "/web/static/missing.js", 404, "", ""},
+	}
+	for _, c := range cases {
+		w := serveTest(router, c.method, c.url)
+		if w.Code != c.code {
+			t.Errorf("%s %s: expect status %d but got %d", c.method, c.url, c.code, w.Code)
+			continue
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			t.Errorf("%s %s: expect body %q but got %q", c.method, c.url, c.body, w.Body.String())
+		}
+		if c.ctype != "" && w.Header().Get("Content-Type") != c.ctype {
+			t.Errorf("%s %s: expect content type %q but got %q", c.method, c.url, c.ctype, w.Header().Get("Content-Type"))
+		}
+	}
+
+	w := serveTest(router, "GET", "/web/static/css/base.css")
+	if w.Header().Get("Last-Modified") == "" {
+		t.Errorf("expect Last-Modified from the file system")
+	}
+}
+
+func TestStaticCaching(t *testing.T) {
+	fsys := fstest.MapFS{
+		"js/app.js":    {Data: []byte("var app;")},
+		"js/app.js.br": {Data: []byte("br-data")},
+		"js/app.js.gz": {Data: []byte("gz-data")},
+		"lib/jq.js":    {Data: []byte("var jq;")},
+	}
+
+	m := NewModule(nil, "m")
+	m.Static("/static", fsys)
+	router := NewRouter()
+	router.RegisterModule(m)
+	router.SetCacheControl("/static", "no-cache")
+	router.SetCacheControl("/static/lib", "public, max-age=604800")
+
+	serve := func(url string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", url, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	sum := sha256.Sum256([]byte("var app;"))
+	hash := hex.EncodeToString(sum[:])
+
+	// ETag 和 304
+	w := serve("/static/js/app.js")
+	etag := w.Header().Get("Etag")
+	if etag != `"`+hash+`"` {
+		t.Fatalf("expect strong etag of the content but got %q", etag)
+	}
+	if w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("expect Cache-Control no-cache but got %q", w.Header().Get("Cache-Control"))
+	}
+	if w = serve("/static/js/app.js", "If-None-Match", etag); w.Code != 304 {
+		t.Errorf("expect 304 for matched etag but got %d", w.Code)
+	}
+
+	// 最长前缀优先
+	if w = serve("/static/lib/jq.js"); w.Header().Get("Cache-Control") != "public, max-age=604800" {
+		t.Errorf("expect lib Cache-Control but got %q", w.Header().Get("Cache-Control"))
+	}
+
+	// 预压缩文件
+	encodings := []struct {
+		accept string
+		enc    string
+		body   string
+	}{
+		{"gzip, br", "br", "br-data"},
+		{"gzip", "gzip", "gz-data"},
+		{"br;q=0, gzip;q=0.5", "gzip", "gz-data"},
+		{"", "", "var app;"},
+	}
+	for _, c := range encodings {
+		w = serve("/static/js/app.js", "Accept-Encoding", c.accept)
+		if w.Header().Get("Content-Encoding") != c.enc || w.Body.String() != c.body {
+			t.Errorf("Accept-Encoding %q: expect %q %q but got %q %q", c.accept, c.enc, c.body, w.Header().Get("Content-Encoding"), w.Body.String())
+		}
+		if w.Header().Get("Content-Type") != "text/javascript; charset=utf-8" {
+			t.Errorf("Accept-Encoding %q: unexpected content type %q", c.accept, w.Header().Get("Content-Type"))
+		}
+	}
+
+	// 指纹
+	url := router.AssetUrl("/static/js/app.js")
+	if url != "/static/js/app."+hash[:12]+".js" {
+		t.Fatalf("unexpected asset url %q", url)
+	}
+	if w = serve(url); w.Code != 200 || w.Body.String() != "var app;" || w.Header().Get("Cache-Control") != IMMUTABLE_CACHE_CONTROL {
+		t.Errorf("expect immutable response for fingerprinted url but got %d %q %q", w.Code, w.Body.String(), w.Header().Get("Cache-Control"))
+	}
+	if w = serve("/static/js/app.0123456789ab.js"); w.Code != 200 || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("expect stale fingerprint to be served without immutable but got %d %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	if url = router.AssetUrl("/static/missing.js"); url != "/static/missing.js" {
+		t.Errorf("expect missing asset url unchanged but got %q", url)
+	}
+}
@@ -0,0 +1,177 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/*
+	storage 负责保存上传的文件
+	@key 为以/分隔的相对路径 如 2024/05/01/3f2a9c1b7d4e5f60.png
+	@TDiskStorage 保存到硬盘 默认根目录为程序 uploads 不在静态根目录内 需经控制器下载
+	@TMemoryStorage 保存在内存 用于测试
+*/
+
+var ErrInvalidStorageKey = errors.New("invalid storage key")
+
+type (
+	// 上传文件存储接口
+	IUploadStorage interface {
+		Save(key string, r io.Reader) (int64, error)
+		Open(key string) (io.ReadCloser, error)
+		Delete(key string) error
+	}
+
+	// 硬盘存储
+	TDiskStorage struct {
+		Root string // 存储根目录
+	}
+
+	// 内存存储
+	TMemoryStorage struct {
+		lock  sync.RWMutex
+		files map[string][]byte
+	}
+)
+
+func NewDiskStorage(root string) *TDiskStorage {
+	return &TDiskStorage{
+		Root: root,
+	}
+}
+
+// 写入临时文件后改名 写入失败不会留下不完整的文件
+func (self *TDiskStorage) Save(key string, r io.Reader) (int64, error) {
+	lFile, err := self.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(lFile), 0755); err != nil {
+		return 0, err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(lFile), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), lFile)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (self *TDiskStorage) Open(key string) (io.ReadCloser, error) {
+	lFile, err := self.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(lFile)
+}
+
+func (self *TDiskStorage) Delete(key string) error {
+	lFile, err := self.path(key)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(lFile)
+}
+
+// key 转换为根目录下的文件路径
+func (self *TDiskStorage) path(key string) (string, error) {
+	if !validStorageKey(key) {
+		return "", ErrInvalidStorageKey
+	}
+
+	return filepath.Join(self.Root, filepath.FromSlash(key)), nil
+}
+
+func NewMemoryStorage() *TMemoryStorage {
+	return &TMemoryStorage{
+		files: make(map[string][]byte),
+	}
+}
+
+func (self *TMemoryStorage) Save(key string, r io.Reader) (int64, error) {
+	if !validStorageKey(key) {
+		return 0, ErrInvalidStorageKey
+	}
+
+	lData, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	self.lock.Lock()
+	self.files[key] = lData
+	self.lock.Unlock()
+	return int64(len(lData)), nil
+}
+
+func (self *TMemoryStorage) Open(key string) (io.ReadCloser, error) {
+	self.lock.RLock()
+	lData, ok := self.files[key]
+	self.lock.RUnlock()
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	return io.NopCloser(bytes.NewReader(lData)), nil
+}
+
+func (self *TMemoryStorage) Delete(key string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if _, ok := self.files[key]; !ok {
+		return fs.ErrNotExist
+	}
+
+	delete(self.files, key)
+	return nil
+}
+
+// 返回所有已保存的key
+func (self *TMemoryStorage) Keys() []string {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	lKeys := make([]string, 0, len(self.files))
+	for key := range self.files {
+		lKeys = append(lKeys, key)
+	}
+	return lKeys
+}
+
+// key 必须是不含 . 开头路径段的相对路径
+func validStorageKey(key string) bool {
+	if key == "" || strings.ContainsAny(key, `\:`) || path.IsAbs(key) || path.Clean(key) != key {
+		return false
+	}
+
+	for _, lSeg := range strings.Split(key, "/") {
+		if strings.HasPrefix(lSeg, ".") {
+			return false
+		}
+	}
+	return true
+}
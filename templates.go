@@ -0,0 +1,69 @@
+package web
+
+import (
+	"io/fs"
+	"path"
+)
+
+/*
+	templates 负责从fs.FS加载模块的模板
+	@与静态文件相同 文件系统可以是 os.DirFS 或 embed.FS,使单个程序文件即可携带模块的模板
+	@模板加入 Router.Template 与硬盘上的模板使用同一模板引擎 语法/模板函数/转义规则相同
+	@模块挂载模板文件系统后 hd.RenderTemplate 和错误页面优先从中读取,文件不存在时仍从硬盘读取
+	@模板在 Router.Template 中的名称以 @模块名称 为前缀 模块名称须唯一
+
+	//go:embed template
+	var views embed.FS
+
+	lSub, _ := fs.Sub(views, "template")
+	module.Templates(lSub)
+	hd.RenderTemplate("index.html", args) // 读取 lSub 中的 index.html
+*/
+
+// 挂载模块的模板文件系统 子模块没有挂载时使用父模块的
+func (self *TModule) Templates(fsys fs.FS) {
+	if fsys == nil {
+		logger.Panic("the template file system must not be nil!")
+	}
+
+	self.TemplateFS = fsys
+}
+
+// 模块模板在 Router.Template 中的前缀
+func (self *TModule) templatePrefix() string {
+	return "@" + self.Name
+}
+
+// 文件系统中是否存在该模板文件
+func hasTemplate(fsys fs.FS, name string) bool {
+	if !fs.ValidPath(name) {
+		return false
+	}
+
+	fi, err := fs.Stat(fsys, name)
+	return err == nil && !fi.IsDir()
+}
+
+// 路由所属模块的模板中存在该文件时返回其在 Router.Template 中的名称
+// 模块的模板文件系统在第一次使用时加入 Router.Template
+func (self *THandler) moduleTemplate(aTemplateFile string) string {
+	if self.Route == nil || self.Router.Template == nil {
+		return ""
+	}
+
+	for lModule := self.Route.module; lModule != nil; lModule = lModule.Parent {
+		if lModule.TemplateFS == nil {
+			continue
+		}
+		if !hasTemplate(lModule.TemplateFS, aTemplateFile) {
+			return ""
+		}
+
+		lPrefix := lModule.templatePrefix()
+		if _, loaded := self.Router.templateFS.LoadOrStore(lPrefix, lModule.TemplateFS); !loaded {
+			self.Router.Template.AddFS(lPrefix, lModule.TemplateFS)
+		}
+		return path.Join(lPrefix, aTemplateFile)
+	}
+	return ""
+}
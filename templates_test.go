@@ -0,0 +1,45 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/VectorsOrigin/template"
+)
+
+func TestModuleTemplates(t *testing.T) {
+	views := fstest.MapFS{
+		"index.html": {Data: []byte(`<main>{{.Name}} {{asset "/x.js"}}</main>`)},
+		"404.html":   {Data: []byte(`missing {{.Status}}`)},
+	}
+	m := NewModule(nil, "m")
+	m.Templates(views)
+	m.Get("/", func(hd *THandler) {
+		hd.RenderTemplate("index.html", map[string]interface{}{"Name": "<b>"})
+	})
+	sub := NewModule(m, "sub")
+	sub.Get("/sub", func(hd *THandler) {
+		hd.RespondWithNotFound()
+	})
+	router := NewRouter()
+	router.Template = template.NewTemplateSet()
+	router.RegisterModule(m)
+	router.RegisterModule(sub)
+	router.Init()
+
+	w := serveTest(router, "GET", "/")
+	if w.Code != http.StatusOK || w.Body.String() != "<main>&lt;b&gt; /x.js</main>" {
+		t.Errorf("unexpected page %d %q", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/sub", nil)
+	req.Header.Set("Accept", MIME_HTML)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "missing 404") {
+		t.Errorf("expect error page from the parent module templates but got %d %q", w.Code, w.Body.String())
+	}
+}
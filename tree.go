@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/VectorsOrigin/utils"
 )
@@ -42,6 +43,14 @@ var (
 		"TRACE",
 		"PATCH",
 	}
+
+	// 缓存匹配参数 避免每次请求分配内存
+	paramsPool = sync.Pool{
+		New: func() interface{} {
+			p := make(Params, 0, 8)
+			return &p
+		},
+	}
 )
 
 type (
@@ -73,6 +82,8 @@ type (
 		Route  *TRoute
 		Level  int // #动态Node排序等级 /.../ 之间的Nodes越多等级越高
 		regexp *regexp.Regexp
+
+		indices string // 静态子节点首字母索引 与Children[:len(indices)]一一对应
 	}
 
 	TTree struct {
@@ -261,7 +272,7 @@ func (r *TTree) parsePath(path string) (nodes []*TNode, isDyn bool) {
 				}
 
 				if len(regex) > 0 { // 正则
-					node = &TNode{Type: RegexpNode, regexp: regexp.MustCompile("(" + regex + ")"), Text: path[j : i-len(regex)]}
+					node = &TNode{Type: RegexpNode, regexp: regexp.MustCompile("^(" + regex + ")$"), Text: path[j : i-len(regex)]}
 					nodes = append(nodes, node)
 				} else { // 变量
 					node = &TNode{Type: VariantNode, ContentType: typ, Text: path[j:i]}
@@ -337,156 +348,152 @@ func (r *TTree) parsePath(path string) (nodes []*TNode, isDyn bool) {
 	return //nodes, isDyn
 }
 
+// 匹配节点
+// 静态节点由Radix压缩,通过首字母索引直接定位;动态节点按TSubNodes排序的优先级依次尝试,失败时回溯
+// 参数仅在匹配成功后追加,故回溯无需清理aParams
 func (r *TTree) matchNode(aNode *TNode, aUrl string, aParams *Params) *TNode {
-	var retnil bool
-	if aNode.Type == StaticNode { // 静态节点
-		if strings.HasPrefix(aUrl, aNode.Text) {
-			//fmt.Println("J态", aUrl, " | ", aNode.Text[1:])
-			if len(aUrl) == len(aNode.Text) {
-				return aNode
-			}
+	switch aNode.Type {
+	case StaticNode: // 静态节点
+		if !strings.HasPrefix(aUrl, aNode.Text) {
+			return nil
+		}
 
-			for _, c := range aNode.Children {
-				e := r.matchNode(c, aUrl[len(aNode.Text):], aParams)
-				if e != nil {
-					return e
-				}
-			}
+		lRest := aUrl[len(aNode.Text):]
+		if lRest == "" && aNode.Route != nil {
+			return aNode
 		}
 
-	} else if aNode.Type == AnyNode { // 全匹配节点
-		//if len(aNode.Children) == 0 {
-		//	*aParams = append(*aParams, param{aNode.Text[1:], aUrl})
-		//	return aNode
-		//}
-		//fmt.Println("Any态", aUrl, " | ", aNode.Text[1:])
+		return r.matchChildren(aNode, lRest, aParams)
+
+	case AnyNode: // 全匹配节点
+		// # 贪婪匹配:从最后出现的位置开始向前回溯
 		for _, c := range aNode.Children {
-			idx := strings.LastIndex(aUrl, c.Text)
-			//fmt.Println("LastIndex", aUrl, c.Text)
-			if idx > -1 {
-				h := r.matchNode(c, aUrl[idx:], aParams)
-				if h != nil {
+			for idx := strings.LastIndex(aUrl, c.Text); idx > -1; idx = strings.LastIndex(aUrl[:idx], c.Text) {
+				if h := r.matchNode(c, aUrl[idx:], aParams); h != nil {
 					*aParams = append(*aParams, param{aNode.Text[1:], aUrl[:idx]})
 					return h
 				}
-
 			}
 		}
 
-		*aParams = append(*aParams, param{aNode.Text[1:], aUrl})
-		return aNode
-
-	} else if aNode.Type == VariantNode { // 变量节点
-		// # 消除path like /abc 的'/'
-		idx := strings.IndexByte(aUrl, r.DelimitChar)
-		//fmt.Println("D态", aUrl, " | ", aNode.Text[1:], idx)
-		if idx == 0 { // #fix错误if idx > -1 {
-			for _, c := range aNode.Children {
-				h := r.matchNode(c, aUrl[idx:], aParams)
-				if h != nil {
-					/*fmt.Println("类型1", aUrl[:idx], aNode.ContentType)
-					if !validType(aUrl[:idx], aNode.ContentType) {
-						fmt.Println("错误类型", aUrl[:idx], aNode.ContentType)
-						return nil
-					}
-					*/
-					*aParams = append(*aParams, param{aNode.Text[1:], aUrl[:idx]})
-					return h
-				}
-			}
-			return nil
+		if aNode.Route != nil {
+			*aParams = append(*aParams, param{aNode.Text[1:], aUrl})
+			return aNode
+		}
+
+	case VariantNode, RegexpNode: // 变量节点 正则节点
+		// # 变量值不能跨越分隔符且不能为空
+		lEnd := strings.IndexByte(aUrl, r.DelimitChar)
+		if lEnd == -1 {
+			lEnd = len(aUrl)
 		}
 
-		// 最底层Node
-		//if len(aNode.Children) == 0 {
-		//	*aParams = append(*aParams, param{aNode.Text[1:], aUrl})
-		//	return aNode
-		//}
-		//fmt.Println("Index", idx)
 		for _, c := range aNode.Children {
-			idx := strings.Index(aUrl, c.Text) // #匹配前面检索到的/之前的字符串
-			//fmt.Println("Index", idx, aUrl, c.Text, aUrl[:idx])
-			if idx > -1 {
-				if len(aUrl[:idx]) > 1 && strings.IndexByte(aUrl[:idx], r.DelimitChar) > -1 {
-					retnil = true
-					continue
+			for lOff := 1; lOff <= lEnd; {
+				idx := strings.Index(aUrl[lOff:], c.Text)
+				if idx == -1 {
+					break
 				}
 
-				//fmt.Println("类型2", aUrl[:idx], aNode.ContentType)
-				if !validType(aUrl[:idx], aNode.ContentType) {
-					//fmt.Println("错误类型", aUrl[:idx], aNode.ContentType)
-					return nil
-					//continue
+				idx += lOff
+				if idx > lEnd {
+					break
 				}
-				h := r.matchNode(c, aUrl[idx:], aParams)
-				if h != nil {
-					*aParams = append(*aParams, param{aNode.Text[1:], aUrl[:idx]})
-					return h
-				}
-
-				retnil = true
-			}
-		}
-
-		if retnil {
-			return nil
-		}
 
-		//fmt.Printf("动态", aUrl, aNode.Text[1:])
-		*aParams = append(*aParams, param{aNode.Text[1:], aUrl})
-		return aNode
-
-	} else if aNode.Type == RegexpNode { // 正则节点
-		//if len(aNode.Children) == 0 && aNode.regexp.MatchString(aUrl) {
-		//	*aParams = append(*aParams, param{aNode.Text[1:], aUrl})
-		//	return aNode
-		//}
-		idx := strings.IndexByte(aUrl, r.DelimitChar)
-		if idx > -1 {
-			if aNode.regexp.MatchString(aUrl[:idx]) {
-				for _, c := range aNode.Children {
-					h := r.matchNode(c, aUrl[idx:], aParams)
-					if h != nil {
+				if aNode.validValue(aUrl[:idx]) {
+					if h := r.matchNode(c, aUrl[idx:], aParams); h != nil {
 						*aParams = append(*aParams, param{aNode.Text[1:], aUrl[:idx]})
 						return h
 					}
 				}
+				lOff = idx + 1
 			}
-			return nil
 		}
-		for _, c := range aNode.Children {
-			idx := strings.Index(aUrl, c.Text)
-			if idx > -1 && aNode.regexp.MatchString(aUrl[:idx]) {
-				h := r.matchNode(c, aUrl[idx:], aParams)
-				if h != nil {
-					*aParams = append(*aParams, param{aNode.Text[1:], aUrl[:idx]})
-					return h
-				}
 
+		// 最底层Node
+		if aNode.Route != nil && lEnd == len(aUrl) && lEnd > 0 && aNode.validValue(aUrl) {
+			*aParams = append(*aParams, param{aNode.Text[1:], aUrl})
+			return aNode
+		}
+	}
+
+	return nil
+}
+
+// 匹配子节点
+func (r *TTree) matchChildren(aNode *TNode, aUrl string, aParams *Params) *TNode {
+	// 静态子节点首字母互不相同,最多只有一个候选
+	if len(aUrl) > 0 {
+		if i := strings.IndexByte(aNode.indices, aUrl[0]); i > -1 {
+			if h := r.matchNode(aNode.Children[i], aUrl, aParams); h != nil {
+				return h
 			}
 		}
+	}
 
-		if aNode.regexp.MatchString(aUrl) {
-			*aParams = append(*aParams, param{aNode.Text[1:], aUrl})
-			return aNode
+	for _, c := range aNode.Children[len(aNode.indices):] {
+		if h := r.matchNode(c, aUrl, aParams); h != nil {
+			return h
 		}
+	}
+
+	return nil
+}
 
+// 匹配路由并将参数写入aParams
+// aParams 会先被清空,可重复使用以避免内存分配
+func (r *TTree) Lookup(method string, url string, aParams *Params) *TRoute {
+	*aParams = (*aParams)[:0]
+	lRoot := r.Root[method]
+	if lRoot == nil {
+		return nil
 	}
 
+	if e := r.matchChildren(lRoot, url, aParams); e != nil {
+		return e.Route
+	}
+
+	*aParams = (*aParams)[:0]
 	return nil
 }
 
+// 匹配路由
+// 返回的Params由调用者持有,需要避免分配时使用Lookup
 func (r *TTree) Match(method string, url string) (*TRoute, Params) {
-	lRoot := r.Root[method]
+	lParams := getParams()
+	defer putParams(lParams)
 
-	var lParams = make(Params, 0, strings.Count(url, string(r.DelimitChar)))
-	for _, n := range lRoot.Children {
-		e := r.matchNode(n, url, &lParams)
-		if e != nil {
-			return e.Route, lParams
-		}
+	lRoute := r.Lookup(method, url, lParams)
+	if lRoute == nil {
+		return nil, nil
+	}
+
+	var res Params
+	if len(*lParams) > 0 {
+		res = make(Params, len(*lParams))
+		copy(res, *lParams)
+	}
+	return lRoute, res
+}
+
+// 从池中获得空的Params
+func getParams() *Params {
+	return paramsPool.Get().(*Params)
+}
+
+// 回收Params
+func putParams(p *Params) {
+	*p = (*p)[:0]
+	paramsPool.Put(p)
+}
+
+// 验证变量值是否符合节点的类型或正则
+func (self *TNode) validValue(content string) bool {
+	if self.Type == RegexpNode {
+		return self.regexp.MatchString(content)
 	}
-	return nil, nil
+
+	return validType(content, self.ContentType)
 }
 
 func validType(content string, typ ContentType) bool {
@@ -528,115 +535,169 @@ func validNodes(nodes []*TNode) bool {
 
 // 添加路由到Tree
 func (self *TTree) AddRoute(aMethod, path string, aRoute *TRoute) {
+	self.addRoute(aMethod, path, aRoute, false)
+}
+
+// 添加路由到Tree
+// aIsHook 为true时已存在的路由将合并Controller,反之替换
+func (self *TTree) addRoute(aMethod, path string, aRoute *TRoute, aIsHook bool) {
 	// 解析并创建为Nodes的List形式
 	lNodes, lIsDyn := self.parsePath(path)
 
-	// 标记为动态路由
-	aRoute.isDynRoute = lIsDyn // 即将Hook的新Route是动态地址
-
-	// 绑定Route到最后一个Node
-	lNode := lNodes[len(lNodes)-1]
-	aRoute.Action = lNode.Text // 赋值Action
-	lNode.Route = aRoute
-	lNode.Path = path
 	// 验证合法性
 	if !validNodes(lNodes) {
 		logger.Panic("express %s is not supported", path)
 	}
 
-	// 插入该节点到Tree
-	self.addnodes(aMethod, lNodes, false)
+	// 标记为动态路由
+	aRoute.isDynRoute = lIsDyn                 // 即将Hook的新Route是动态地址
+	aRoute.Action = lNodes[len(lNodes)-1].Text // 赋值Action
+
+	// 插入该节点到Tree并绑定Route到最后一个Node
+	lNode := self.addnodes(aMethod, lNodes)
+	switch {
+	case lNode.Route == nil && aIsHook:
+		// 合并时使用副本 避免修改源树的路由
+		lNode.Route = aRoute.clone()
+	case lNode.Route == nil:
+		lNode.Route = aRoute
+	case !aIsHook && aRoute.hasMain() && len(lNode.Route.BeforeCtrls) == 0 && len(lNode.Route.AfterCtrls) == 0:
+		// 原始路由会被替换
+		lNode.Route = aRoute
+	default:
+		// 叠加合并Controller 替换主控制器时保留已有的Hook
+		// 同一Route可能被多个方法的节点共用 所以合并到副本
+		lRoute := lNode.Route.clone()
+		lRoute.CombineController(aRoute)
+		lNode.Route = lRoute
+	}
+	lNode.Path = path
 }
 
-// conbine 2 node together
-func (self *TTree) conbine(aDes, aSrc *TNode) {
-	var lNode *TNode
-
-	// 是否目标Node有该Node
-	for _, node := range aDes.Children {
-		if node.Equal(aSrc) {
-			lNode = node
+// 删除指定名称的路由
+func (self *TTree) DelRoute(name string) bool {
+	lFound := false
+	for _, root := range self.Root {
+		if root.delRoute(name) {
+			lFound = true
 		}
 	}
-	// 如果:无该Node直接添加完成所有工作
-	// 或者:遍历添加所有没有的新Node
-	if lNode == nil {
-		aDes.Children = append(aDes.Children, aSrc)
-		return
-	} else {
-		if lNode.Type == RegexpNode {
+	return lFound
+}
 
-		}
+// 删除子树中指定名称的路由并剪除空分支
+func (self *TNode) delRoute(name string) bool {
+	lFound := false
+	if self.Route != nil && self.Route.settings().Name == name {
+		self.Route = nil
+		self.Path = ""
+		lFound = true
+	}
 
-		if aSrc.Route != nil {
-			if lNode.Route == nil {
-				lNode.Route = aSrc.Route
-			} else {
-				// 叠加合并Controller
-				lNode.Route.CombineController(aSrc.Route)
-			}
+	lChildren := self.Children[:0]
+	for _, c := range self.Children {
+		if c.delRoute(name) {
+			lFound = true
 		}
 
-		// 合并子节点
-		for _, _node := range aSrc.Children {
-			self.conbine(lNode, _node)
+		if c.Route == nil && len(c.Children) == 0 {
+			continue
 		}
+		lChildren = append(lChildren, c)
 	}
+	self.Children = lChildren
+	self.updateIndices()
+	return lFound
 }
 
 // conbine 2 tree together
 func (self *TTree) Conbine(aTree *TTree) *TTree {
 	for method, snode := range aTree.Root {
-		// 如果主树没有该方法叉则直接移植
-		if node, has := self.Root[method]; !has {
-			self.Root[method] = snode
-		} else {
-			// 采用逐个添加
-			for _, n := range snode.Children {
-				self.conbine(node, n)
+		// 逐个添加源树的路由
+		snode.walk(func(n *TNode) {
+			if n.Route != nil {
+				self.addRoute(method, n.Path, n.Route, true)
 			}
-
-		}
+		})
 	}
 
 	return self
 }
 
-// add node nodes[i] to parent node p
-func (self *TNode) addnode(aParent *TNode, aNodes []*TNode, i int, aIsHook bool) *TNode {
-	if len(aParent.Children) == 0 {
-		aParent.Children = make([]*TNode, 0)
+// 遍历节点及其所有子节点
+func (self *TNode) walk(fn func(*TNode)) {
+	fn(self)
+	for _, c := range self.Children {
+		c.walk(fn)
 	}
+}
 
-	// 如果:找到[已经注册]的分支节点则从该节继续[查找/添加]下一个节点
-	for _, n := range aParent.Children {
-		if n.Equal(aNodes[i]) {
-			// 如果:插入的节点层级已经到末尾,则为该节点注册路由
-			if i == len(aNodes)-1 {
-				// 原始路由会被替换
-				if aIsHook {
-					n.Route.CombineController(aNodes[i].Route)
-				} else {
-					n.Route = aNodes[i].Route
-				}
+// 添加静态路径到节点
+// 与已有静态子节点共享前缀时拆分该子节点,返回完整匹配aText的节点
+func (self *TNode) addStatic(aText string) *TNode {
+	lParent := self
+	for {
+		i := strings.IndexByte(lParent.indices, aText[0])
+		if i == -1 {
+			lNode := &TNode{Type: StaticNode, Text: aText}
+			lParent.addChild(lNode)
+			return lNode
+		}
+
+		lChild := lParent.Children[i]
+		l := commonPrefix(lChild.Text, aText)
+		if l < len(lChild.Text) {
+			// 拆分为公共前缀和剩余部分
+			lTail := &TNode{
+				Type:     StaticNode,
+				Text:     lChild.Text[l:],
+				Children: lChild.Children,
+				Path:     lChild.Path,
+				Route:    lChild.Route,
+				indices:  lChild.indices,
 			}
-			return n
+			lChild.Text = lChild.Text[:l]
+			lChild.Children = TSubNodes{lTail}
+			lChild.Path = ""
+			lChild.Route = nil
+			lChild.indices = lTail.Text[:1]
 		}
+
+		if l == len(aText) {
+			return lChild
+		}
+
+		lParent = lChild
+		aText = aText[l:]
 	}
+}
 
-	// 如果:该节点没有对应分支则插入同级的aNodes为新的分支
-	aParent.Children = append(aParent.Children, aNodes[i])
-	sort.Sort(aParent.Children)
-	return aNodes[i]
+// 添加子节点并按优先级排序
+func (self *TNode) addChild(aNode *TNode) {
+	self.Children = append(self.Children, aNode)
+	sort.Stable(self.Children)
+	self.updateIndices()
+}
+
+// 更新静态子节点的首字母索引
+// 排序后静态子节点总是排在前面
+func (self *TNode) updateIndices() {
+	lIndices := make([]byte, 0, len(self.Children))
+	for _, c := range self.Children {
+		if c.Type != StaticNode {
+			break
+		}
+		lIndices = append(lIndices, c.Text[0])
+	}
+	self.indices = string(lIndices)
 }
 
 // add nodes to trees
-func (self *TTree) addnodes(aMethod string, aNodes []*TNode, aIsHook bool) {
-	//fmt.Println("self.Root", self.Root)
+// 返回最后一个节点
+func (self *TTree) addnodes(aMethod string, aNodes []*TNode) *TNode {
 	// 获得对应方法[POST,GET...]
 	cn := self.Root[aMethod]
 	if cn == nil {
-
 		// 初始化Root node
 		cn = &TNode{
 			Children: TSubNodes{},
@@ -644,12 +705,59 @@ func (self *TTree) addnodes(aMethod string, aNodes []*TNode, aIsHook bool) {
 		self.Root[aMethod] = cn
 	}
 
-	var p *TNode = cn // 复制方法对应的Root
+	var (
+		p    = cn // 复制方法对应的Root
+		text string
+	)
+
+	// 层级插入Nodes的Node到Root 连续的静态节点合并后插入
+	for idx, n := range aNodes {
+		if n.Type == StaticNode {
+			text += n.Text
+			if idx < len(aNodes)-1 && aNodes[idx+1].Type == StaticNode {
+				continue
+			}
+
+			if text != "" {
+				p = p.addStatic(text)
+			}
+			text = ""
+			continue
+		}
+
+		p = p.addDynamic(n)
+	}
+
+	return p
+}
 
-	// 层级插入Nodes的Node到Root
-	for idx, _ := range aNodes {
-		p = cn.addnode(p, aNodes, idx, aIsHook)
+// 添加动态节点到节点
+func (self *TNode) addDynamic(aNode *TNode) *TNode {
+	// 如果:找到[已经注册]的分支节点则从该节继续[查找/添加]下一个节点
+	for _, n := range self.Children {
+		if n.Equal(aNode) {
+			return n
+		}
 	}
+
+	// 如果:该节点没有对应分支则插入为新的分支
+	lNode := &TNode{
+		Type:        aNode.Type,
+		ContentType: aNode.ContentType,
+		Text:        aNode.Text,
+		Level:       aNode.Level,
+		regexp:      aNode.regexp,
+	}
+	self.addChild(lNode)
+	return lNode
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
 }
 
 func printNode(i int, node *TNode) {
@@ -686,8 +794,12 @@ func (self *TTree) PrintTrees() {
 }
 
 func (self *TNode) Equal(o *TNode) bool {
-	if self.Type != o.Type || self.Text != o.Text {
+	if self.Type != o.Type || self.Text != o.Text || self.ContentType != o.ContentType {
 		return false
 	}
+
+	if self.Type == RegexpNode {
+		return self.regexp.String() == o.regexp.String()
+	}
 	return true
 }
@@ -49,3 +49,138 @@ func TestTree(t *testing.T) {
 	fmt.Println("/adffabc1ab/c4abc1abc1", r.Path, p)
 
 }
+
+func TestTreeMatch(t *testing.T) {
+	tree := NewRouteTree()
+	routes := []string{
+		"/web/content/(string:xmlid)",
+		"/web/content/(int:id)",
+		"/web/content/(int:id)/(:filename)",
+		"/web/content/(int:id)-(string:unique)",
+		"/web/content/(int:id)-(string:unique)/(:filename)",
+		"/web/image",
+		"/web/images/(:name)",
+		"/web/(:model)/list",
+		"/api/(:id[0-9]+)",
+		"/files/(*path)",
+		"/files/(*path).zip",
+		"/",
+	}
+	for _, path := range routes {
+		tree.AddRoute("GET", path, &TRoute{Path: path})
+	}
+
+	cases := []struct {
+		url    string
+		path   string
+		params map[string]string
+	}{
+		{"/", "/", nil},
+		{"/web/content/abc", "/web/content/(string:xmlid)", map[string]string{"xmlid": "abc"}},
+		{"/web/content/36", "/web/content/(int:id)", map[string]string{"id": "36"}},
+		{"/web/content/36/a.css", "/web/content/(int:id)/(:filename)", map[string]string{"id": "36", "filename": "a.css"}},
+		{"/web/content/36-abc", "/web/content/(int:id)-(string:unique)", map[string]string{"id": "36", "unique": "abc"}},
+		{"/web/content/36-abc/a.css", "/web/content/(int:id)-(string:unique)/(:filename)", map[string]string{"id": "36", "unique": "abc", "filename": "a.css"}},
+		{"/web/image", "/web/image", nil},
+		{"/web/images/logo", "/web/images/(:name)", map[string]string{"name": "logo"}},
+		{"/web/sale/list", "/web/(:model)/list", map[string]string{"model": "sale"}},
+		{"/api/123", "/api/(:id[0-9]+)", map[string]string{"id": "123"}},
+		{"/files/a/b/c.txt", "/files/(*path)", map[string]string{"path": "a/b/c.txt"}},
+		{"/files/a/b.zip", "/files/(*path).zip", map[string]string{"path": "a/b"}},
+		{"/web/content/36-1", "", nil},
+		{"/api/12a", "", nil},
+		{"/web/images/", "", nil},
+		{"/web/imag", "", nil},
+		{"/nothing", "", nil},
+	}
+
+	for _, c := range cases {
+		r, p := tree.Match("GET", c.url)
+		if c.path == "" {
+			if r != nil {
+				t.Errorf("%s: expect no route but got %s", c.url, r.Path)
+			}
+			continue
+		}
+
+		if r == nil {
+			t.Errorf("%s: expect %s but got nil", c.url, c.path)
+			continue
+		}
+		if r.Path != c.path {
+			t.Errorf("%s: expect %s but got %s", c.url, c.path, r.Path)
+		}
+		if len(p) != len(c.params) {
+			t.Errorf("%s: expect params %v but got %v", c.url, c.params, p)
+		}
+		for k, v := range c.params {
+			if p.Get(k) != v {
+				t.Errorf("%s: expect param %s=%s but got %s", c.url, k, v, p.Get(k))
+			}
+		}
+	}
+
+	if r, _ := tree.Match("POST", "/"); r != nil {
+		t.Errorf("expect no route for unregistered method")
+	}
+}
+
+// 静态路由压缩后仍可互相拆分和合并
+func TestTreeRadix(t *testing.T) {
+	tree := NewRouteTree()
+	tree2 := NewRouteTree()
+	paths := []string{"/search", "/support", "/blog/(:post)", "/blog", "/about-us", "/about-us/team", "/contact", "/s"}
+	for i, path := range paths {
+		if i%2 == 0 {
+			tree.AddRoute("GET", path, &TRoute{Path: path})
+		} else {
+			tree2.AddRoute("GET", path, &TRoute{Path: path})
+		}
+	}
+	tree.Conbine(tree2)
+
+	for _, path := range paths {
+		if path == "/blog/(:post)" {
+			continue
+		}
+		if r, _ := tree.Match("GET", path); r == nil || r.Path != path {
+			t.Errorf("%s: not matched after conbine", path)
+		}
+	}
+
+	if len(tree.Root["GET"].Children) != 1 {
+		t.Errorf("expect static children to share the / prefix")
+	}
+}
+
+// 生成大量路由模拟ERP注册规模
+func benchTree(n int) *TTree {
+	tree := NewRouteTree()
+	for i := 0; i < n; i++ {
+		tree.AddRoute("GET", fmt.Sprintf("/erp/model%d/list", i), new(TRoute))
+		tree.AddRoute("GET", fmt.Sprintf("/erp/model%d/(int:id)", i), new(TRoute))
+		tree.AddRoute("GET", fmt.Sprintf("/erp/model%d/(int:id)/(:field)", i), new(TRoute))
+	}
+	tree.AddRoute("GET", "/web/content/(int:id)-(string:unique)/(:filename)", new(TRoute))
+	tree.AddRoute("GET", "/static/(*filepath)", new(TRoute))
+	return tree
+}
+
+func benchMatch(b *testing.B, n int, url string) {
+	tree := benchTree(n)
+	params := make(Params, 0, 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if tree.Lookup("GET", url, &params) == nil {
+			b.Fatalf("%s not matched", url)
+		}
+	}
+}
+
+func BenchmarkMatchStatic10(b *testing.B)     { benchMatch(b, 10, "/erp/model9/list") }
+func BenchmarkMatchStatic1000(b *testing.B)   { benchMatch(b, 1000, "/erp/model999/list") }
+func BenchmarkMatchParam10(b *testing.B)      { benchMatch(b, 10, "/erp/model9/42/name") }
+func BenchmarkMatchParam1000(b *testing.B)    { benchMatch(b, 1000, "/erp/model999/42/name") }
+func BenchmarkMatchMixed1000(b *testing.B)    { benchMatch(b, 1000, "/web/content/36-abc/site.css") }
+func BenchmarkMatchCatchAll1000(b *testing.B) { benchMatch(b, 1000, "/static/lib/js/base.js") }
@@ -0,0 +1,362 @@
+package web
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+	upload 负责文件上传
+	@限制 Router.UploadLimit 为默认限制 route.SetUploadLimit 为单个路由设置限制
+	@类型 按文件内容嗅探MIME类型 不信任客户端提供的Content-Type
+	@存储 key 为随机文件名 保留客户端扩展名 但去掉 .html/.svg/.js 等可执行内容的扩展名
+
+	// 解析整个表单后取得文件
+	lFiles, err := hd.Files("avatar")
+	lKey, err := lFiles[0].Save(hd.Router.UploadStorage)
+
+	// 边读边存 文件不经过临时文件
+	lFiles, err := hd.SaveFiles("attachment")
+*/
+
+const (
+	DEFAULT_MAX_UPLOAD_SIZE = 32 << 20 // 默认请求体最大字节数
+	MULTIPART_MEMORY        = 1 << 20  // 解析表单时保存在内存中的最大字节数 超出部分写入临时文件
+)
+
+var (
+	ErrUploadTooLarge = errors.New("upload too large")
+	ErrUploadType     = errors.New("upload type not allowed")
+
+	// 浏览器可能作为页面或脚本执行的扩展名 不保留在存储key中
+	uploadActiveExts = map[string]bool{
+		".html": true, ".htm": true, ".shtml": true, ".xhtml": true, ".xht": true,
+		".svg": true, ".svgz": true, ".xml": true, ".xsl": true, ".xslt": true,
+		".js": true, ".mjs": true, ".swf": true,
+	}
+)
+
+type (
+	// 上传限制
+	TUploadLimit struct {
+		MaxSize     int64    // 请求体最大字节数 0 使用默认值
+		MaxFileSize int64    // 单个文件最大字节数 0 不限制
+		Types       []string // 允许的MIME类型 支持 image/* 为空时不限制
+	}
+
+	// 上传的文件
+	TUploadFile struct {
+		Field    string // 表单字段名
+		Name     string // 客户端文件名 不含路径
+		Size     int64
+		MimeType string // 嗅探得到的MIME类型
+		Key      string // 保存后的存储key
+
+		header  *multipart.FileHeader
+		storage IUploadStorage
+	}
+
+	// 超出大小时返回 ErrUploadTooLarge
+	tLimitReader struct {
+		reader io.Reader
+		remain int64
+	}
+)
+
+// 设置路由的上传限制 需在注册模块前设置
+func (self *TRoute) SetUploadLimit(aLimit TUploadLimit) *TRoute {
+	self.upload = &aLimit
+	return self
+}
+
+// 打开文件内容
+func (self *TUploadFile) Open() (io.ReadCloser, error) {
+	if self.header != nil {
+		return self.header.Open()
+	}
+
+	if self.storage != nil && self.Key != "" {
+		return self.storage.Open(self.Key)
+	}
+
+	return nil, errors.New("upload file has no content")
+}
+
+// 保存到存储 返回存储key
+func (self *TUploadFile) Save(aStorage IUploadStorage) (string, error) {
+	f, err := self.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	lKey := newUploadKey(self.Name)
+	if _, err = aStorage.Save(lKey, f); err != nil {
+		return "", err
+	}
+
+	self.Key = lKey
+	self.storage = aStorage
+	return lKey, nil
+}
+
+// 获得当前路由的上传限制
+func (self *THandler) uploadLimit() TUploadLimit {
+	var lLimit TUploadLimit
+	if self.Route != nil && self.Route.settings().upload != nil {
+		lLimit = *self.Route.settings().upload
+	} else if self.Router != nil {
+		lLimit = self.Router.UploadLimit
+	}
+
+	if lLimit.MaxSize <= 0 {
+		lLimit.MaxSize = DEFAULT_MAX_UPLOAD_SIZE
+	}
+	return lLimit
+}
+
+// 在上传限制内解析multipart表单
+func (self *THandler) parseMultipartForm() error {
+	if self.Request.MultipartForm != nil {
+		return nil
+	}
+
+	self.Request.Body = http.MaxBytesReader(self.Response, self.Request.Body, self.uploadLimit().MaxSize)
+	return uploadError(self.Request.ParseMultipartForm(MULTIPART_MEMORY))
+}
+
+// 获得表单中name字段的所有文件
+func (self *THandler) Files(name string) ([]*TUploadFile, error) {
+	if err := self.parseMultipartForm(); err != nil {
+		return nil, err
+	}
+
+	lLimit := self.uploadLimit()
+	var lFiles []*TUploadFile
+	for _, lHeader := range self.Request.MultipartForm.File[name] {
+		if lLimit.MaxFileSize > 0 && lHeader.Size > lLimit.MaxFileSize {
+			return nil, fmt.Errorf("%w: %s", ErrUploadTooLarge, lHeader.Filename)
+		}
+
+		f, err := lHeader.Open()
+		if err != nil {
+			return nil, err
+		}
+		lHead := make([]byte, 512)
+		n, err := io.ReadFull(f, lHead)
+		f.Close()
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+
+		lType := sniffType(lHead[:n])
+		if !allowedType(lLimit.Types, lType) {
+			return nil, fmt.Errorf("%w: %s", ErrUploadType, lType)
+		}
+
+		lFiles = append(lFiles, &TUploadFile{
+			Field:    name,
+			Name:     uploadFileName(lHeader.Filename),
+			Size:     lHeader.Size,
+			MimeType: lType,
+			header:   lHeader,
+		})
+	}
+
+	return lFiles, nil
+}
+
+// 边读边保存表单中name字段的所有文件 storage 为空时使用 Router.UploadStorage
+// 任何文件失败时删除本次已保存的文件 表单中的普通字段可继续通过 MethodParams 获得
+func (self *THandler) SaveFiles(name string, storage ...IUploadStorage) ([]*TUploadFile, error) {
+	var lStorage IUploadStorage
+	if len(storage) > 0 && storage[0] != nil {
+		lStorage = storage[0]
+	} else if self.Router != nil {
+		lStorage = self.Router.UploadStorage
+	}
+	if lStorage == nil {
+		return nil, errors.New("no upload storage")
+	}
+
+	lLimit := self.uploadLimit()
+	self.Request.Body = http.MaxBytesReader(self.Response, self.Request.Body, lLimit.MaxSize)
+	lReader, err := self.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	var lFiles []*TUploadFile
+	lValues := url.Values{}
+	for {
+		lPart, err := lReader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			switch {
+			case lPart.FileName() == "":
+				var lValue []byte
+				if lValue, err = io.ReadAll(lPart); err == nil {
+					lValues.Add(lPart.FormName(), string(lValue))
+				}
+			case lPart.FormName() != name:
+				_, err = io.Copy(io.Discard, lPart)
+			default:
+				var lFile *TUploadFile
+				if lFile, err = saveUploadPart(lPart, lLimit, lStorage); err == nil {
+					lFiles = append(lFiles, lFile)
+				}
+			}
+			lPart.Close()
+		}
+
+		if err != nil {
+			for _, lFile := range lFiles {
+				lStorage.Delete(lFile.Key)
+			}
+			return nil, uploadError(err)
+		}
+	}
+
+	// # 普通字段合并到Form 供MethodParams使用
+	if self.Request.Form == nil {
+		self.Request.Form = self.Request.URL.Query()
+	}
+	if self.Request.PostForm == nil {
+		self.Request.PostForm = url.Values{}
+	}
+	for key, lVals := range lValues {
+		self.Request.Form[key] = append(self.Request.Form[key], lVals...)
+		self.Request.PostForm[key] = append(self.Request.PostForm[key], lVals...)
+	}
+
+	return lFiles, nil
+}
+
+func saveUploadPart(aPart *multipart.Part, aLimit TUploadLimit, aStorage IUploadStorage) (*TUploadFile, error) {
+	lHead := make([]byte, 512)
+	n, err := io.ReadFull(aPart, lHead)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	lType := sniffType(lHead[:n])
+	if !allowedType(aLimit.Types, lType) {
+		return nil, fmt.Errorf("%w: %s", ErrUploadType, lType)
+	}
+
+	var lReader io.Reader = io.MultiReader(bytes.NewReader(lHead[:n]), aPart)
+	if aLimit.MaxFileSize > 0 {
+		lReader = &tLimitReader{reader: lReader, remain: aLimit.MaxFileSize}
+	}
+
+	lName := uploadFileName(aPart.FileName())
+	lKey := newUploadKey(lName)
+	lSize, err := aStorage.Save(lKey, lReader)
+	if err != nil {
+		aStorage.Delete(lKey)
+		if errors.Is(err, ErrUploadTooLarge) {
+			return nil, fmt.Errorf("%w: %s", ErrUploadTooLarge, lName)
+		}
+		return nil, err
+	}
+
+	return &TUploadFile{
+		Field:    aPart.FormName(),
+		Name:     lName,
+		Size:     lSize,
+		MimeType: lType,
+		Key:      lKey,
+		storage:  aStorage,
+	}, nil
+}
+
+func (self *tLimitReader) Read(p []byte) (int, error) {
+	if self.remain < 0 {
+		return 0, ErrUploadTooLarge
+	}
+
+	// # 多读一个字节用于判断是否超出
+	if int64(len(p)) > self.remain+1 {
+		p = p[:self.remain+1]
+	}
+
+	n, err := self.reader.Read(p)
+	self.remain -= int64(n)
+	if self.remain < 0 {
+		return 0, ErrUploadTooLarge
+	}
+	return n, err
+}
+
+// 请求体超出限制的错误统一为 ErrUploadTooLarge
+func uploadError(err error) error {
+	var lMaxErr *http.MaxBytesError
+	if errors.As(err, &lMaxErr) {
+		return ErrUploadTooLarge
+	}
+	return err
+}
+
+// 嗅探内容的MIME类型 不含参数
+func sniffType(aHead []byte) string {
+	lType, _, err := mime.ParseMediaType(http.DetectContentType(aHead))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return lType
+}
+
+// MIME类型是否被允许 支持 image/* 形式
+func allowedType(aTypes []string, aType string) bool {
+	if len(aTypes) == 0 {
+		return true
+	}
+
+	for _, t := range aTypes {
+		if strings.EqualFold(t, aType) || t == "*/*" ||
+			(strings.HasSuffix(t, "/*") && strings.HasPrefix(strings.ToLower(aType), strings.ToLower(t[:len(t)-1]))) {
+			return true
+		}
+	}
+	return false
+}
+
+// 去掉客户端文件名中的路径
+func uploadFileName(aName string) string {
+	aName = aName[strings.LastIndexAny(aName, `/\`)+1:]
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, aName)
+}
+
+// 生成存储key 日期目录+随机文件名+原扩展名
+// 浏览器会执行的扩展名被去掉 存储被公开时也不会以HTML/脚本响应
+func newUploadKey(aName string) string {
+	lRand := make([]byte, 8)
+	rand.Read(lRand)
+
+	lExt := strings.ToLower(filepath.Ext(aName))
+	if len(lExt) > 16 || uploadActiveExts[lExt] || strings.IndexFunc(strings.TrimPrefix(lExt, "."), func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+	}) >= 0 {
+		lExt = ""
+	}
+
+	return time.Now().Format("2006/01/02") + "/" + hex.EncodeToString(lRand) + lExt
+}
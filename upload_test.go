@@ -0,0 +1,179 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func multipartBody(t *testing.T, values map[string]string, files map[string][]byte) (io.Reader, string) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for k, v := range values {
+		w.WriteField(k, v)
+	}
+	for name, data := range files {
+		field, filename, _ := strings.Cut(name, ":")
+		fw, err := w.CreateFormFile(field, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	w.Close()
+	return &buf, w.FormDataContentType()
+}
+
+func TestUploadFiles(t *testing.T) {
+	var files []*TUploadFile
+	var uploadErr error
+	m := NewModule(nil, "m")
+	m.Post("/avatar", func(hd *THandler) {
+		files, uploadErr = hd.Files("avatar")
+	}).SetUploadLimit(TUploadLimit{MaxFileSize: 64, Types: []string{"image/*"}})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	cases := []struct {
+		name  string
+		files map[string][]byte
+		err   error
+		count int
+	}{
+		{"image", map[string][]byte{"avatar:../../me.png": testPNG}, nil, 1},
+		{"other field", map[string][]byte{"photo:me.png": testPNG}, nil, 0},
+		{"type by content", map[string][]byte{"avatar:me.png": []byte("plain text")}, ErrUploadType, 0},
+		{"too large", map[string][]byte{"avatar:me.png": append(testPNG, make([]byte, 64)...)}, ErrUploadTooLarge, 0},
+	}
+	for _, c := range cases {
+		files, uploadErr = nil, nil
+		body, ct := multipartBody(t, nil, c.files)
+		req := httptest.NewRequest("POST", "/avatar", body)
+		req.Header.Set("Content-Type", ct)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		if !errors.Is(uploadErr, c.err) || len(files) != c.count {
+			t.Errorf("%s: expect %v %d files but got %v %d", c.name, c.err, c.count, uploadErr, len(files))
+		}
+	}
+
+	// 保存
+	files, uploadErr = nil, nil
+	body, ct := multipartBody(t, nil, map[string][]byte{"avatar:../../me.png": testPNG})
+	req := httptest.NewRequest("POST", "/avatar", body)
+	req.Header.Set("Content-Type", ct)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if len(files) != 1 || files[0].Name != "me.png" || files[0].MimeType != "image/png" {
+		t.Fatalf("unexpected files %v %v", files, uploadErr)
+	}
+	storage := NewMemoryStorage()
+	key, err := files[0].Save(storage)
+	if err != nil || !strings.HasSuffix(key, ".png") {
+		t.Fatalf("unexpected key %q %v", key, err)
+	}
+	r, _ := storage.Open(key)
+	if data, _ := io.ReadAll(r); !bytes.Equal(data, testPNG) {
+		t.Errorf("unexpected saved data %q", data)
+	}
+}
+
+func TestUploadSaveFiles(t *testing.T) {
+	storage := NewMemoryStorage()
+	var files []*TUploadFile
+	var uploadErr error
+	var title string
+	m := NewModule(nil, "m")
+	m.Post("/attach", func(hd *THandler) {
+		files, uploadErr = hd.SaveFiles("file", storage)
+		title = hd.MethodParams().AsString("title")
+	}).SetUploadLimit(TUploadLimit{MaxSize: 4096, MaxFileSize: 100})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	post := func(files map[string][]byte) {
+		body, ct := multipartBody(t, map[string]string{"title": "report"}, files)
+		req := httptest.NewRequest("POST", "/attach", body)
+		req.Header.Set("Content-Type", ct)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	post(map[string][]byte{"file:a.txt": []byte("hello"), "other:b.txt": []byte("skip")})
+	if uploadErr != nil || len(files) != 1 || files[0].Size != 5 || files[0].MimeType != "text/plain" {
+		t.Fatalf("unexpected files %v %v", files, uploadErr)
+	}
+	if title != "report" {
+		t.Errorf("expect form value after streaming but got %q", title)
+	}
+	if keys := storage.Keys(); len(keys) != 1 || keys[0] != files[0].Key {
+		t.Errorf("unexpected stored keys %v", keys)
+	}
+
+	// 单个文件超出限制 已保存的文件被删除
+	storage.Delete(files[0].Key)
+	post(map[string][]byte{"file:a.txt": []byte("small"), "file:b.txt": bytes.Repeat([]byte("x"), 101)})
+	if !errors.Is(uploadErr, ErrUploadTooLarge) || len(storage.Keys()) != 0 {
+		t.Errorf("expect ErrUploadTooLarge and no stored files but got %v %v", uploadErr, storage.Keys())
+	}
+
+	// 请求体超出限制
+	post(map[string][]byte{"file:a.bin": bytes.Repeat([]byte("x"), 8192)})
+	if !errors.Is(uploadErr, ErrUploadTooLarge) {
+		t.Errorf("expect ErrUploadTooLarge for body but got %v", uploadErr)
+	}
+}
+
+func TestDiskStorage(t *testing.T) {
+	storage := NewDiskStorage(t.TempDir())
+	if n, err := storage.Save("2024/05/01/a.txt", strings.NewReader("abc")); err != nil || n != 3 {
+		t.Fatalf("save failed %d %v", n, err)
+	}
+	r, err := storage.Open("2024/05/01/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "abc" {
+		t.Errorf("unexpected data %q", data)
+	}
+	if err = storage.Delete("2024/05/01/a.txt"); err != nil {
+		t.Error(err)
+	}
+
+	for _, key := range []string{"", "../a.txt", "/etc/passwd", "a/../../b", `a\b`, "a/.hidden"} {
+		if _, err := storage.Save(key, strings.NewReader("x")); err != ErrInvalidStorageKey {
+			t.Errorf("expect invalid key error for %q but got %v", key, err)
+		}
+	}
+}
+
+func TestUploadKeyExt(t *testing.T) {
+	for name, ext := range map[string]string{
+		"a.png":      ".png",
+		"a.PDF":      ".pdf",
+		"x.html":     "",
+		"x.SVG":      "",
+		"x.js":       "",
+		"x.tar.gz":   ".gz",
+		"x.p h p":    "",
+		"noext":      "",
+		"x.png.html": "",
+	} {
+		if key := newUploadKey(name); path.Ext(key) != ext {
+			t.Errorf("%s: expect ext %q but got key %q", name, ext, key)
+		}
+	}
+
+	storage := NewRouter().UploadStorage.(*TDiskStorage)
+	if strings.Contains(filepath.ToSlash(storage.Root), "/"+STATIC_DIR+"/") {
+		t.Errorf("expect default upload storage outside the static root but got %s", storage.Root)
+	}
+}
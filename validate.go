@@ -0,0 +1,418 @@
+package web
+
+import (
+	"errors"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+/*
+	validate 负责按结构体标签验证数据
+	@规则 多个规则用,分隔 pattern 必须是最后一个规则(正则中可包含,)
+	required        必填 非零值
+	min=3 max=20    数字比较数值 字符串比较字符数 切片/Map比较长度
+	len=11          字符数/长度
+	oneof=a b c     取值之一 以空格分隔
+	email           邮件地址
+	pattern=^\\d+$  正则 标签中的\需写为\\
+	omitempty       零值时不验证其他规则
+	@空字符串和nil指针只验证required 数字和bool的零值照常验证 如 min=1 不接受0
+
+	type TUser struct {
+		Name  string `form:"name" validate:"required,max=20" label:"用户名"`
+		Phone string `form:"phone" validate:"pattern=^1\\d{10}$"`
+	}
+
+	@消息 规则的消息模板和label都经过 TI18n 翻译 {field} {param} 在翻译后替换
+	@自定义 RegisterValidateRule("even", "{field} must be even", func(v reflect.Value, param string) bool {...})
+*/
+
+type (
+	// 验证规则 aValue 为已解引用的字段值 aParam 为=后的参数
+	TValidateRule func(aValue reflect.Value, aParam string) bool
+
+	// 字段验证错误
+	TValidationError struct {
+		Field   string `json:"field"`
+		Rule    string `json:"rule"`
+		Param   string `json:"param,omitempty"`
+		Message string `json:"message"`
+	}
+
+	// 验证错误 字段路径:第一个失败的规则
+	TValidationErrors map[string]*TValidationError
+
+	tValidateRule struct {
+		fn      TValidateRule
+		message string
+	}
+
+	tValidateField struct {
+		index     int
+		name      string
+		label     string
+		rules     []tFieldRule
+		omitempty bool // 零值时不验证
+		nested    bool // 结构体/结构体切片需要递归验证
+		anonymous bool
+	}
+
+	tFieldRule struct {
+		name  string
+		param string
+	}
+)
+
+var (
+	validateRules = map[string]*tValidateRule{}
+	validateLock  sync.RWMutex
+	validateCache sync.Map // reflect.Type:[]*tValidateField
+	patternCache  sync.Map // string:*regexp.Regexp
+)
+
+func init() {
+	RegisterValidateRule("required", "{field} is required", func(v reflect.Value, p string) bool {
+		return v.IsValid() && !v.IsZero()
+	})
+	RegisterValidateRule("min", "{field} must be at least {param}", func(v reflect.Value, p string) bool {
+		c, ok := compareSize(v, p)
+		return ok && c >= 0
+	})
+	RegisterValidateRule("max", "{field} must be at most {param}", func(v reflect.Value, p string) bool {
+		c, ok := compareSize(v, p)
+		return ok && c <= 0
+	})
+	RegisterValidateRule("len", "{field} must have length {param}", func(v reflect.Value, p string) bool {
+		c, ok := compareSize(v, p)
+		return ok && c == 0
+	})
+	RegisterValidateRule("oneof", "{field} must be one of {param}", func(v reflect.Value, p string) bool {
+		lValue := valueString(v)
+		for _, lOption := range strings.Fields(p) {
+			if lOption == lValue {
+				return true
+			}
+		}
+		return false
+	})
+	RegisterValidateRule("email", "{field} must be a valid email address", func(v reflect.Value, p string) bool {
+		lAddr, err := mail.ParseAddress(valueString(v))
+		return err == nil && lAddr.Name == "" && lAddr.Address == valueString(v)
+	})
+	RegisterValidateRule("pattern", "{field} has an invalid format", func(v reflect.Value, p string) bool {
+		return getPattern(p).MatchString(valueString(v))
+	})
+}
+
+// 注册验证规则 同名规则将被替换
+// message 为消息模板 可使用 {field} {param}
+func RegisterValidateRule(name, message string, rule TValidateRule) {
+	if name == "" || rule == nil {
+		logger.Panic("validate rule name and func must not be empty!")
+	}
+
+	validateLock.Lock()
+	validateRules[name] = &tValidateRule{fn: rule, message: message}
+	validateLock.Unlock()
+}
+
+func (self TValidationErrors) Error() string {
+	lFields := make([]string, 0, len(self))
+	for lField := range self {
+		lFields = append(lFields, lField)
+	}
+	sort.Strings(lFields)
+
+	lMsgs := make([]string, len(lFields))
+	for i, lField := range lFields {
+		lMsgs[i] = self[lField].Message
+	}
+	return strings.Join(lMsgs, "; ")
+}
+
+// 字段路径:消息 用于模板
+func (self TValidationErrors) Messages() map[string]string {
+	lMsgs := make(map[string]string, len(self))
+	for lField, lErr := range self {
+		lMsgs[lField] = lErr.Message
+	}
+	return lMsgs
+}
+
+// 验证结构体 translate 用于翻译消息模板和label
+// 验证通过返回nil 否则返回 TValidationErrors 目标不是结构体时返回error
+func Validate(obj interface{}, translate ...func(string) string) error {
+	lVal := reflect.ValueOf(obj)
+	for lVal.Kind() == reflect.Ptr && !lVal.IsNil() {
+		lVal = lVal.Elem()
+	}
+	if lVal.Kind() != reflect.Struct {
+		return errors.New("validate target must be a struct or non-nil pointer to struct")
+	}
+
+	lTrans := func(s string) string { return s }
+	if len(translate) > 0 && translate[0] != nil {
+		lTrans = translate[0]
+	}
+
+	lErrs := TValidationErrors{}
+	validateStruct(lVal, "", lTrans, lErrs)
+	if len(lErrs) > 0 {
+		return lErrs
+	}
+	return nil
+}
+
+// 使用Router的TI18n按请求语言验证
+func (self *THandler) Validate(obj interface{}) error {
+	if self.Router == nil || self.Router.I18n == nil {
+		return Validate(obj)
+	}
+
+	lLocale := self.locale()
+	return Validate(obj, func(s string) string {
+		return self.Router.I18n.Translate(s, lLocale)
+	})
+}
+
+// 绑定后验证
+func (self *THandler) BindValid(dst interface{}) error {
+	if err := self.Bind(dst); err != nil {
+		return err
+	}
+	return self.Validate(dst)
+}
+
+// 按 Accept-Language 选择语言目录中已有的语言 没有时使用TI18n当前语言
+func (self *THandler) locale() string {
+	lI18n := self.Router.I18n
+	lI18n.rmutex.RLock()
+	defer lI18n.rmutex.RUnlock()
+
+	for _, lPart := range strings.Split(self.Request.Header.Get("Accept-Language"), ",") {
+		lLang := strings.ToLower(strings.TrimSpace(strings.Split(lPart, ";")[0]))
+		if lLang == "" {
+			continue
+		}
+		if _, ok := lI18n.Locales[lLang]; ok {
+			return lLang
+		}
+		if i := strings.IndexByte(lLang, '-'); i > 0 {
+			if _, ok := lI18n.Locales[lLang[:i]]; ok {
+				return lLang[:i]
+			}
+		}
+	}
+
+	return lI18n.currentLocal
+}
+
+func validateStruct(aVal reflect.Value, aPath string, aTrans func(string) string, aErrs TValidationErrors) {
+	for _, lField := range getValidateFields(aVal.Type()) {
+		lVal := aVal.Field(lField.index)
+		lPath := lField.name
+		if aPath != "" {
+			lPath = aPath + "." + lField.name
+		}
+
+		lElem := lVal
+		for lElem.Kind() == reflect.Ptr {
+			if lElem.IsNil() {
+				lElem = reflect.Value{}
+				break
+			}
+			lElem = lElem.Elem()
+		}
+
+		// # 空字符串/nil指针/omitempty的零值 只验证required
+		lEmpty := !lElem.IsValid() || (lElem.Kind() == reflect.String && lElem.Len() == 0) || (lField.omitempty && lElem.IsZero())
+		for _, lRule := range lField.rules {
+			if lEmpty && lRule.name != "required" {
+				continue
+			}
+
+			validateLock.RLock()
+			lDef := validateRules[lRule.name]
+			validateLock.RUnlock()
+
+			if !lDef.fn(lElem, lRule.param) {
+				lLabel := lField.label
+				if lLabel == "" {
+					lLabel = lField.name
+				}
+				aErrs[lPath] = &TValidationError{
+					Field: lPath,
+					Rule:  lRule.name,
+					Param: lRule.param,
+					Message: strings.NewReplacer(
+						"{field}", aTrans(lLabel),
+						"{param}", lRule.param,
+					).Replace(aTrans(lDef.message)),
+				}
+				break
+			}
+		}
+
+		if !lField.nested || !lElem.IsValid() || aErrs[lPath] != nil {
+			continue
+		}
+
+		switch lElem.Kind() {
+		case reflect.Struct:
+			if lField.anonymous {
+				validateStruct(lElem, aPath, aTrans, aErrs) // 内嵌结构体的字段视为本结构体的字段
+			} else {
+				validateStruct(lElem, lPath, aTrans, aErrs)
+			}
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < lElem.Len(); i++ {
+				lItem := lElem.Index(i)
+				for lItem.Kind() == reflect.Ptr && !lItem.IsNil() {
+					lItem = lItem.Elem()
+				}
+				if lItem.Kind() == reflect.Struct {
+					validateStruct(lItem, lPath+"["+strconv.Itoa(i)+"]", aTrans, aErrs)
+				}
+			}
+		}
+	}
+}
+
+// 获得结构体的验证信息
+func getValidateFields(aType reflect.Type) []*tValidateField {
+	if v, ok := validateCache.Load(aType); ok {
+		return v.([]*tValidateField)
+	}
+
+	var lFields []*tValidateField
+	for i := 0; i < aType.NumField(); i++ {
+		lField := aType.Field(i)
+		if lField.PkgPath != "" && !(lField.Anonymous && lField.Type.Kind() == reflect.Struct) {
+			continue
+		}
+
+		lValidate := &tValidateField{
+			index:     i,
+			name:      lField.Name,
+			label:     lField.Tag.Get("label"),
+			anonymous: lField.Anonymous,
+		}
+		lValidate.rules, lValidate.omitempty = parseValidateTag(lField.Tag.Get("validate"))
+
+		lType := lField.Type
+		for lType.Kind() == reflect.Ptr || lType.Kind() == reflect.Slice || lType.Kind() == reflect.Array {
+			lType = lType.Elem()
+		}
+		lValidate.nested = lType.Kind() == reflect.Struct && lType != timeType
+		if len(lValidate.rules) > 0 || lValidate.nested {
+			lFields = append(lFields, lValidate)
+		}
+	}
+
+	validateCache.Store(aType, lFields)
+	return lFields
+}
+
+// 解析validate标签 未注册的规则会Panic
+func parseValidateTag(aTag string) (lRules []tFieldRule, lOmitEmpty bool) {
+	if aTag == "" || aTag == "-" {
+		return nil, false
+	}
+
+	for _, lPart := range strings.Split(aTag, ",") {
+		lName, lParam, _ := strings.Cut(strings.TrimSpace(lPart), "=")
+		if lName == "" {
+			continue
+		}
+		if lName == "omitempty" {
+			lOmitEmpty = true
+			continue
+		}
+		if lName == "pattern" {
+			// # 正则中可能包含, 取剩余全部内容
+			lParam = strings.SplitN(aTag, "pattern=", 2)[1]
+			getPattern(lParam)
+		}
+
+		validateLock.RLock()
+		_, ok := validateRules[lName]
+		validateLock.RUnlock()
+		if !ok {
+			logger.Panic("unknown validate rule %q in tag %q", lName, aTag)
+		}
+
+		lRules = append(lRules, tFieldRule{name: lName, param: lParam})
+		if lName == "pattern" {
+			break
+		}
+	}
+
+	return lRules, lOmitEmpty
+}
+
+func getPattern(aPattern string) *regexp.Regexp {
+	if v, ok := patternCache.Load(aPattern); ok {
+		return v.(*regexp.Regexp)
+	}
+
+	lRegexp, err := regexp.Compile(aPattern)
+	if err != nil {
+		logger.Panic("invalid validate pattern %q: %s", aPattern, err.Error())
+	}
+	patternCache.Store(aPattern, lRegexp)
+	return lRegexp
+}
+
+// 比较值的大小或长度与参数 返回-1/0/1
+func compareSize(aVal reflect.Value, aParam string) (int, bool) {
+	var lSize float64
+	switch aVal.Kind() {
+	case reflect.String:
+		lSize = float64(utf8.RuneCountInString(aVal.String()))
+	case reflect.Slice, reflect.Map, reflect.Array:
+		lSize = float64(aVal.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		lSize = float64(aVal.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		lSize = float64(aVal.Uint())
+	case reflect.Float32, reflect.Float64:
+		lSize = aVal.Float()
+	default:
+		return 0, false
+	}
+
+	lParam, err := strconv.ParseFloat(aParam, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	switch {
+	case lSize < lParam:
+		return -1, true
+	case lSize > lParam:
+		return 1, true
+	}
+	return 0, true
+}
+
+func valueString(aVal reflect.Value) string {
+	switch aVal.Kind() {
+	case reflect.String:
+		return aVal.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(aVal.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(aVal.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(aVal.Float(), 'f', -1, 64)
+	case reflect.Bool:
+		return strconv.FormatBool(aVal.Bool())
+	}
+	return ""
+}
@@ -0,0 +1,210 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testValidateItem struct {
+	Sku string `validate:"required"`
+	Qty int    `validate:"min=1"`
+}
+
+type testValidateOrder struct {
+	testValidateItem
+	Name   string              `form:"name" validate:"required,max=5" label:"Name"`
+	Code   string              `validate:"len=3"`
+	Age    int                 `validate:"min=18,max=60"`
+	Status string              `validate:"oneof=draft done"`
+	Email  string              `validate:"email"`
+	Phone  string              `validate:"pattern=^1\\d{2,3}$"`
+	Note   *string             `validate:"required"`
+	Tags   []string            `validate:"max=2"`
+	Even   int                 `validate:"even"`
+	Grade  int                 `validate:"oneof=1 2 3"`
+	Level  int                 `validate:"omitempty,min=2"`
+	Items  []*testValidateItem `validate:"required,max=2"`
+	Addr   *testBindAddr
+}
+
+func init() {
+	RegisterValidateRule("even", "{field} must be even", func(v reflect.Value, param string) bool {
+		return v.Int()%2 == 0
+	})
+}
+
+func TestValidate(t *testing.T) {
+	note := "n"
+	valid := testValidateOrder{
+		testValidateItem: testValidateItem{Sku: "s", Qty: 1},
+		Name:             "Tom",
+		Age:              20,
+		Grade:            1,
+		Note:             &note,
+		Items:            []*testValidateItem{{Sku: "a", Qty: 2}},
+	}
+	if err := Validate(&valid); err != nil {
+		t.Fatalf("expect valid but got %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		edit  func(*testValidateOrder)
+		field string
+		rule  string
+	}{
+		{"required", func(o *testValidateOrder) { o.Name = "" }, "Name", "required"},
+		{"max length", func(o *testValidateOrder) { o.Name = "张三李四王五" }, "Name", "max"},
+		{"len", func(o *testValidateOrder) { o.Code = "ab" }, "Code", "len"},
+		{"min", func(o *testValidateOrder) { o.Age = 17 }, "Age", "min"},
+		{"max", func(o *testValidateOrder) { o.Age = 61 }, "Age", "max"},
+		{"oneof", func(o *testValidateOrder) { o.Status = "open" }, "Status", "oneof"},
+		{"email", func(o *testValidateOrder) { o.Email = "a@" }, "Email", "email"},
+		{"pattern", func(o *testValidateOrder) { o.Phone = "2123" }, "Phone", "pattern"},
+		{"nil pointer", func(o *testValidateOrder) { o.Note = nil }, "Note", "required"},
+		{"slice max", func(o *testValidateOrder) { o.Tags = []string{"a", "b", "c"} }, "Tags", "max"},
+		{"custom", func(o *testValidateOrder) { o.Even = 3 }, "Even", "even"},
+		{"slice required", func(o *testValidateOrder) { o.Items = nil }, "Items", "required"},
+		{"nested slice", func(o *testValidateOrder) { o.Items = append(o.Items, &testValidateItem{Qty: 1}) }, "Items[1].Sku", "required"},
+		{"embedded", func(o *testValidateOrder) { o.Qty = -1 }, "Qty", "min"},
+		{"zero min", func(o *testValidateOrder) { o.Qty = 0 }, "Qty", "min"},
+		{"zero oneof", func(o *testValidateOrder) { o.Grade = 0 }, "Grade", "oneof"},
+		{"omitempty", func(o *testValidateOrder) { o.Level = 1 }, "Level", "min"},
+	}
+	for _, c := range cases {
+		order := valid
+		c.edit(&order)
+
+		var errs TValidationErrors
+		if err := Validate(&order); !errors.As(err, &errs) {
+			t.Errorf("%s: expect TValidationErrors but got %v", c.name, err)
+			continue
+		}
+		if len(errs) != 1 || errs[c.field] == nil || errs[c.field].Rule != c.rule {
+			t.Errorf("%s: expect %s %s but got %v", c.name, c.field, c.rule, errs)
+		}
+	}
+
+	// 空值只验证required
+	order := valid
+	order.Email, order.Phone, order.Status = "", "", ""
+	if err := Validate(order); err != nil {
+		t.Errorf("expect empty optional fields valid but got %v", err)
+	}
+
+	// 不是结构体时返回error 而不是Panic
+	var nilOrder *testValidateOrder
+	for _, obj := range []interface{}{nil, 1, nilOrder} {
+		var errs TValidationErrors
+		if err := Validate(obj); err == nil || errors.As(err, &errs) {
+			t.Errorf("expect target error for %T but got %v", obj, err)
+		}
+	}
+
+	// 可转为JSON
+	order.Name = ""
+	data, _ := json.Marshal(Validate(order))
+	if string(data) != `{"Name":{"field":"Name","rule":"required","message":"Name is required"}}` {
+		t.Errorf("unexpected json %s", data)
+	}
+}
+
+func TestHandlerValidate(t *testing.T) {
+	var validateErr error
+	m := NewModule(nil, "m")
+	m.Post("/order", func(hd *THandler) {
+		var order struct {
+			Name string `form:"name" validate:"required,max=3" label:"name"`
+		}
+		validateErr = hd.BindValid(&order)
+	})
+	router := NewRouter()
+	router.I18n = NewI18n("test")
+	router.I18n.Locales["zh"] = map[string]string{
+		"{field} is required":             "{field}不能为空",
+		"{field} must be at most {param}": "{field}不能超过{param}",
+		"name":                            "名称",
+	}
+	router.RegisterModule(m)
+
+	post := func(body, lang string) map[string]string {
+		req := httptest.NewRequest("POST", "/order", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept-Language", lang)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		var errs TValidationErrors
+		if !errors.As(validateErr, &errs) {
+			return nil
+		}
+		return errs.Messages()
+	}
+
+	if msgs := post("name=", "zh-CN,zh;q=0.9"); msgs["Name"] != "名称不能为空" {
+		t.Errorf("unexpected zh message %v", msgs)
+	}
+	if msgs := post("name=abcd", "zh"); msgs["Name"] != "名称不能超过3" {
+		t.Errorf("unexpected zh message %v", msgs)
+	}
+	if msgs := post("name=", "fr"); msgs["Name"] != "name is required" {
+		t.Errorf("unexpected default message %v", msgs)
+	}
+	if msgs := post("name=abc", "zh"); msgs != nil {
+		t.Errorf("expect valid but got %v", msgs)
+	}
+}
+
+func TestI18nInit(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "zh-CN.json"), []byte(`{"{field} is required":"{field}不能为空","name":"名称"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "en.json"), []byte(`{}`), 0644)
+
+	srv := NewServer("i18n_test")
+	srv.Config.UseI18N = true
+	srv.Config.LocaleDir = dir
+	srv.Config.PrintRouterTree = false
+	srv.Post("/order", func(hd *THandler) {
+		var order struct {
+			Name string `form:"name" validate:"required" label:"name"`
+		}
+		if err := hd.BindValid(&order); err != nil {
+			hd.RespondWithError(err)
+		}
+	})
+	h := srv.Handler()
+
+	post := func(lang string) TProblem {
+		req := httptest.NewRequest("POST", "/order", strings.NewReader("name="))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept-Language", lang)
+		req.Header.Set("Accept", MIME_JSON)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		var problem TProblem
+		json.Unmarshal(w.Body.Bytes(), &problem)
+		return problem
+	}
+
+	if p := post("zh-CN,en;q=0.9"); p.Status != 422 || p.Errors["Name"] != "名称不能为空" {
+		t.Errorf("expect translated message but got %+v", p)
+	}
+	if p := post("fr"); p.Errors["Name"] != "name is required" {
+		t.Errorf("expect default message but got %+v", p)
+	}
+	if srv.Router.trans("name") != "name" || srv.Router.I18n.Translate("name", "zh-CN") != "名称" {
+		t.Errorf("unexpected trans %q", srv.Router.trans("name"))
+	}
+
+	// 默认语言与文件名大小写不同
+	i18n := NewI18n("test")
+	if err := i18n.Init(dir, "zh-CN"); err != nil || i18n.Translate("name") != "名称" {
+		t.Errorf("expect default language zh-CN but got %v %q", err, i18n.Translate("name"))
+	}
+}
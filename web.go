@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 
 	log "github.com/VectorsOrigin/logger"
 	"github.com/VectorsOrigin/template"
@@ -19,7 +20,6 @@ import (
 		├─module 应用模块目录
 		│  ├─web 模块目录
 		│  │  ├─static 静态资源目录
-		│  │  │   ├─uploads 上传根目录
 		│  │  │   ├─lib 资源库文件目录(常用作前端框架库)
 		│  │  │   └─src 资源文件
 		│  │  │      ├─js 资源Js文件目录
@@ -35,8 +35,9 @@ import (
 		│  │
 		│  └─... 扩展的可装卸功能模块或插件
 		│
+		├─uploads 上传根目录 不作为静态文件公开
+		│
 		├─static 静态资源目录
-		│  ├─uploads 上传根目录
 		│  ├─lib 资源库文件目录(常用作前端框架库)
 		│  └─src 资源文件
 		│     ├─js 资源Js文件目录
@@ -60,8 +61,11 @@ type (
 		Config   *TConfig               // 配置类
 		Router   *TRouter               // 路由类
 		Template *template.TTemplateSet // 模板类
+		Injector *TInjector             // 控制器参数的依赖注入
 		//Logger   *logger.TLogger        // 日志类
 		//debugMode bool
+
+		initOnce sync.Once // 保证路由只完成一次注册
 	}
 )
 
@@ -94,6 +98,7 @@ func NewServer(name ...string) *TServer {
 		//Logger:   logger.NewLogger(""),
 		Router:   NewRouter(),
 		Template: template.NewTemplateSet(),
+		Injector: NewInjector(),
 	}
 	// 初始化服务器资源路径为APP当前路径
 	srv.TModule.Path = ""     //utils.AppDir()
@@ -167,8 +172,7 @@ func (self *TServer) Listen(addr ...string) {
 	self.Config.Save()     // 保存文件
 
 	//注册主Route
-	self.Router.RegisterModule(self)
-	self.Router.Init()
+	lHandler := self.Handler()
 	// 阻塞监听
 	// 显示系统信息
 	new_addr := fmt.Sprintf("%s:%d", self.Config.Host, self.Config.Port)
@@ -178,19 +182,31 @@ func (self *TServer) Listen(addr ...string) {
 			logger.Panic("lost cert file or key file for TLS connection!")
 		}
 
-		err := http.ListenAndServeTLS(new_addr, self.Config.TLSCertFile, self.Config.TLSKeyFile, self.Router)
+		err := http.ListenAndServeTLS(new_addr, self.Config.TLSCertFile, self.Config.TLSKeyFile, lHandler)
 		if err != nil {
 			logger.Panic("start server faild : %s", err)
 		}
 
 	} else {
-		err := http.ListenAndServe(new_addr, self.Router)
+		err := http.ListenAndServe(new_addr, lHandler)
 		if err != nil {
 			logger.Panic("start server faild : %s", err)
 		}
 	}
 }
 
+// 完成服务器路由注册并返回可直接使用的http.Handler
+// 用于嵌入其他http.Server或httptest 无需调用Listen
+// 多次调用只注册一次,之后添加到服务器的路由需通过RegisterModule(srv)更新
+func (self *TServer) Handler() http.Handler {
+	self.initOnce.Do(func() {
+		self.Router.RegisterModule(self)
+		self.Router.Init()
+	})
+
+	return self.Router
+}
+
 // 废弃
 func (self *TServer) __ListenTLS(certFile, keyFile string, addr ...string) {
 	/*	//注册主Route
@@ -233,6 +249,19 @@ func (self *TServer) RegisterModule(obj IModule) {
 	self.Router.RegisterModule(obj)
 }
 
+func (self *TServer) UnregisterModule(obj IModule) bool {
+	return self.Router.UnregisterModule(obj)
+}
+
+func (self *TServer) UnregisterRoute(name string) bool {
+	return self.Router.UnregisterRoute(name)
+}
+
+// 设置静态文件的Cache-Control 详见 TRouter.SetCacheControl
+func (self *TServer) SetCacheControl(prefix, value string) {
+	self.Router.SetCacheControl(prefix, value)
+}
+
 // 注册中间件
 // 中间件可以使用在Conntroller，全局Object 上
 func (self *TServer) RegisterMiddleware(obj ...IMiddleware) {
@@ -245,6 +274,7 @@ func (self *TServer) LoadConfigFile(filepath string) {
 
 }
 
+// 废弃 注册于http.DefaultServeMux,路由器不会使用 请使用TModule.Static
 func SetStaticPath(url string, path string) {
 	//log.Print(path)
 	//log.Print(http.Dir(path))
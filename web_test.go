@@ -0,0 +1,37 @@
+package web
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServerHandler(t *testing.T) {
+	srv := NewServer("handler_test")
+	srv.Get("/hello", func(hd *THandler) {
+		hd.RespondString("hello " + hd.Router.Server.Name)
+	})
+
+	h := srv.Handler()
+	if h != srv.Handler() {
+		t.Fatalf("expect the same handler on each call")
+	}
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(body) != "hello handler_test" {
+		t.Errorf("expect 200 hello handler_test but got %d %s", resp.StatusCode, body)
+	}
+
+	// 注册只完成一次
+	if r, _ := srv.Router.getTree().Match("GET", "/hello"); r == nil || len(r.Ctrls) != 1 {
+		t.Errorf("expect a single controller on /hello")
+	}
+}
@@ -0,0 +1,510 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+	websocket 负责 RFC 6455 WebSocket 连接
+	@升级前照常执行路由的中间件和前置Hook 已响应(如未登录)时不升级
+	@Ping由服务端定时发送 收到的Ping自动回复Pong
+	@消息超过 MaxMessageSize 时以1009关闭连接
+
+	module.WebSocket("/doc/(:id)/live", func(conn *TConn) {
+		lId := conn.Handler.PathParams().AsString("id")
+		for {
+			lType, lData, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(lType, lData)
+		}
+	})
+*/
+
+const (
+	// 消息类型
+	WS_TEXT   = 1
+	WS_BINARY = 2
+	WS_CLOSE  = 8
+	WS_PING   = 9
+	WS_PONG   = 10
+
+	// 关闭代码
+	WS_CLOSE_NORMAL           = 1000
+	WS_CLOSE_GOING_AWAY       = 1001
+	WS_CLOSE_PROTOCOL_ERROR   = 1002
+	WS_CLOSE_UNSUPPORTED_DATA = 1003
+	WS_CLOSE_NO_STATUS        = 1005
+	WS_CLOSE_ABNORMAL         = 1006
+	WS_CLOSE_INVALID_PAYLOAD  = 1007
+	WS_CLOSE_POLICY_VIOLATION = 1008
+	WS_CLOSE_MESSAGE_TOO_BIG  = 1009
+	WS_CLOSE_INTERNAL_ERROR   = 1011
+
+	DEFAULT_WS_MAX_MESSAGE_SIZE = 1 << 20
+	DEFAULT_WS_PING_INTERVAL    = 30 * time.Second
+	DEFAULT_WS_WRITE_TIMEOUT    = 10 * time.Second
+
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsContinuation = 0
+	wsMaxControl   = 125
+)
+
+var ErrWebSocketClosed = errors.New("websocket closed")
+
+type (
+	// WebSocket 选项 零值使用默认值
+	TWebSocketOptions struct {
+		MaxMessageSize int64                    // 单个消息最大字节数
+		PingInterval   time.Duration            // 发送Ping的间隔 负数不发送 超过两个间隔未收到数据视为断开
+		WriteTimeout   time.Duration            // 单次写出超时
+		Subprotocols   []string                 // 支持的子协议 按偏好排序
+		CheckOrigin    func(*http.Request) bool // 为nil时只允许同源或没有Origin的请求
+	}
+
+	// WebSocket 连接
+	TConn struct {
+		Handler *THandler // 升级前的Handler 用于取得路由参数/会话等
+
+		conn        net.Conn
+		reader      *bufio.Reader
+		opts        TWebSocketOptions
+		subprotocol string
+
+		writeLock sync.Mutex
+		closeOnce sync.Once
+		closeSent bool
+		done      chan struct{}
+	}
+
+	// 对方关闭连接或连接因错误关闭
+	TCloseError struct {
+		Code int
+		Text string
+	}
+)
+
+// 添加WebSocket路由 握手前执行中间件和Hook handler返回后关闭连接
+func (self *TModule) WebSocket(url string, handler func(*TConn), opts ...TWebSocketOptions) *TRoute {
+	if handler == nil {
+		logger.Panic("the websocket handler must not be nil!")
+	}
+
+	var lOpts TWebSocketOptions
+	if len(opts) > 0 {
+		lOpts = opts[0]
+	}
+	if lOpts.MaxMessageSize <= 0 {
+		lOpts.MaxMessageSize = DEFAULT_WS_MAX_MESSAGE_SIZE
+	}
+	if lOpts.PingInterval == 0 {
+		lOpts.PingInterval = DEFAULT_WS_PING_INTERVAL
+	}
+	if lOpts.WriteTimeout <= 0 {
+		lOpts.WriteTimeout = DEFAULT_WS_WRITE_TIMEOUT
+	}
+
+	return self.url(CommomRoute, []string{"GET"}, url, func(hd *THandler) {
+		lConn, err := upgradeWebSocket(hd, lOpts)
+		if err != nil {
+			logger.Err("websocket upgrade faild: %s", err.Error())
+			return
+		}
+		defer lConn.Close(WS_CLOSE_NORMAL, "")
+
+		handler(lConn)
+	}, "", "", 0)
+}
+
+// 握手并接管连接 握手失败时已响应错误
+func upgradeWebSocket(hd *THandler, aOpts TWebSocketOptions) (*TConn, error) {
+	lReq := hd.Request
+	lFail := func(status int, msg string) (*TConn, error) {
+		hd.Abort(status, msg)
+		return nil, errors.New(msg)
+	}
+
+	if !headerContains(lReq.Header, "Connection", "upgrade") || !headerContains(lReq.Header, "Upgrade", "websocket") {
+		return lFail(http.StatusBadRequest, "websocket: not a websocket handshake")
+	}
+	if lReq.Header.Get("Sec-WebSocket-Version") != "13" {
+		hd.Header().Set("Sec-WebSocket-Version", "13")
+		return lFail(http.StatusUpgradeRequired, "websocket: unsupported version")
+	}
+	lKey := lReq.Header.Get("Sec-WebSocket-Key")
+	if lNonce, err := base64.StdEncoding.DecodeString(lKey); err != nil || len(lNonce) != 16 {
+		return lFail(http.StatusBadRequest, "websocket: invalid Sec-WebSocket-Key")
+	}
+
+	lCheckOrigin := aOpts.CheckOrigin
+	if lCheckOrigin == nil {
+		lCheckOrigin = sameOrigin
+	}
+	if !lCheckOrigin(lReq) {
+		return lFail(http.StatusForbidden, "websocket: origin not allowed")
+	}
+
+	lHijacker, ok := hd.Response.(http.Hijacker)
+	if !ok {
+		return lFail(http.StatusInternalServerError, "websocket: response does not support hijacking")
+	}
+
+	lProtocol := selectSubprotocol(lReq, aOpts.Subprotocols)
+
+	// # 101响应由此写出 接管前先执行写出响应头前的函数 如保存会话Cookie
+	if lResp, ok := hd.Response.(interface{ runBeforeWriteHeader() }); ok {
+		lResp.runBeforeWriteHeader()
+	}
+	lNetConn, lBuf, err := lHijacker.Hijack()
+	if err != nil {
+		return lFail(http.StatusInternalServerError, "websocket: "+err.Error())
+	}
+
+	// # 中间件设置的头(如Cookie)一并写出
+	lHeader := hd.Header()
+	lHeader.Set("Upgrade", "websocket")
+	lHeader.Set("Connection", "Upgrade")
+	lHeader.Set("Sec-WebSocket-Accept", websocketAccept(lKey))
+	if lProtocol != "" {
+		lHeader.Set("Sec-WebSocket-Protocol", lProtocol)
+	}
+
+	var lResp bytes.Buffer
+	lResp.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	lHeader.Write(&lResp)
+	lResp.WriteString("\r\n")
+
+	lNetConn.SetDeadline(time.Time{})
+	lNetConn.SetWriteDeadline(time.Now().Add(aOpts.WriteTimeout))
+	if _, err := lNetConn.Write(lResp.Bytes()); err != nil {
+		lNetConn.Close()
+		return nil, err
+	}
+
+	lConn := &TConn{
+		Handler:     hd,
+		conn:        lNetConn,
+		reader:      lBuf.Reader,
+		opts:        aOpts,
+		subprotocol: lProtocol,
+		done:        make(chan struct{}),
+	}
+	if aOpts.PingInterval > 0 {
+		go lConn.pingLoop()
+	}
+	return lConn, nil
+}
+
+func websocketAccept(aKey string) string {
+	h := sha1.New()
+	h.Write([]byte(aKey + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// 以逗号分隔的头中是否包含token 不区分大小写
+func headerContains(aHeader http.Header, aName, aToken string) bool {
+	for _, lValue := range aHeader.Values(aName) {
+		for _, lToken := range strings.Split(lValue, ",") {
+			if strings.EqualFold(strings.TrimSpace(lToken), aToken) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 没有Origin或Origin的Host与请求一致
+func sameOrigin(req *http.Request) bool {
+	lOrigin := req.Header.Get("Origin")
+	if lOrigin == "" {
+		return true
+	}
+
+	u, err := url.Parse(lOrigin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host)
+}
+
+// 按服务端偏好选择客户端请求的子协议
+func selectSubprotocol(req *http.Request, aSupported []string) string {
+	for _, lProtocol := range aSupported {
+		if headerContains(req.Header, "Sec-WebSocket-Protocol", lProtocol) {
+			return lProtocol
+		}
+	}
+	return ""
+}
+
+// 协商得到的子协议
+func (self *TConn) Subprotocol() string {
+	return self.subprotocol
+}
+
+func (self *TConn) RemoteAddr() net.Addr {
+	return self.conn.RemoteAddr()
+}
+
+// 读取一个完整的消息 控制帧在内部处理
+// 对方关闭时返回 *TCloseError
+func (self *TConn) ReadMessage() (int, []byte, error) {
+	var lType int
+	var lData []byte
+	for {
+		lFin, lOpcode, lPayload, err := self.readFrame()
+		if err != nil {
+			return 0, nil, self.fail(err)
+		}
+
+		switch lOpcode {
+		case WS_PING:
+			if err := self.writeFrame(WS_PONG, lPayload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case WS_PONG:
+			continue
+		case WS_CLOSE:
+			lErr := parseCloseFrame(lPayload)
+			lCode := lErr.Code
+			if lCode == WS_CLOSE_NO_STATUS {
+				lCode = WS_CLOSE_NORMAL
+			}
+			self.Close(lCode, "")
+			return 0, nil, lErr
+		case WS_TEXT, WS_BINARY:
+			if lType != 0 {
+				return 0, nil, self.fail(&TCloseError{WS_CLOSE_PROTOCOL_ERROR, "new message before the last one finished"})
+			}
+			lType = int(lOpcode)
+		case wsContinuation:
+			if lType == 0 {
+				return 0, nil, self.fail(&TCloseError{WS_CLOSE_PROTOCOL_ERROR, "unexpected continuation frame"})
+			}
+		default:
+			return 0, nil, self.fail(&TCloseError{WS_CLOSE_PROTOCOL_ERROR, fmt.Sprintf("unknown opcode %d", lOpcode)})
+		}
+
+		if int64(len(lData)+len(lPayload)) > self.opts.MaxMessageSize {
+			return 0, nil, self.fail(&TCloseError{WS_CLOSE_MESSAGE_TOO_BIG, "message too big"})
+		}
+		lData = append(lData, lPayload...)
+
+		if lFin {
+			if lType == WS_TEXT && !utf8.Valid(lData) {
+				return 0, nil, self.fail(&TCloseError{WS_CLOSE_INVALID_PAYLOAD, "invalid utf-8 text"})
+			}
+			return lType, lData, nil
+		}
+	}
+}
+
+// 读取一个JSON消息
+func (self *TConn) ReadJSON(v interface{}) error {
+	_, lData, err := self.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(lData, v)
+}
+
+// 写出一个消息 可并发调用
+func (self *TConn) WriteMessage(msgType int, data []byte) error {
+	if msgType != WS_TEXT && msgType != WS_BINARY {
+		return fmt.Errorf("websocket: invalid message type %d", msgType)
+	}
+	return self.writeFrame(byte(msgType), data)
+}
+
+func (self *TConn) WriteText(s string) error {
+	return self.writeFrame(WS_TEXT, []byte(s))
+}
+
+// 以文本消息写出JSON
+func (self *TConn) WriteJSON(v interface{}) error {
+	lData, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return self.writeFrame(WS_TEXT, lData)
+}
+
+func (self *TConn) Ping(data []byte) error {
+	return self.writeFrame(WS_PING, data)
+}
+
+// 发送关闭帧并关闭连接 可多次调用
+// 1005/1006 只用于表示状态 不发送关闭帧
+func (self *TConn) Close(code int, reason string) error {
+	var err error
+	self.closeOnce.Do(func() {
+		if code != WS_CLOSE_NO_STATUS && code != WS_CLOSE_ABNORMAL {
+			lPayload := make([]byte, 2, 2+len(reason))
+			binary.BigEndian.PutUint16(lPayload, uint16(code))
+			lPayload = append(lPayload, reason...)
+			if len(lPayload) > wsMaxControl {
+				lPayload = lPayload[:wsMaxControl]
+			}
+			err = self.writeFrame(WS_CLOSE, lPayload)
+		}
+
+		close(self.done)
+		if lErr := self.conn.Close(); err == nil {
+			err = lErr
+		}
+	})
+	return err
+}
+
+// 读取出错时关闭连接 协议错误时带上关闭代码
+func (self *TConn) fail(err error) error {
+	var lClose *TCloseError
+	if errors.As(err, &lClose) {
+		self.Close(lClose.Code, lClose.Text)
+		return err
+	}
+
+	self.Close(WS_CLOSE_ABNORMAL, "")
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return &TCloseError{Code: WS_CLOSE_ABNORMAL, Text: err.Error()}
+	}
+	return err
+}
+
+func (self *TConn) readFrame() (bool, byte, []byte, error) {
+	if self.opts.PingInterval > 0 {
+		self.conn.SetReadDeadline(time.Now().Add(2 * self.opts.PingInterval))
+	}
+
+	var lHead [2]byte
+	if _, err := io.ReadFull(self.reader, lHead[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	lFin := lHead[0]&0x80 != 0
+	lOpcode := lHead[0] & 0x0f
+	if lHead[0]&0x70 != 0 {
+		return false, 0, nil, &TCloseError{WS_CLOSE_PROTOCOL_ERROR, "reserved bits set"}
+	}
+	if lHead[1]&0x80 == 0 {
+		return false, 0, nil, &TCloseError{WS_CLOSE_PROTOCOL_ERROR, "client frame not masked"}
+	}
+
+	lLen := uint64(lHead[1] & 0x7f)
+	switch lLen {
+	case 126:
+		var lExt [2]byte
+		if _, err := io.ReadFull(self.reader, lExt[:]); err != nil {
+			return false, 0, nil, err
+		}
+		lLen = uint64(binary.BigEndian.Uint16(lExt[:]))
+	case 127:
+		var lExt [8]byte
+		if _, err := io.ReadFull(self.reader, lExt[:]); err != nil {
+			return false, 0, nil, err
+		}
+		lLen = binary.BigEndian.Uint64(lExt[:])
+	}
+
+	if lOpcode >= WS_CLOSE && (!lFin || lLen > wsMaxControl) {
+		return false, 0, nil, &TCloseError{WS_CLOSE_PROTOCOL_ERROR, "invalid control frame"}
+	}
+	if lLen > uint64(self.opts.MaxMessageSize) {
+		return false, 0, nil, &TCloseError{WS_CLOSE_MESSAGE_TOO_BIG, "message too big"}
+	}
+
+	var lMask [4]byte
+	if _, err := io.ReadFull(self.reader, lMask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	lPayload := make([]byte, lLen)
+	if _, err := io.ReadFull(self.reader, lPayload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range lPayload {
+		lPayload[i] ^= lMask[i%4]
+	}
+
+	return lFin, lOpcode, lPayload, nil
+}
+
+// 写出一个不分片的帧 服务端的帧不加掩码
+func (self *TConn) writeFrame(aOpcode byte, aPayload []byte) error {
+	self.writeLock.Lock()
+	defer self.writeLock.Unlock()
+
+	if self.closeSent {
+		return ErrWebSocketClosed
+	}
+	if aOpcode == WS_CLOSE {
+		self.closeSent = true
+	}
+
+	lFrame := make([]byte, 0, len(aPayload)+10)
+	lFrame = append(lFrame, 0x80|aOpcode)
+	switch lLen := len(aPayload); {
+	case lLen <= 125:
+		lFrame = append(lFrame, byte(lLen))
+	case lLen <= 0xffff:
+		lFrame = append(lFrame, 126, byte(lLen>>8), byte(lLen))
+	default:
+		var lExt [8]byte
+		binary.BigEndian.PutUint64(lExt[:], uint64(lLen))
+		lFrame = append(append(lFrame, 127), lExt[:]...)
+	}
+	lFrame = append(lFrame, aPayload...)
+
+	self.conn.SetWriteDeadline(time.Now().Add(self.opts.WriteTimeout))
+	_, err := self.conn.Write(lFrame)
+	return err
+}
+
+func (self *TConn) pingLoop() {
+	lTicker := time.NewTicker(self.opts.PingInterval)
+	defer lTicker.Stop()
+
+	for {
+		select {
+		case <-lTicker.C:
+			if self.Ping(nil) != nil {
+				return
+			}
+		case <-self.done:
+			return
+		}
+	}
+}
+
+func parseCloseFrame(aPayload []byte) *TCloseError {
+	if len(aPayload) < 2 {
+		return &TCloseError{Code: WS_CLOSE_NO_STATUS}
+	}
+	return &TCloseError{
+		Code: int(binary.BigEndian.Uint16(aPayload)),
+		Text: string(aPayload[2:]),
+	}
+}
+
+func (self *TCloseError) Error() string {
+	if self.Text == "" {
+		return fmt.Sprintf("websocket: close %d", self.Code)
+	}
+	return fmt.Sprintf("websocket: close %d %s", self.Code, self.Text)
+}
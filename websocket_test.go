@@ -0,0 +1,166 @@
+package web
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试用客户端 发送带掩码的帧
+type testWSClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTestWS(t *testing.T, addr, path string, header map[string]string) (*testWSClient, *http.Response) {
+	lConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	lReq := "GET " + path + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	for k, v := range header {
+		lReq += k + ": " + v + "\r\n"
+	}
+	lConn.Write([]byte(lReq + "\r\n"))
+
+	lClient := &testWSClient{conn: lConn, reader: bufio.NewReader(lConn)}
+	lResp, err := http.ReadResponse(lClient.reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lClient, lResp
+}
+
+func (self *testWSClient) send(opcode byte, fin bool, payload []byte) {
+	lFrame := []byte{opcode}
+	if fin {
+		lFrame[0] |= 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		lFrame = append(lFrame, 0x80|byte(len(payload)))
+	default:
+		lFrame = append(lFrame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	}
+
+	lMask := []byte{1, 2, 3, 4}
+	lFrame = append(lFrame, lMask...)
+	for i, b := range payload {
+		lFrame = append(lFrame, b^lMask[i%4])
+	}
+	self.conn.Write(lFrame)
+}
+
+func (self *testWSClient) recv(t *testing.T) (byte, []byte) {
+	var lHead [2]byte
+	if _, err := io.ReadFull(self.reader, lHead[:]); err != nil {
+		t.Fatal(err)
+	}
+	lLen := int(lHead[1] & 0x7f)
+	if lLen == 126 {
+		var lExt [2]byte
+		io.ReadFull(self.reader, lExt[:])
+		lLen = int(binary.BigEndian.Uint16(lExt[:]))
+	}
+	lPayload := make([]byte, lLen)
+	io.ReadFull(self.reader, lPayload)
+	return lHead[0] & 0x0f, lPayload
+}
+
+var testWSHeader = map[string]string{
+	"Connection":             "Upgrade",
+	"Upgrade":                "websocket",
+	"Sec-WebSocket-Version":  "13",
+	"Sec-WebSocket-Key":      "dGhlIHNhbXBsZSBub25jZQ==",
+	"Sec-WebSocket-Protocol": "chat, json",
+}
+
+func TestWebSocket(t *testing.T) {
+	m := NewModule(nil, "m")
+	m.HookBefore(nil, "/ws/(:room)", func(hd *THandler) {
+		if hd.Request.Header.Get("Authorization") != "ok" {
+			hd.Abort(http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		hd.Session().Set("uid", 1) // 升级前创建的会话随101响应写出
+	})
+	m.WebSocket("/ws/(:room)", func(conn *TConn) {
+		conn.WriteText(conn.Handler.PathParams().AsString("room") + ":" + conn.Subprotocol())
+		for {
+			lType, lData, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(lType, lData)
+		}
+	}, TWebSocketOptions{MaxMessageSize: 200, PingInterval: -1, Subprotocols: []string{"json"}})
+	router := NewRouter()
+	router.RegisterModule(m)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	// Hook拒绝时不升级
+	_, resp := dialTestWS(t, addr, "/ws/a", testWSHeader)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expect 401 but got %d", resp.StatusCode)
+	}
+
+	header := map[string]string{"Authorization": "ok"}
+	for k, v := range testWSHeader {
+		header[k] = v
+	}
+	client, resp := dialTestWS(t, addr, "/ws/a", header)
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" || resp.Header.Get("Sec-WebSocket-Protocol") != "json" {
+		t.Fatalf("unexpected handshake %d %v", resp.StatusCode, resp.Header)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Set-Cookie"), DEFAULT_SESSION_COOKIE+"=") {
+		t.Errorf("expect session cookie in the handshake but got %v", resp.Header)
+	}
+	if op, data := client.recv(t); op != WS_TEXT || string(data) != "a:json" {
+		t.Errorf("unexpected greeting %d %q", op, data)
+	}
+
+	// 分片消息
+	client.send(WS_TEXT, false, []byte("hel"))
+	client.send(WS_PING, true, []byte("p"))
+	client.send(wsContinuation, true, []byte("lo"))
+	if op, data := client.recv(t); op != WS_PONG || string(data) != "p" {
+		t.Errorf("expect pong but got %d %q", op, data)
+	}
+	if op, data := client.recv(t); op != WS_TEXT || string(data) != "hello" {
+		t.Errorf("expect echo but got %d %q", op, data)
+	}
+
+	// 超过大小限制
+	client.send(WS_BINARY, true, make([]byte, 201))
+	op, data := client.recv(t)
+	if op != WS_CLOSE || binary.BigEndian.Uint16(data) != WS_CLOSE_MESSAGE_TOO_BIG {
+		t.Errorf("expect close 1009 but got %d % x", op, data)
+	}
+
+	// 正常关闭
+	client, _ = dialTestWS(t, addr, "/ws/b", header)
+	client.recv(t)
+	client.send(WS_CLOSE, true, []byte{0x03, 0xe8})
+	op, data = client.recv(t)
+	if op != WS_CLOSE || binary.BigEndian.Uint16(data) != WS_CLOSE_NORMAL {
+		t.Errorf("expect close 1000 but got %d % x", op, data)
+	}
+
+	// 版本不支持
+	header["Sec-WebSocket-Version"] = "8"
+	_, resp = dialTestWS(t, addr, "/ws/c", header)
+	if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("expect 426 but got %d", resp.StatusCode)
+	}
+}
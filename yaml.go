@@ -0,0 +1,245 @@
+package web
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	yaml 负责把数据编码为YAML 只提供编码
+	@字段名按 yaml > json 标签 > 字段名 的顺序取得 支持 omitempty 和 -
+	@字符串在可能被误解析时使用双引号
+*/
+
+type (
+	// YAML节点 scalar/mapping/sequence 三者之一
+	tYamlNode struct {
+		scalar   string
+		mapping  []tYamlPair
+		sequence []*tYamlNode
+		kind     int
+	}
+
+	tYamlPair struct {
+		key   string
+		value *tYamlNode
+	}
+)
+
+const (
+	yamlScalar = iota
+	yamlMapping
+	yamlSequence
+)
+
+var (
+	yamlPlain    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_ ./-]*$`)
+	yamlReserved = map[string]bool{
+		"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true,
+		"null": true, "y": true, "n": true, "~": true,
+	}
+)
+
+func encodeYAML(w io.Writer, v interface{}) error {
+	lNode, err := toYamlNode(reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+
+	var lBuf strings.Builder
+	switch {
+	case lNode.kind == yamlMapping && len(lNode.mapping) > 0:
+		writeYamlMapping(&lBuf, lNode, 0, false)
+	case lNode.kind == yamlSequence && len(lNode.sequence) > 0:
+		writeYamlSequence(&lBuf, lNode, 0, false)
+	default:
+		lBuf.WriteString(yamlInline(lNode) + "\n")
+	}
+
+	_, err = io.WriteString(w, lBuf.String())
+	return err
+}
+
+func toYamlNode(aVal reflect.Value) (*tYamlNode, error) {
+	for aVal.IsValid() && (aVal.Kind() == reflect.Ptr || aVal.Kind() == reflect.Interface) {
+		if aVal.IsNil() {
+			return &tYamlNode{scalar: "null"}, nil
+		}
+		aVal = aVal.Elem()
+	}
+	if !aVal.IsValid() {
+		return &tYamlNode{scalar: "null"}, nil
+	}
+
+	if aVal.Type() == timeType {
+		return &tYamlNode{scalar: yamlString(aVal.Interface().(time.Time).Format(time.RFC3339Nano))}, nil
+	}
+	if aVal.Type().Implements(textMarshalerType) {
+		lText, err := aVal.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return &tYamlNode{scalar: yamlString(string(lText))}, nil
+	}
+
+	switch aVal.Kind() {
+	case reflect.Bool:
+		return &tYamlNode{scalar: strconv.FormatBool(aVal.Bool())}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &tYamlNode{scalar: strconv.FormatInt(aVal.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &tYamlNode{scalar: strconv.FormatUint(aVal.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		return &tYamlNode{scalar: yamlFloat(aVal.Float())}, nil
+	case reflect.String:
+		return &tYamlNode{scalar: yamlString(aVal.String())}, nil
+
+	case reflect.Slice, reflect.Array:
+		if aVal.Type().Elem().Kind() == reflect.Uint8 {
+			lBytes := make([]byte, aVal.Len())
+			reflect.Copy(reflect.ValueOf(lBytes), aVal)
+			return &tYamlNode{scalar: "!!binary " + base64.StdEncoding.EncodeToString(lBytes)}, nil
+		}
+
+		lNode := &tYamlNode{kind: yamlSequence}
+		for i := 0; i < aVal.Len(); i++ {
+			lItem, err := toYamlNode(aVal.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			lNode.sequence = append(lNode.sequence, lItem)
+		}
+		return lNode, nil
+
+	case reflect.Map:
+		lKeys := aVal.MapKeys()
+		lNames := make([]string, len(lKeys))
+		for i, k := range lKeys {
+			lNames[i] = fmt.Sprint(k.Interface())
+		}
+		lOrder := make([]int, len(lKeys))
+		for i := range lOrder {
+			lOrder[i] = i
+		}
+		sort.Slice(lOrder, func(i, j int) bool { return lNames[lOrder[i]] < lNames[lOrder[j]] })
+
+		lNode := &tYamlNode{kind: yamlMapping}
+		for _, i := range lOrder {
+			lValue, err := toYamlNode(aVal.MapIndex(lKeys[i]))
+			if err != nil {
+				return nil, err
+			}
+			lNode.mapping = append(lNode.mapping, tYamlPair{yamlString(lNames[i]), lValue})
+		}
+		return lNode, nil
+
+	case reflect.Struct:
+		lNode := &tYamlNode{kind: yamlMapping}
+		for _, f := range encodeFields(aVal.Type(), "yaml", "json") {
+			lField, err := aVal.FieldByIndexErr(f.index)
+			if err != nil { // 内嵌的nil指针
+				continue
+			}
+			if f.omitEmpty && lField.IsZero() {
+				continue
+			}
+
+			lValue, err := toYamlNode(lField)
+			if err != nil {
+				return nil, err
+			}
+			lNode.mapping = append(lNode.mapping, tYamlPair{yamlString(f.name), lValue})
+		}
+		return lNode, nil
+	}
+
+	return nil, fmt.Errorf("yaml: unsupported type %s", aVal.Type())
+}
+
+// 写出映射 aInline 为true时第一行紧跟在"- "后
+func writeYamlMapping(aBuf *strings.Builder, aNode *tYamlNode, aIndent int, aInline bool) {
+	for i, lPair := range aNode.mapping {
+		if i > 0 || !aInline {
+			aBuf.WriteString(strings.Repeat(" ", aIndent))
+		}
+		aBuf.WriteString(lPair.key + ":")
+		writeYamlValue(aBuf, lPair.value, aIndent)
+	}
+}
+
+// 写出序列
+func writeYamlSequence(aBuf *strings.Builder, aNode *tYamlNode, aIndent int, aInline bool) {
+	for i, lItem := range aNode.sequence {
+		if i > 0 || !aInline {
+			aBuf.WriteString(strings.Repeat(" ", aIndent))
+		}
+		aBuf.WriteString("-")
+		switch {
+		case lItem.kind == yamlMapping && len(lItem.mapping) > 0:
+			aBuf.WriteString(" ")
+			writeYamlMapping(aBuf, lItem, aIndent+2, true)
+		case lItem.kind == yamlSequence && len(lItem.sequence) > 0:
+			aBuf.WriteString(" ")
+			writeYamlSequence(aBuf, lItem, aIndent+2, true)
+		default:
+			aBuf.WriteString(" " + yamlInline(lItem) + "\n")
+		}
+	}
+}
+
+// 写出"key:"之后的值
+func writeYamlValue(aBuf *strings.Builder, aNode *tYamlNode, aIndent int) {
+	switch {
+	case aNode.kind == yamlMapping && len(aNode.mapping) > 0:
+		aBuf.WriteString("\n")
+		writeYamlMapping(aBuf, aNode, aIndent+2, false)
+	case aNode.kind == yamlSequence && len(aNode.sequence) > 0:
+		aBuf.WriteString("\n")
+		writeYamlSequence(aBuf, aNode, aIndent+2, false)
+	default:
+		aBuf.WriteString(" " + yamlInline(aNode) + "\n")
+	}
+}
+
+// 标量或空集合的单行形式
+func yamlInline(aNode *tYamlNode) string {
+	switch aNode.kind {
+	case yamlMapping:
+		return "{}"
+	case yamlSequence:
+		return "[]"
+	}
+	return aNode.scalar
+}
+
+// 可能被误解析为其他类型的字符串使用双引号
+func yamlString(s string) string {
+	if yamlPlain.MatchString(s) && !yamlReserved[strings.ToLower(s)] && !strings.HasSuffix(s, " ") {
+		return s
+	}
+
+	// JSON字符串是合法的YAML双引号字符串
+	lQuoted, _ := json.Marshal(s)
+	return string(lQuoted)
+}
+
+func yamlFloat(f float64) string {
+	switch {
+	case f != f:
+		return ".nan"
+	case f > 1.7976931348623157e308:
+		return ".inf"
+	case f < -1.7976931348623157e308:
+		return "-.inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}